@@ -0,0 +1,336 @@
+// Package audit writes an append-only JSON-lines log of file lifecycle events
+// (uploads, downloads, relays, upload requests, deletions, expirations, expiry changes, revocations,
+// failed authentication and configuration reloads) with size-based rotation, and supports filtered queries over
+// the retained logs.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types recorded in the audit log
+const (
+	EventUpload        = "upload"
+	EventDownload      = "download"
+	EventDelete        = "delete"
+	EventExpire        = "expire"
+	EventEvict         = "evict"
+	EventRevoke        = "revoke"
+	EventExpiryChange  = "expiry_change"
+	EventAuthFailure   = "auth_failure"
+	EventConfigReload  = "config_reload"
+	EventRelay         = "relay"
+	EventRequestCreate = "request_create"
+)
+
+// Identities attached to audit records
+const (
+	IdentityAnonymous = "anonymous"
+	IdentityUploader  = "uploader"
+	IdentityAdmin     = "admin"
+	IdentitySystem    = "system"
+)
+
+// Record is a single audit log entry
+type Record struct {
+	Time         time.Time `json:"time"`
+	Event        string    `json:"event"`
+	Success      bool      `json:"success"`
+	Identity     string    `json:"identity"`
+	ClientIP     string    `json:"clientIP,omitempty"`     // address of the connection, which clients cannot forge
+	ForwardedFor string    `json:"forwardedFor,omitempty"` // X-Forwarded-For chain or X-Real-IP of a trusted proxy
+	UserAgent    string    `json:"userAgent,omitempty"`
+	RequestID    string    `json:"requestID,omitempty"`
+	FileID       string    `json:"fileID,omitempty"`
+	FileName     string    `json:"fileName,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
+
+// Logger appends audit records to a file, rotating it when it grows beyond maxBytes.
+// A nil *Logger is valid and discards all records, which is how auditing is disabled.
+type Logger struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewLogger opens (or creates) the audit log at path. Rotated logs are kept as
+// path.1 (most recent) up to path.<maxBackups>.
+func NewLogger(path string, maxBytes int64, maxBackups int) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	l := &Logger{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the current log file for appending
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// Log appends a record, filling in the time and identity if they are missing.
+// Write failures are reported through the application log and never fail the request.
+func (l *Logger) Log(rec Record) {
+	if l == nil {
+		return
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	if rec.Identity == "" {
+		rec.Identity = IdentityAnonymous
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		slog.Error("Error encoding audit record", "event", rec.Event, "file_id", rec.FileID, "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		slog.Error("Audit log is closed, dropping record", "event", rec.Event, "file_id", rec.FileID)
+		return
+	}
+	if l.maxBytes > 0 && l.size+int64(len(line)) > l.maxBytes && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			slog.Error("Error rotating audit log", "error", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("Error writing audit record", "event", rec.Event, "file_id", rec.FileID, "error", err)
+	}
+}
+
+// rotate shifts path.N to path.N+1, moves the current file to path.1 and reopens path.
+// Must be called with l.mu held.
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		slog.Warn("Error closing audit log before rotation", "error", err)
+	}
+	l.file = nil
+
+	if l.maxBackups > 0 {
+		os.Remove(backupPath(l.path, l.maxBackups))
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(backupPath(l.path, i), backupPath(l.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to shift audit backup: %w", err)
+			}
+		}
+		if err := os.Rename(l.path, backupPath(l.path, 1)); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	} else if err := os.Truncate(l.path, 0); err != nil {
+		return fmt.Errorf("failed to truncate audit log: %w", err)
+	}
+
+	return l.open()
+}
+
+// Sync flushes the audit log to stable storage
+func (l *Logger) Sync() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Sync()
+}
+
+// Close flushes and closes the audit log; later records are dropped
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		slog.Warn("Error syncing audit log", "error", err)
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Enabled reports whether records are being written
+func (l *Logger) Enabled() bool {
+	return l != nil
+}
+
+// backupPath returns the path of the n-th rotated log
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Filter selects audit records; zero-valued fields match everything
+type Filter struct {
+	Event    string
+	FileID   string
+	ClientIP string
+	Identity string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// matches reports whether rec satisfies the filter
+func (f Filter) matches(rec Record) bool {
+	switch {
+	case f.Event != "" && rec.Event != f.Event:
+		return false
+	case f.FileID != "" && rec.FileID != f.FileID:
+		return false
+	case f.ClientIP != "" && rec.ClientIP != f.ClientIP && !forwardedBy(rec.ForwardedFor, f.ClientIP):
+		return false
+	case f.Identity != "" && rec.Identity != f.Identity:
+		return false
+	case !f.Since.IsZero() && rec.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && rec.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// forwardedBy reports whether ip is one of the addresses of a forwarded chain
+func forwardedBy(chain, ip string) bool {
+	for _, hop := range strings.Split(chain, ",") {
+		if strings.TrimSpace(hop) == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the records matching filter across the current and rotated logs,
+// oldest first. When a limit is set, the most recent matching records are returned.
+// The logs are opened under the lock so rotation cannot shift them mid-query, and read
+// after releasing it so that records keep being written meanwhile.
+func (l *Logger) Query(filter Filter) ([]Record, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	files, size, err := l.openAll()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var records []Record
+	for _, f := range files {
+		var r io.Reader = f
+		if f.Name() == l.path {
+			// Stop at the records written before the query, never at half a line
+			r = io.LimitReader(f, size)
+		}
+		records, err = readRecords(r, f.Name(), filter, records)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[len(records)-filter.Limit:]
+	}
+	return records, nil
+}
+
+// openAll opens the rotated logs, oldest first, followed by the current log,
+// and returns the size of the current log at that point
+func (l *Logger) openAll() ([]*os.File, int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	paths := make([]string, 0, l.maxBackups+1)
+	for i := l.maxBackups; i >= 1; i-- {
+		paths = append(paths, backupPath(l.path, i))
+	}
+	paths = append(paths, l.path)
+
+	files := make([]*os.File, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, 0, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		files = append(files, f)
+	}
+	return files, l.size, nil
+}
+
+// readRecords appends the matching records of a single log file to records
+func readRecords(r io.Reader, path string, filter Filter, records []Record) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			slog.Warn("Skipping malformed audit record", "path", path, "error", err)
+			continue
+		}
+		if filter.matches(rec) {
+			records = append(records, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return records, nil
+}
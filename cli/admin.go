@@ -0,0 +1,451 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/storage"
+)
+
+// adminCommands lists the subcommands of "qcus admin"
+var adminCommands = map[string]func(args []string) error{
+	"list":   runAdminList,
+	"rm":     runAdminRemove,
+	"purge":  runAdminPurge,
+	"verify": runAdminVerify,
+	"stats":  runAdminStats,
+}
+
+// runAdmin implements "qcus admin SUBCOMMAND", which works directly on the upload directory
+// and metadata index of a server on this host
+func runAdmin(args []string) error {
+	if len(args) == 0 {
+		adminUsage(os.Stderr)
+		return usageError(errors.New("no subcommand given"))
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		adminUsage(os.Stdout)
+		return nil
+	}
+	run, ok := adminCommands[args[0]]
+	if !ok {
+		adminUsage(os.Stderr)
+		return usageError(fmt.Errorf("unknown subcommand %q", args[0]))
+	}
+	return run(args[1:])
+}
+
+func adminUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: qcus admin SUBCOMMAND [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Subcommands:")
+	fmt.Fprintln(w, "  list       List stored files")
+	fmt.Fprintln(w, "  rm ID...   Delete stored files (the server must be stopped)")
+	fmt.Fprintln(w, "  purge      Delete expired or orphaned files (the server must be stopped)")
+	fmt.Fprintln(w, "  verify     Check that the index, the files on disk and their checksums agree")
+	fmt.Fprintln(w, "  stats      Show storage statistics")
+}
+
+// adminOptions locates the upload directory an admin subcommand works on
+type adminOptions struct {
+	configFile string
+	dir        string
+}
+
+// newAdminFlags creates the flag set of an admin subcommand
+func newAdminFlags(name, usage string, opts *adminOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("qcus admin "+name, flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", os.Getenv("QCUS_CONFIG"), "server configuration file (env QCUS_CONFIG)")
+	fs.StringVar(&opts.dir, "upload-dir", "", "upload directory (default: UPLOAD_DIR of the server configuration)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: qcus admin %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// uploadDir returns the directory given with --upload-dir, or else the one the server uses
+// with the same configuration file and environment
+func (opts *adminOptions) uploadDir() (string, error) {
+	if opts.dir != "" {
+		return opts.dir, nil
+	}
+	cfg, err := config.Load(&config.Options{ConfigFile: opts.configFile})
+	if err != nil {
+		return "", err
+	}
+	return cfg.UploadDir, nil
+}
+
+// lockUploadDir takes the lock the server holds while running, so that files are never
+// removed behind its back
+func lockUploadDir(dir string) (func(), error) {
+	unlock, err := storage.LockDir(dir)
+	if errors.Is(err, storage.ErrLocked) {
+		return nil, fmt.Errorf("%s is in use by a running server: stop it first, or use the admin API", dir)
+	}
+	return unlock, err
+}
+
+// runAdminList implements "qcus admin list"
+func runAdminList(args []string) error {
+	var (
+		opts    adminOptions
+		jsonOut bool
+	)
+	fs := newAdminFlags("list", "[flags]", &opts)
+	fs.BoolVar(&jsonOut, "json", false, "print the index entries as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	dir, err := opts.uploadDir()
+	if err != nil {
+		return err
+	}
+	files, err := storage.ReadIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	if jsonOut {
+		if files == nil {
+			files = []storage.StoredFile{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(files)
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSIZE\tUPLOADED\tEXPIRES\tDOWNLOADS LEFT")
+	for _, f := range files {
+		expires := f.ExpiresAt.Local().Format(time.DateTime)
+		if !now.Before(f.ExpiresAt) {
+			expires = "expired"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\n", f.ID, f.OriginalName, formatSize(f.Size),
+			f.UploadTime.Local().Format(time.DateTime), expires, f.RemainingDownloads)
+	}
+	return tw.Flush()
+}
+
+// runAdminRemove implements "qcus admin rm ID..."
+func runAdminRemove(args []string) error {
+	var opts adminOptions
+	fs := newAdminFlags("rm", "[flags] ID...", &opts)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usageError(errors.New("no file IDs given"))
+	}
+	dir, err := opts.uploadDir()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockUploadDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := storage.ReadIndex(dir)
+	if err != nil {
+		return err
+	}
+	remove := make(map[string]bool, fs.NArg())
+	for _, id := range fs.Args() {
+		remove[id] = true
+	}
+
+	var kept, removed []storage.StoredFile
+	for _, f := range files {
+		if !remove[f.ID] {
+			kept = append(kept, f)
+			continue
+		}
+		removed = append(removed, f)
+		delete(remove, f.ID)
+	}
+	// The index is saved first: data left behind by a failed removal is an orphan that purge
+	// --orphans and the server clean up, while an index listing deleted data would be restored
+	if err := storage.WriteIndex(dir, kept); err != nil {
+		return err
+	}
+	if _, err := removeUnshared(removed, kept); err != nil {
+		return err
+	}
+	for _, f := range removed {
+		fmt.Printf("Removed %s (%s)\n", f.ID, f.OriginalName)
+	}
+
+	for _, id := range fs.Args() {
+		if remove[id] {
+			fmt.Fprintf(os.Stderr, "%s: no such file\n", id)
+		}
+	}
+	if len(remove) > 0 {
+		return fmt.Errorf("%d of %d files not found", len(remove), fs.NArg())
+	}
+	return nil
+}
+
+// runAdminPurge implements "qcus admin purge"
+func runAdminPurge(args []string) error {
+	var (
+		opts    adminOptions
+		expired bool
+		orphans bool
+	)
+	fs := newAdminFlags("purge", "--expired|--orphans [flags]", &opts)
+	fs.BoolVar(&expired, "expired", false, "delete files past their expiry")
+	fs.BoolVar(&orphans, "orphans", false, "delete files missing from the index and index entries whose file is missing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !expired && !orphans {
+		fs.Usage()
+		return usageError(errors.New("nothing to purge: pass --expired and/or --orphans"))
+	}
+	dir, err := opts.uploadDir()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockUploadDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := storage.ReadIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var kept, purged []storage.StoredFile
+	for _, f := range files {
+		_, statErr := os.Stat(f.Path)
+		switch {
+		case expired && !now.Before(f.ExpiresAt):
+			fmt.Printf("Removed expired %s (%s)\n", f.ID, f.OriginalName)
+			purged = append(purged, f)
+		case orphans && errors.Is(statErr, os.ErrNotExist):
+			fmt.Printf("Dropped index entry %s (%s): file is missing\n", f.ID, f.OriginalName)
+		default:
+			kept = append(kept, f)
+		}
+	}
+	// The index is saved before the data is deleted, as in runAdminRemove
+	if err := storage.WriteIndex(dir, kept); err != nil {
+		return err
+	}
+	freed, err := removeUnshared(purged, kept)
+	if err != nil {
+		return err
+	}
+	removed := len(purged)
+
+	if orphans {
+		orphaned, err := storage.OrphanedFiles(dir, kept)
+		if err != nil {
+			return err
+		}
+		for _, name := range orphaned {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil {
+				freed += info.Size()
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			fmt.Printf("Removed orphaned file %s\n", name)
+			removed++
+		}
+	}
+
+	fmt.Printf("Purged %d files, freed %s\n", removed, formatSize(freed))
+	return nil
+}
+
+// runAdminVerify implements "qcus admin verify"
+func runAdminVerify(args []string) error {
+	var opts adminOptions
+	fs := newAdminFlags("verify", "[flags]", &opts)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	dir, err := opts.uploadDir()
+	if err != nil {
+		return err
+	}
+	files, err := storage.ReadIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	report := func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+		problems++
+	}
+
+	// Files sharing their content share a blob, which is only hashed once
+	sums := make(map[string]string)
+	for _, f := range files {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			report("%s (%s): file missing: %v", f.ID, f.OriginalName, err)
+			continue
+		}
+		if info.Size() != f.DiskSize() {
+			report("%s (%s): size mismatch: index has %d bytes, disk has %d", f.ID, f.OriginalName, f.DiskSize(), info.Size())
+			continue
+		}
+		sum, ok := sums[f.Path]
+		if !ok {
+			sum, err = dataSHA256(f)
+			if err != nil {
+				report("%s (%s): %v", f.ID, f.OriginalName, err)
+				continue
+			}
+			sums[f.Path] = sum
+		}
+		if sum != f.SHA256 {
+			report("%s (%s): checksum mismatch: index has %s, disk has %s", f.ID, f.OriginalName, f.SHA256, sum)
+		}
+	}
+
+	orphaned, err := storage.OrphanedFiles(dir, files)
+	if err != nil {
+		return err
+	}
+	for _, name := range orphaned {
+		report("%s: file not in the index", name)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problems found in %s", problems, dir)
+	}
+	fmt.Printf("Verified %d files in %s: no problems found\n", len(files), dir)
+	return nil
+}
+
+// runAdminStats implements "qcus admin stats"
+func runAdminStats(args []string) error {
+	var opts adminOptions
+	fs := newAdminFlags("stats", "[flags]", &opts)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	dir, err := opts.uploadDir()
+	if err != nil {
+		return err
+	}
+	files, err := storage.ReadIndex(dir)
+	if err != nil {
+		return err
+	}
+	orphaned, err := storage.OrphanedFiles(dir, files)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var (
+		expiredSize, orphanedSize int64
+		expired, downloads        int
+		oldest, nextExpiry        time.Time
+	)
+	for _, f := range files {
+		if !now.Before(f.ExpiresAt) {
+			expired++
+			expiredSize += f.Size
+		} else if nextExpiry.IsZero() || f.ExpiresAt.Before(nextExpiry) {
+			nextExpiry = f.ExpiresAt
+		}
+		if oldest.IsZero() || f.UploadTime.Before(oldest) {
+			oldest = f.UploadTime
+		}
+		for _, d := range f.Downloads {
+			if d.Success {
+				downloads++
+			}
+		}
+	}
+	for _, name := range orphaned {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			orphanedSize += info.Size()
+		}
+	}
+	usage := storage.UsageOf(files)
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Upload directory:\t%s\n", dir)
+	fmt.Fprintf(tw, "Stored files:\t%d (%s)\n", usage.Files, formatSize(usage.LogicalBytes))
+	fmt.Fprintf(tw, "Disk usage:\t%s in %d blobs (%s saved by deduplication, %s by compression)\n",
+		formatSize(usage.Bytes), usage.Blobs, formatSize(usage.Deduplicated), formatSize(usage.Compressed()))
+	fmt.Fprintf(tw, "Expired, not yet removed:\t%d (%s)\n", expired, formatSize(expiredSize))
+	fmt.Fprintf(tw, "Orphaned files:\t%d (%s)\n", len(orphaned), formatSize(orphanedSize))
+	fmt.Fprintf(tw, "Completed downloads:\t%d\n", downloads)
+	fmt.Fprintf(tw, "Oldest upload:\t%s\n", formatTime(oldest))
+	fmt.Fprintf(tw, "Next expiry:\t%s\n", formatTime(nextExpiry))
+	return tw.Flush()
+}
+
+// removeUnshared deletes the data of removed files from disk, except for blobs that kept
+// files share, and returns the number of bytes freed
+func removeUnshared(removed, kept []storage.StoredFile) (int64, error) {
+	done := make(map[string]bool, len(kept))
+	for _, f := range kept {
+		done[f.Path] = true
+	}
+	var freed int64
+	for _, f := range removed {
+		if done[f.Path] {
+			continue
+		}
+		done[f.Path] = true
+		if err := storage.RemoveData(f); err != nil {
+			return freed, err
+		}
+		freed += f.DiskSize()
+	}
+	return freed, nil
+}
+
+// dataSHA256 returns the hex-encoded SHA-256 of the content of a stored file, which is
+// decompressed first if it is stored compressed
+func dataSHA256(f storage.StoredFile) (string, error) {
+	data, err := storage.OpenData(f)
+	if err != nil {
+		return "", err
+	}
+	defer data.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
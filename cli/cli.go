@@ -0,0 +1,176 @@
+// Package cli implements the qcus client commands, which upload files to a QCUS server,
+// download them with resume support and wait for them to be consumed, and the admin
+// commands that inspect the upload directory of a server on the same host.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go-quick-cli-upload-server/config"
+)
+
+// Exit codes returned by Run
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// userAgent identifies the client to the server
+const userAgent = "qcus-cli"
+
+// Commands lists the commands handled by Run
+var Commands = map[string]string{
+	"upload":   "Upload files and print their download links",
+	"download": "Download a file, resuming interrupted transfers",
+	"wait":     "Wait until a file has been downloaded",
+	"admin":    "Inspect and clean up the local upload directory",
+}
+
+// Run executes a client command and returns the process exit code
+func Run(command string, args []string) int {
+	var err error
+	switch command {
+	case "upload":
+		err = runUpload(args)
+	case "download":
+		err = runDownload(args)
+	case "wait":
+		err = runWait(args)
+	case "admin":
+		err = runAdmin(args)
+	default:
+		err = usageError(fmt.Errorf("unknown command %q", command))
+	}
+
+	var usage *usageErr
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		fmt.Fprintf(os.Stderr, "qcus %s: %v\n", command, usage.err)
+		return exitUsage
+	default:
+		fmt.Fprintf(os.Stderr, "qcus %s: %v\n", command, err)
+		return exitFailure
+	}
+}
+
+// usageErr marks an error caused by invalid command-line usage
+type usageErr struct {
+	err error
+}
+
+func (e *usageErr) Error() string { return e.err.Error() }
+
+func usageError(err error) error {
+	return &usageErr{err: err}
+}
+
+// clientConfig holds the server URL and credentials shared by the client commands
+type clientConfig struct {
+	Server   string
+	Password string
+}
+
+// clientConfigPath returns the per-user configuration file, ~/.config/qcus/config.toml on Linux
+func clientConfigPath() string {
+	if p := os.Getenv("QCUS_CLIENT_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "qcus", "config.toml")
+}
+
+// registerClientFlags adds the flags shared by all client commands
+func registerClientFlags(flags *flag.FlagSet, cfg *clientConfig) {
+	flags.StringVar(&cfg.Server, "server", "", "server URL (env QCUS_SERVER, or server in the client config file)")
+	flags.StringVar(&cfg.Password, "password", "", "upload password (env QCUS_PASSWORD, or password in the client config file)")
+}
+
+// loadClientConfig fills the settings not given as flags from the environment, then from the
+// client configuration file
+func loadClientConfig(cfg *clientConfig) error {
+	var file map[string]string
+	if p := clientConfigPath(); p != "" {
+		values, err := config.ReadTOMLFile(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to read client config: %w", err)
+		}
+		file = values
+	}
+
+	pick := func(flagValue, env, key string) string {
+		if flagValue != "" {
+			return flagValue
+		}
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+		return file[key]
+	}
+	cfg.Server = strings.TrimRight(pick(cfg.Server, "QCUS_SERVER", "server"), "/")
+	cfg.Password = pick(cfg.Password, "QCUS_PASSWORD", "password")
+	return nil
+}
+
+// requireServer checks that a server URL is configured
+func (cfg *clientConfig) requireServer() error {
+	if cfg.Server == "" {
+		return usageError(fmt.Errorf("no server configured: pass --server, set QCUS_SERVER or add server to %s", clientConfigPath()))
+	}
+	if _, err := url.ParseRequestURI(cfg.Server); err != nil {
+		return usageError(fmt.Errorf("invalid server URL %q", cfg.Server))
+	}
+	return nil
+}
+
+// newRequest creates a request carrying the client's user agent
+func newRequest(method, target string) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// fileReference resolves a file ID or download URL to the file ID and its download URL
+func fileReference(cfg *clientConfig, ref string) (id, downloadURL string, err error) {
+	if strings.Contains(ref, "://") {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", "", usageError(fmt.Errorf("invalid URL %q", ref))
+		}
+		return path.Base(u.Path), ref, nil
+	}
+	if err := cfg.requireServer(); err != nil {
+		return "", "", err
+	}
+	return ref, cfg.Server + "/download/" + url.PathEscape(ref), nil
+}
+
+// responseError turns an unexpected HTTP response into an error with the server's message
+func responseError(resp *http.Response) error {
+	body := make([]byte, 512)
+	n, _ := resp.Body.Read(body)
+	msg := strings.TrimSpace(string(body[:n]))
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return fmt.Errorf("server returned %s: %s", resp.Status, msg)
+}
@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// permanentError marks a download failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// runDownload implements "qcus download URL|ID"
+func runDownload(args []string) error {
+	var (
+		cfg     clientConfig
+		output  string
+		force   bool
+		retries int
+	)
+	fs := flag.NewFlagSet("qcus download", flag.ContinueOnError)
+	registerClientFlags(fs, &cfg)
+	fs.StringVar(&output, "o", "", "output file (default: the uploaded file name)")
+	fs.BoolVar(&force, "force", false, "overwrite an existing output file")
+	fs.IntVar(&retries, "retries", 3, "times an interrupted download is resumed before giving up")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: qcus download [flags] URL|ID")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError(errors.New("expected exactly one URL or file ID"))
+	}
+	if err := loadClientConfig(&cfg); err != nil {
+		return err
+	}
+	id, target, err := fileReference(&cfg, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Check the output name before downloading, as every completed GET uses up a download
+	name := output
+	if name == "" {
+		if name, err = remoteName(target, id); err != nil {
+			return err
+		}
+	}
+	if !force {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("%s already exists (use --force to overwrite or -o to choose another name)", name)
+		}
+	}
+
+	// Partial data is kept next to the output so that running the command again resumes it
+	partPath := filepath.Join(filepath.Dir(name), ".qcus-"+id+".part")
+
+	for attempt := 0; ; attempt++ {
+		size, err := downloadOnce(target, partPath, name)
+		if err == nil {
+			fmt.Printf("Saved %s (%s)\n", name, formatSize(size))
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return err
+		}
+		if attempt >= retries {
+			return fmt.Errorf("%w (partial data kept in %s, run the command again to resume)", err, partPath)
+		}
+		fmt.Fprintf(os.Stderr, "Download interrupted: %v, resuming...\n", err)
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+// remoteName asks the server for the file's name with a HEAD request, which does not count
+// as a download
+func remoteName(target, id string) (string, error) {
+	req, err := newRequest(http.MethodHead, target)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return attachmentName(resp, id), nil
+	case http.StatusNotFound:
+		return "", errNotFound
+	default:
+		return "", fmt.Errorf("server returned %s", resp.Status)
+	}
+}
+
+// errNotFound is returned for files that no longer exist on the server
+var errNotFound = errors.New("file not found: it may have been downloaded already or expired")
+
+// downloadOnce fetches the file into partPath, continuing from the data already there, and
+// moves it to name once complete
+func downloadOnce(target, partPath, name string) (size int64, err error) {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := newRequest(http.MethodGet, target)
+	if err != nil {
+		return 0, &permanentError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return 0, &permanentError{fmt.Errorf("server resumed at an unexpected offset (%s)", resp.Header.Get("Content-Range"))}
+		}
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial data does not match the file on the server: start over
+		os.Remove(partPath)
+		return 0, errors.New("partial data does not match the server's file")
+	case http.StatusNotFound:
+		return 0, &permanentError{errNotFound}
+	default:
+		return 0, &permanentError{responseError(resp)}
+	}
+
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return 0, &permanentError{err}
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	bar := newProgress(filepath.Base(name), total, offset)
+	written, copyErr := io.Copy(bar.writer(f), resp.Body)
+	bar.finish()
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return 0, copyErr
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return 0, fmt.Errorf("received %d of %d bytes", written, resp.ContentLength)
+	}
+
+	if err := verifyETag(partPath, resp.Header.Get("ETag")); err != nil {
+		os.Remove(partPath)
+		return 0, &permanentError{err}
+	}
+	if err := os.Rename(partPath, name); err != nil {
+		return 0, &permanentError{err}
+	}
+	return offset + written, nil
+}
+
+// attachmentName returns the file name from Content-Disposition, falling back to the file ID
+func attachmentName(resp *http.Response, id string) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(params["filename"]); name != "." && name != "/" && name != "" {
+			return name
+		}
+	}
+	return id
+}
+
+// verifyETag checks a completed download against the SHA-256 the server uses as ETag, which
+// catches resumed downloads whose parts do not fit together
+func verifyETag(path, etag string) error {
+	// The tag of gzip-encoded responses has a suffix; the transport has decompressed them
+	expected := strings.TrimSuffix(strings.Trim(etag, `"`), "-gzip")
+	if len(expected) != sha256.Size*2 {
+		return nil
+	}
+
+	actual, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch: expected SHA-256 %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
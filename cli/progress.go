@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressBarWidth is the number of cells in the progress bar
+const progressBarWidth = 30
+
+// progress renders a transfer progress bar on stderr when it is a terminal
+type progress struct {
+	label    string
+	total    int64
+	done     int64
+	start    time.Time
+	lastDraw time.Time
+	enabled  bool
+}
+
+// newProgress creates a progress bar for a transfer of total bytes (unknown when negative)
+// that already has done bytes, as when resuming a download
+func newProgress(label string, total, done int64) *progress {
+	return &progress{
+		label:   label,
+		total:   total,
+		done:    done,
+		start:   time.Now(),
+		enabled: isTerminal(os.Stderr),
+	}
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// reader wraps r so that reads advance the progress bar
+func (p *progress) reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+// writer wraps w so that writes advance the progress bar
+func (p *progress) writer(w io.Writer) io.Writer {
+	return &progressWriter{w: w, p: p}
+}
+
+func (p *progress) add(n int) {
+	p.done += int64(n)
+	if now := time.Now(); now.Sub(p.lastDraw) >= 100*time.Millisecond {
+		p.lastDraw = now
+		p.draw()
+	}
+}
+
+func (p *progress) draw() {
+	if !p.enabled {
+		return
+	}
+
+	rate := ""
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = formatSize(int64(float64(p.done)/elapsed)) + "/s"
+	}
+
+	if p.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%s  %s  %s\033[K", p.label, formatSize(p.done), rate)
+		return
+	}
+
+	fraction := float64(p.done) / float64(p.total)
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	fmt.Fprintf(os.Stderr, "\r%s  [%s] %3.0f%%  %s / %s  %s\033[K",
+		p.label, bar, fraction*100, formatSize(p.done), formatSize(p.total), rate)
+}
+
+// finish draws the final state and ends the progress line
+func (p *progress) finish() {
+	if !p.enabled {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.add(n)
+	return n, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.add(n)
+	return n, err
+}
+
+// formatSize formats bytes into human-readable format
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
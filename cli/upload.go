@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mdp/qrterminal/v3"
+)
+
+// uploadResult is the JSON response of the server to an upload
+type uploadResult struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	URL          string    `json:"url"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	MaxDownloads int       `json:"maxDownloads"`
+	// MetadataRemoved is the size of the EXIF and other metadata stripped from an image
+	MetadataRemoved int64 `json:"metadataRemoved,omitempty"`
+}
+
+// runUpload implements "qcus upload FILE..."
+func runUpload(args []string) error {
+	var (
+		cfg       clientConfig
+		expiry    int
+		downloads int
+		noQR      bool
+		jsonOut   bool
+		strip     bool
+	)
+	fs := flag.NewFlagSet("qcus upload", flag.ContinueOnError)
+	registerClientFlags(fs, &cfg)
+	fs.IntVar(&expiry, "expiry", 0, "minutes before the files expire (server default when 0)")
+	fs.IntVar(&downloads, "downloads", 0, "number of times each file may be downloaded (1 when 0)")
+	fs.BoolVar(&strip, "strip-metadata", false, "remove EXIF and other metadata from JPEG and PNG files (server default when not given)")
+	fs.BoolVar(&noQR, "no-qr", false, "do not print QR codes")
+	fs.BoolVar(&jsonOut, "json", false, "print the server responses as JSON lines")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: qcus upload [flags] FILE...")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usageError(errors.New("no files given"))
+	}
+	if expiry < 0 || downloads < 0 {
+		return usageError(errors.New("--expiry and --downloads must not be negative"))
+	}
+	if err := loadClientConfig(&cfg); err != nil {
+		return err
+	}
+	if err := cfg.requireServer(); err != nil {
+		return err
+	}
+
+	// Only an explicit --strip-metadata overrides the server default, in either direction
+	stripMetadata := ""
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "strip-metadata" {
+			stripMetadata = strconv.FormatBool(strip)
+		}
+	})
+
+	failed := 0
+	for _, name := range fs.Args() {
+		result, err := uploadFile(&cfg, name, expiry, downloads, stripMetadata)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed++
+			continue
+		}
+
+		if jsonOut {
+			if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+				return err
+			}
+			continue
+		}
+		printUploadResult(result, !noQR)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, fs.NArg())
+	}
+	return nil
+}
+
+// uploadFile streams a file to the server as a raw PUT, showing its progress. stripMetadata is
+// sent as X-Strip-Metadata unless empty.
+func uploadFile(cfg *clientConfig, name string, expiry, downloads int, stripMetadata string) (uploadResult, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return uploadResult{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return uploadResult{}, err
+	}
+	if info.IsDir() {
+		return uploadResult{}, errors.New("is a directory")
+	}
+
+	bar := newProgress(filepath.Base(name), info.Size(), 0)
+	req, err := newRequest(http.MethodPut, cfg.Server+"/")
+	if err != nil {
+		return uploadResult{}, err
+	}
+	// Hash what is sent to check that the server stored the same bytes
+	hasher := sha256.New()
+	req.Body = readCloser{Reader: io.TeeReader(bar.reader(f), hasher), Closer: f}
+	req.ContentLength = info.Size()
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-File-Name", url.PathEscape(filepath.Base(name)))
+	req.Header.Set("X-Upload-Password", cfg.Password)
+	if expiry > 0 {
+		req.Header.Set("X-Expiry-Minutes", strconv.Itoa(expiry))
+	}
+	if downloads > 0 {
+		req.Header.Set("X-Max-Downloads", strconv.Itoa(downloads))
+	}
+	if stripMetadata != "" {
+		req.Header.Set("X-Strip-Metadata", stripMetadata)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	bar.finish()
+	if err != nil {
+		return uploadResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return uploadResult{}, responseError(resp)
+	}
+
+	var result uploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return uploadResult{}, fmt.Errorf("unexpected server response: %w", err)
+	}
+	// Stripping metadata changes the stored bytes on purpose
+	if sent := hex.EncodeToString(hasher.Sum(nil)); result.MetadataRemoved == 0 && result.SHA256 != sent {
+		return result, fmt.Errorf("checksum mismatch: sent SHA-256 %s, server stored %s", sent, result.SHA256)
+	}
+	return result, nil
+}
+
+// printUploadResult prints the download link of an upload, with a QR code when requested
+func printUploadResult(result uploadResult, withQR bool) {
+	if withQR && isTerminal(os.Stdout) {
+		qrterminal.GenerateWithConfig(result.URL, qrterminal.Config{
+			Level:     qrterminal.L,
+			Writer:    os.Stdout,
+			BlackChar: qrterminal.BLACK,
+			WhiteChar: qrterminal.WHITE,
+			QuietZone: 1,
+		})
+	}
+
+	fmt.Printf("%s (%s)\n", result.Name, formatSize(result.Size))
+	fmt.Printf("  URL:       %s\n", result.URL)
+	fmt.Printf("  Expires:   %s\n", result.ExpiresAt.Local().Format(time.DateTime))
+	if result.MaxDownloads > 1 {
+		fmt.Printf("  Downloads: %d\n", result.MaxDownloads)
+	}
+	if result.MetadataRemoved > 0 {
+		fmt.Printf("  Metadata:  %s stripped\n", formatSize(result.MetadataRemoved))
+	}
+	fmt.Printf("  SHA-256:   %s\n", result.SHA256)
+}
+
+// readCloser combines a progress-tracking reader with the file's Close
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
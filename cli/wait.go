@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fileEvent is a notification sent by the server over the file's WebSocket
+type fileEvent struct {
+	Downloaded bool      `json:"downloaded"`
+	Event      string    `json:"event"`
+	FileID     string    `json:"fileID"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Set for files received through an upload request
+	File *struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+		URL  string `json:"url"`
+	} `json:"file"`
+	Remaining int `json:"remaining"`
+}
+
+// runWait implements "qcus wait URL|ID"
+func runWait(args []string) error {
+	var (
+		cfg     clientConfig
+		timeout time.Duration
+	)
+	fs := flag.NewFlagSet("qcus wait", flag.ContinueOnError)
+	registerClientFlags(fs, &cfg)
+	fs.DurationVar(&timeout, "timeout", 0, "give up after this long, e.g. 10m (no limit when 0)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: qcus wait [flags] URL|ID")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError(errors.New("expected exactly one URL or file ID"))
+	}
+	if timeout < 0 {
+		return usageError(errors.New("--timeout must not be negative"))
+	}
+	if err := loadClientConfig(&cfg); err != nil {
+		return err
+	}
+	id, downloadURL, err := fileReference(&cfg, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	wsURL, err := websocketURL(downloadURL, id)
+	if err != nil {
+		return err
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, map[string][]string{"User-Agent": {userAgent}})
+	if err != nil {
+		if resp != nil {
+			return responseError(resp)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	for {
+		var event fileEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return fmt.Errorf("file %s was not downloaded within %s", id, timeout)
+			}
+			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				return errors.New("server is shutting down")
+			}
+			return fmt.Errorf("connection lost: %w", err)
+		}
+
+		switch {
+		case event.Downloaded:
+			fmt.Printf("File %s was downloaded\n", id)
+			return nil
+		case event.Event == "not-found":
+			return fmt.Errorf("file %s not found: the ID is wrong, or the file was removed over an hour ago", id)
+		case event.Event == "expiry-changed":
+			fmt.Printf("File %s now expires at %s\n", id, event.ExpiresAt.Local().Format(time.DateTime))
+		case event.Event == "relay-started":
+			fmt.Printf("Relay %s: receiver connected, transfer started\n", id)
+		case event.Event == "file-received" && event.File != nil:
+			fmt.Printf("Received %s (%s): %s\n", event.File.Name, formatSize(event.File.Size), event.File.URL)
+			if event.Remaining <= 0 {
+				return nil
+			}
+		case event.Event == "closed":
+			fmt.Printf("Upload request link expired, it accepts no more files\n")
+			return nil
+		case event.Event != "":
+			return fmt.Errorf("file %s was removed before being downloaded (%s)", id, event.Event)
+		}
+	}
+}
+
+// websocketURL derives the notification endpoint of a file from its download URL
+func websocketURL(downloadURL, id string) (string, error) {
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return "", usageError(fmt.Errorf("unsupported URL scheme %q", u.Scheme))
+	}
+	u.Path = "/ws/" + id
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String(), nil
+}
@@ -1,61 +1,249 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"mime"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"go-quick-cli-upload-server/logging"
 )
 
 // Config holds all server configuration settings
 type Config struct {
 	UploadDir         string
 	UploadPassword    string
+	AdminPassword     string
 	MaxFileSizeMB     int
 	FileExpiryMinutes int
-	Port              string
+	MaxExpiryMinutes  int
+	MaxDownloads      int
+	MaxPasteSizeKB    int
+	StripMetadata     bool
+	CompressUploads   bool
+	StorageQuotaMB    int
+	ClientQuotaMB     int
+	MinFreeSpaceMB    int
+	EvictOnLowSpace   bool
+	Listen            []ListenAddr
+	TrustedProxies    []netip.Prefix
 	IsDefaultPassword bool
+	MetricsUsername   string
+	MetricsPassword   string
+	MetricsAllowedIPs []netip.Prefix
+	LogFormat         string
+	LogLevel          slog.Level
+	AuditLogPath      string
+	AuditLogMaxSizeMB int
+	AuditLogBackups   int
+	ShutdownGraceSecs int
+
+	ReadHeaderTimeoutSecs int
+	IdleTimeoutSecs       int
+	MaxHeaderBytes        int
+	MaxConnsPerIP         int
+	MinUploadRateKBps     int
+	UploadRateWindowSecs  int
+	UploadStallSecs       int
+	RelayMaxSizeMB        int
+	RelayWaitSecs         int
+	PreviewTypes          []string // media types shown inline, exactly or as type/*; see PreviewAllowed
+
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSSelfSigned         bool
+	TLSHosts              []string
+	TLSRedirectAddr       string
+	TLSReloadIntervalSecs int
+}
+
+// Options control how the configuration is loaded; they come from the command line
+type Options struct {
+	ConfigFile  string
+	PrintConfig bool
+	overrides   map[string]sourceValue
+}
+
+// ParseFlags parses the command-line arguments. Every setting has a flag named after its
+// environment variable, e.g. --max-file-size-mb for MAX_FILE_SIZE_MB.
+func ParseFlags(name string, args []string) (*Options, error) {
+	opts := &Options{overrides: make(map[string]sourceValue)}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.ConfigFile, "config", os.Getenv("QCUS_CONFIG"), "path of a TOML configuration file (env QCUS_CONFIG)")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.flagName()] = fs.String(s.flagName(), "", fmt.Sprintf("%s (env %s)", s.Usage, s.Env))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flagName() == f.Name {
+				opts.overrides[s.Env] = sourceValue{raw: *flagValues[f.Name], origin: "flag --" + f.Name}
+			}
+		}
+	})
+	return opts, nil
 }
 
-// LoadFromEnv loads configuration from environment variables with sensible defaults
-func LoadFromEnv() (*Config, error) {
-	uploadPassword := os.Getenv("UPLOAD_PASSWORD")
+// Load builds the configuration from defaults, the configuration file, environment variables
+// and command-line flags, each overriding the previous one. All invalid settings are reported at once.
+func Load(opts *Options) (*Config, error) {
+	var errs []error
+	var fileValues map[string]sourceValue
+	if opts.ConfigFile != "" {
+		fileValues, errs = readConfigFile(opts.ConfigFile)
+	}
+	src := newSource(fileValues, opts.overrides)
+
+	uploadPassword := src.str("UPLOAD_PASSWORD", "")
 	isDefaultPassword := uploadPassword == "" || uploadPassword == "demo"
 	if uploadPassword == "" {
 		uploadPassword = "demo"
 	}
 
 	cfg := &Config{
-		UploadDir:         "./uploads",
+		UploadDir:         src.str("UPLOAD_DIR", "./uploads"),
 		UploadPassword:    uploadPassword,
-		MaxFileSizeMB:     getIntEnvOrDefault("MAX_FILE_SIZE_MB", 100),
-		FileExpiryMinutes: getIntEnvOrDefault("FILE_EXPIRY_MINUTES", 10),
-		Port:              getEnvOrDefault("PORT", "8088"),
+		AdminPassword:     src.str("ADMIN_PASSWORD", ""),
+		MaxFileSizeMB:     src.positiveInt("MAX_FILE_SIZE_MB", 100),
+		FileExpiryMinutes: src.positiveInt("FILE_EXPIRY_MINUTES", 10),
+		MaxExpiryMinutes:  src.positiveInt("MAX_EXPIRY_MINUTES", 1440),
+		MaxDownloads:      src.positiveInt("MAX_DOWNLOADS", 100),
+		MaxPasteSizeKB:    src.positiveInt("MAX_PASTE_SIZE_KB", 1024),
+		StripMetadata:     src.boolean("STRIP_METADATA", false),
+		CompressUploads:   src.boolean("COMPRESS_UPLOADS", true),
+		StorageQuotaMB:    src.nonNegativeInt("STORAGE_QUOTA_MB", 0),
+		ClientQuotaMB:     src.nonNegativeInt("CLIENT_QUOTA_MB", 0),
+		MinFreeSpaceMB:    src.nonNegativeInt("MIN_FREE_SPACE_MB", 100),
+		EvictOnLowSpace:   src.boolean("EVICT_ON_LOW_SPACE", false),
 		IsDefaultPassword: isDefaultPassword,
 	}
 
-	if cfg.Port != "" && cfg.Port[0] != ':' {
-		cfg.Port = ":" + cfg.Port
+	cfg.MetricsUsername = src.str("METRICS_USERNAME", "")
+	cfg.MetricsPassword = src.str("METRICS_PASSWORD", "")
+	if allowedIPs, err := parsePrefixList(src.str("METRICS_ALLOWED_IPS", "")); err != nil {
+		src.invalid("METRICS_ALLOWED_IPS", fmt.Sprintf("IP addresses or CIDR ranges (%v)", err))
+	} else {
+		cfg.MetricsAllowedIPs = allowedIPs
 	}
 
+	if trusted, err := parsePrefixList(src.str("TRUSTED_PROXIES", "")); err != nil {
+		src.invalid("TRUSTED_PROXIES", fmt.Sprintf("IP addresses or CIDR ranges (%v)", err))
+	} else {
+		cfg.TrustedProxies = trusted
+	}
+
+	cfg.AuditLogPath = src.str("AUDIT_LOG", "")
+	cfg.AuditLogMaxSizeMB = src.positiveInt("AUDIT_LOG_MAX_SIZE_MB", 10)
+	cfg.AuditLogBackups = src.positiveInt("AUDIT_LOG_BACKUPS", 5)
+
+	cfg.ShutdownGraceSecs = src.positiveInt("SHUTDOWN_GRACE_SECONDS", 30)
+
+	cfg.ReadHeaderTimeoutSecs = src.positiveInt("READ_HEADER_TIMEOUT_SECONDS", 10)
+	cfg.IdleTimeoutSecs = src.positiveInt("IDLE_TIMEOUT_SECONDS", 120)
+	cfg.MaxHeaderBytes = src.positiveInt("MAX_HEADER_BYTES", 64<<10)
+	cfg.MaxConnsPerIP = src.nonNegativeInt("MAX_CONNS_PER_IP", 0)
+	cfg.MinUploadRateKBps = src.nonNegativeInt("MIN_UPLOAD_RATE_KBPS", 0)
+	cfg.UploadRateWindowSecs = src.positiveInt("UPLOAD_RATE_WINDOW_SECONDS", 60)
+	cfg.UploadStallSecs = src.nonNegativeInt("UPLOAD_STALL_TIMEOUT_SECONDS", 60)
+
+	cfg.RelayMaxSizeMB = src.positiveInt("RELAY_MAX_SIZE_MB", 10240)
+	cfg.RelayWaitSecs = src.positiveInt("RELAY_WAIT_SECONDS", 300)
+
+	if previewTypes, err := parsePreviewTypes(src.str("PREVIEW_TYPES", DefaultPreviewTypes)); err != nil {
+		src.invalid("PREVIEW_TYPES", fmt.Sprintf("media types such as image/png or image/*, or none (%v)", err))
+	} else {
+		cfg.PreviewTypes = previewTypes
+	}
+
+	cfg.TLSCertFile = src.str("TLS_CERT_FILE", "")
+	cfg.TLSKeyFile = src.str("TLS_KEY_FILE", "")
+	cfg.TLSSelfSigned = src.boolean("TLS_SELF_SIGNED", false)
+	cfg.TLSHosts = splitList(src.str("TLS_HOSTS", "localhost"))
+	cfg.TLSRedirectAddr = src.str("TLS_REDIRECT_ADDR", "")
+	cfg.TLSReloadIntervalSecs = src.nonNegativeInt("TLS_RELOAD_INTERVAL_SECONDS", 60)
+
+	cfg.LogFormat = strings.ToLower(src.str("LOG_FORMAT", logging.FormatText))
+	if level, err := logging.ParseLevel(src.str("LOG_LEVEL", "info")); err != nil {
+		src.invalid("LOG_LEVEL", "debug, info, warn or error")
+	} else {
+		cfg.LogLevel = level
+	}
+
+	// LISTEN supersedes PORT, which is kept as a shorthand for a single TCP port
+	listenKey := "LISTEN"
+	listen, ok := src.lookup(listenKey)
+	if !ok {
+		listenKey = "PORT"
+		listen = "tcp://:" + strings.TrimPrefix(src.str("PORT", "8088"), ":")
+	}
+	listenAddrs, err := parseListenAddrs(listen)
+	if err != nil {
+		src.invalid(listenKey, fmt.Sprintf("listen addresses (%v)", err))
+		listenAddrs, _ = parseListenAddrs("tcp://:8088")
+	}
+	cfg.Listen = listenAddrs
+
+	errs = append(errs, src.errs...)
 	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
 	}
 
 	return cfg, nil
 }
 
-// Validate checks that all configuration values are valid
+// Validate checks that all configuration values are valid, reporting every problem found
 func (c *Config) Validate() error {
+	var errs []error
 	if c.UploadPassword == "" {
-		return fmt.Errorf("upload password cannot be empty")
+		errs = append(errs, fmt.Errorf("upload password cannot be empty"))
+	}
+	if c.AdminPassword != "" && c.AdminPassword == c.UploadPassword {
+		errs = append(errs, fmt.Errorf("admin password must differ from the upload password"))
+	}
+	if (c.MetricsUsername == "") != (c.MetricsPassword == "") {
+		errs = append(errs, fmt.Errorf("metrics username and password must be set together"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, fmt.Errorf("TLS certificate and key files must be set together"))
+	}
+	if c.TLSRedirectAddr != "" && !c.TLSEnabled() {
+		errs = append(errs, fmt.Errorf("HTTP-to-HTTPS redirect requires TLS to be enabled"))
+	}
+	if len(c.Listen) == 0 {
+		errs = append(errs, fmt.Errorf("at least one listen address is required"))
+	}
+	if !logging.ValidFormat(c.LogFormat) {
+		errs = append(errs, fmt.Errorf("log format must be %q or %q, got %q", logging.FormatText, logging.FormatJSON, c.LogFormat))
 	}
 	if c.MaxFileSizeMB <= 0 {
-		return fmt.Errorf("max file size must be positive, got %d", c.MaxFileSizeMB)
+		errs = append(errs, fmt.Errorf("max file size must be positive, got %d", c.MaxFileSizeMB))
 	}
 	if c.FileExpiryMinutes <= 0 {
-		return fmt.Errorf("file expiry minutes must be positive, got %d", c.FileExpiryMinutes)
+		errs = append(errs, fmt.Errorf("file expiry minutes must be positive, got %d", c.FileExpiryMinutes))
 	}
-	return nil
+	if c.MaxExpiryMinutes < c.FileExpiryMinutes {
+		errs = append(errs, fmt.Errorf("max expiry minutes (%d) cannot be lower than file expiry minutes (%d)", c.MaxExpiryMinutes, c.FileExpiryMinutes))
+	}
+	return errors.Join(errs...)
 }
 
 // MaxFileBytes returns the maximum file size in bytes
@@ -63,31 +251,293 @@ func (c *Config) MaxFileBytes() int64 {
 	return int64(c.MaxFileSizeMB) << 20 // Convert MB to bytes
 }
 
+// StorageQuotaBytes returns how many bytes all stored files may take on disk (zero is unlimited)
+func (c *Config) StorageQuotaBytes() int64 {
+	return int64(c.StorageQuotaMB) << 20
+}
+
+// ClientQuotaBytes returns how many bytes of stored files one client may have (zero is unlimited)
+func (c *Config) ClientQuotaBytes() int64 {
+	return int64(c.ClientQuotaMB) << 20
+}
+
+// MinFreeBytes returns how much free space to keep on the volume of the upload directory
+func (c *Config) MinFreeBytes() int64 {
+	return int64(c.MinFreeSpaceMB) << 20
+}
+
+// MaxPasteBytes returns the maximum size of a text paste in bytes
+func (c *Config) MaxPasteBytes() int64 {
+	return int64(c.MaxPasteSizeKB) << 10
+}
+
+// RelayMaxBytes returns the maximum size of a relayed transfer in bytes
+func (c *Config) RelayMaxBytes() int64 {
+	return int64(c.RelayMaxSizeMB) << 20
+}
+
+// RelayWait returns how long one side of a relay waits for the other to join
+func (c *Config) RelayWait() time.Duration {
+	return time.Duration(c.RelayWaitSecs) * time.Second
+}
+
+// MaxLifetime returns the longest total lifetime a file may be given through expiry changes
+func (c *Config) MaxLifetime() time.Duration {
+	return time.Duration(c.MaxExpiryMinutes) * time.Minute
+}
+
+// PreviewAllowed reports whether files of mediaType may be shown inline in the browser. It
+// only narrows what the server considers safe to show: active content such as HTML or SVG is
+// never shown inline, whatever the preview types allow.
+func (c *Config) PreviewAllowed(mediaType string) bool {
+	for _, pattern := range c.PreviewTypes {
+		if pattern == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminEnabled reports whether the admin API and dashboard are available
+func (c *Config) AdminEnabled() bool {
+	return c.AdminPassword != ""
+}
+
 // LogSummary logs the configuration (without sensitive data)
 func (c *Config) LogSummary() []string {
 	return []string{
 		fmt.Sprintf("Password: %s", "***"), // Don't log actual password
+		fmt.Sprintf("Admin interface: %s", enabledString(c.AdminEnabled())),
 		fmt.Sprintf("Max file size: %d MB", c.MaxFileSizeMB),
 		fmt.Sprintf("File expiry: %d minutes", c.FileExpiryMinutes),
-		fmt.Sprintf("Port: %s", c.Port),
+		fmt.Sprintf("Max expiry: %d minutes", c.MaxExpiryMinutes),
+		fmt.Sprintf("Max downloads per file: %d", c.MaxDownloads),
+		fmt.Sprintf("Max paste size: %d KB", c.MaxPasteSizeKB),
+		fmt.Sprintf("Strip image metadata: %s", enabledString(c.StripMetadata)),
+		fmt.Sprintf("Compress uploads at rest: %s", enabledString(c.CompressUploads)),
+		fmt.Sprintf("Storage quota: %s, per client %s", formatMBLimit(c.StorageQuotaMB), formatMBLimit(c.ClientQuotaMB)),
+		fmt.Sprintf("Minimum free space: %d MB (evict soonest expiring files: %s)", c.MinFreeSpaceMB, enabledString(c.EvictOnLowSpace)),
+		fmt.Sprintf("Listen: %s", formatListenAddrs(c.Listen)),
+		fmt.Sprintf("Trusted proxies: %s", c.trustedProxiesSummary()),
+		fmt.Sprintf("Metrics basic auth: %s", enabledString(c.MetricsUsername != "")),
+		fmt.Sprintf("Metrics allowed IPs: %s", formatPrefixList(c.MetricsAllowedIPs)),
 		fmt.Sprintf("Upload directory: %s", c.UploadDir),
+		fmt.Sprintf("Logging: %s (level %s)", c.LogFormat, c.LogLevel),
+		fmt.Sprintf("Audit log: %s", c.auditSummary()),
+		fmt.Sprintf("Shutdown grace period: %d seconds", c.ShutdownGraceSecs),
+		fmt.Sprintf("Timeouts: read header %ds, idle %ds", c.ReadHeaderTimeoutSecs, c.IdleTimeoutSecs),
+		fmt.Sprintf("Minimum upload rate: %s", c.minRateSummary()),
+		fmt.Sprintf("Upload stall timeout: %s", c.stallSummary()),
+		fmt.Sprintf("Max header bytes: %d", c.MaxHeaderBytes),
+		fmt.Sprintf("Max connections per IP: %s", formatLimit(c.MaxConnsPerIP)),
+		fmt.Sprintf("Relay: max %d MB, wait %d seconds", c.RelayMaxSizeMB, c.RelayWaitSecs),
+		fmt.Sprintf("Preview types: %s", previewTypesString(c.PreviewTypes)),
+		fmt.Sprintf("TLS: %s", c.tlsSummary()),
 	}
 }
 
-// getEnvOrDefault returns environment variable value or default if not set
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// TLSEnabled reports whether the server serves HTTPS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSSelfSigned || c.TLSCertFile != ""
+}
+
+// TLSReloadInterval returns how often certificate files are checked for changes (zero disables polling)
+func (c *Config) TLSReloadInterval() time.Duration {
+	return time.Duration(c.TLSReloadIntervalSecs) * time.Second
+}
+
+// ShutdownGracePeriod returns how long in-flight transfers may run after a shutdown signal
+func (c *Config) ShutdownGracePeriod() time.Duration {
+	return time.Duration(c.ShutdownGraceSecs) * time.Second
+}
+
+// ReadHeaderTimeout returns how long a client may take to send request headers
+func (c *Config) ReadHeaderTimeout() time.Duration {
+	return time.Duration(c.ReadHeaderTimeoutSecs) * time.Second
+}
+
+// IdleTimeout returns how long an idle keep-alive connection is kept open
+func (c *Config) IdleTimeout() time.Duration {
+	return time.Duration(c.IdleTimeoutSecs) * time.Second
+}
+
+// UploadRateWindow returns the interval over which the minimum upload rate is enforced
+func (c *Config) UploadRateWindow() time.Duration {
+	return time.Duration(c.UploadRateWindowSecs) * time.Second
+}
+
+// UploadStallTimeout returns how long an upload or relay body may deliver nothing before it is
+// aborted, zero when stalls are not limited
+func (c *Config) UploadStallTimeout() time.Duration {
+	return time.Duration(c.UploadStallSecs) * time.Second
+}
+
+// MinUploadBytesPerWindow returns how many bytes an upload must deliver in every rate window
+func (c *Config) MinUploadBytesPerWindow() int64 {
+	return int64(c.MinUploadRateKBps) << 10 * int64(c.UploadRateWindowSecs)
+}
+
+// AuditLogMaxBytes returns the size at which the audit log is rotated
+func (c *Config) AuditLogMaxBytes() int64 {
+	return int64(c.AuditLogMaxSizeMB) << 20
+}
+
+// auditSummary describes the audit log settings for the configuration summary
+func (c *Config) auditSummary() string {
+	if c.AuditLogPath == "" {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s (rotate at %d MB, keep %d)", c.AuditLogPath, c.AuditLogMaxSizeMB, c.AuditLogBackups)
+}
+
+// stallSummary describes the upload stall timeout for the configuration summary
+func (c *Config) stallSummary() string {
+	if c.UploadStallSecs == 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%ds", c.UploadStallSecs)
+}
+
+// minRateSummary describes the minimum upload rate for the configuration summary
+func (c *Config) minRateSummary() string {
+	if c.MinUploadRateKBps == 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d KB/s over %ds", c.MinUploadRateKBps, c.UploadRateWindowSecs)
+}
+
+// tlsSummary describes the TLS settings for the configuration summary
+func (c *Config) tlsSummary() string {
+	summary := "disabled"
+	switch {
+	case c.TLSSelfSigned && c.TLSCertFile != "":
+		summary = fmt.Sprintf("self-signed for %s (stored in %s)", strings.Join(c.TLSHosts, ", "), c.TLSCertFile)
+	case c.TLSSelfSigned:
+		summary = fmt.Sprintf("self-signed for %s (in memory)", strings.Join(c.TLSHosts, ", "))
+	case c.TLSCertFile != "":
+		summary = fmt.Sprintf("certificate %s", c.TLSCertFile)
+	}
+	if c.TLSRedirectAddr != "" {
+		summary += fmt.Sprintf(", redirecting HTTP on %s", c.TLSRedirectAddr)
+	}
+	return summary
+}
+
+// formatLimit formats an optional limit where zero means unlimited
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(limit)
+}
+
+// formatMBLimit formats an optional size limit in megabytes for the configuration summary
+func formatMBLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(limit) + " MB"
+}
+
+// enabledString formats a feature toggle for the configuration summary
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// DefaultPreviewTypes are the media types shown inline unless PREVIEW_TYPES says otherwise
+const DefaultPreviewTypes = "image/*,video/*,audio/*,application/pdf,text/plain"
+
+// parsePreviewTypes parses a comma-separated list of media types and type/* wildcards, or
+// "none" to show nothing inline
+func parsePreviewTypes(value string) ([]string, error) {
+	types := []string{}
+	if strings.EqualFold(strings.TrimSpace(value), "none") {
+		return types, nil
+	}
+	for _, item := range splitList(value) {
+		mediaType, params, err := mime.ParseMediaType(item)
+		if err != nil || len(params) > 0 || strings.Count(mediaType, "/") != 1 {
+			return nil, fmt.Errorf("%q is not a media type", item)
+		}
+		if major, minor, _ := strings.Cut(mediaType, "/"); major == "*" || (strings.Contains(minor, "*") && minor != "*") {
+			return nil, fmt.Errorf("%q: only the subtype may be a wildcard", item)
+		}
+		types = append(types, mediaType)
+	}
+	return types, nil
+}
+
+// previewTypesString formats the preview types for the configuration summary
+func previewTypesString(types []string) string {
+	if len(types) == 0 {
+		return "none"
 	}
-	return defaultValue
+	return strings.Join(types, ", ")
 }
 
-// getIntEnvOrDefault returns environment variable as integer or default if not set/invalid
-func getIntEnvOrDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil && intValue > 0 {
-			return intValue
+// trustedProxiesSummary describes the proxies whose forwarding headers are believed
+func (c *Config) trustedProxiesSummary() string {
+	if len(c.TrustedProxies) == 0 {
+		return "none (Unix sockets only)"
+	}
+	return strings.Join(prefixStrings(c.TrustedProxies), ", ")
+}
+
+// parsePrefixList parses a comma-separated list of IP addresses and CIDR ranges
+func parsePrefixList(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
 		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// prefixStrings formats an address list as strings
+func prefixStrings(prefixes []netip.Prefix) []string {
+	items := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		items[i] = p.String()
+	}
+	return items
+}
+
+// formatPrefixList formats an address list for the configuration summary
+func formatPrefixList(prefixes []netip.Prefix) string {
+	if len(prefixes) == 0 {
+		return "any"
 	}
-	return defaultValue
+	return strings.Join(prefixStrings(prefixes), ", ")
 }
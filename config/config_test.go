@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParsePreviewTypes(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{input: DefaultPreviewTypes, want: []string{"image/*", "video/*", "audio/*", "application/pdf", "text/plain"}},
+		{input: " Image/PNG , text/plain ", want: []string{"image/png", "text/plain"}},
+		{input: "none", want: []string{}},
+		{input: "NONE", want: []string{}},
+		{input: "image", wantErr: true},
+		{input: "image/png; charset=utf-8", wantErr: true},
+		{input: "*/*", wantErr: true},
+		{input: "image/p*", wantErr: true},
+		{input: "image/png,none", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePreviewTypes(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePreviewTypes(%q) error = %v, want error: %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePreviewTypes(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewAllowed(t *testing.T) {
+	cfg := &Config{PreviewTypes: []string{"image/*", "application/pdf"}}
+	tests := map[string]bool{
+		"image/png":       true,
+		"image/webp":      true,
+		"application/pdf": true,
+		"text/plain":      false,
+		"video/mp4":       false,
+		"imagex/png":      false,
+	}
+	for mediaType, want := range tests {
+		if got := cfg.PreviewAllowed(mediaType); got != want {
+			t.Errorf("PreviewAllowed(%q) = %v, want %v", mediaType, got, want)
+		}
+	}
+	if (&Config{PreviewTypes: []string{}}).PreviewAllowed("image/png") {
+		t.Errorf("PreviewAllowed with no preview types = true")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qcus.toml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("[limits]\nmax_conns_per_ip = 4\n")
+
+	opts := &Options{ConfigFile: path}
+	cfg, err := Load(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := NewHolder(cfg, opts)
+
+	write("[limits]\nmax_conns_per_ip = 8\n[preview]\ntypes = \"none\"\n[storage]\nupload_dir = \"/elsewhere\"\n")
+	result, err := holder.Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	for _, env := range []string{"MAX_CONNS_PER_IP", "PREVIEW_TYPES"} {
+		if !slices.Contains(result.Changed, env) {
+			t.Errorf("Changed = %v, want it to contain %s", result.Changed, env)
+		}
+	}
+	if !slices.Equal(result.RestartRequired, []string{"UPLOAD_DIR"}) {
+		t.Errorf("RestartRequired = %v, want [UPLOAD_DIR]", result.RestartRequired)
+	}
+
+	current := holder.Current()
+	if current.MaxConnsPerIP != 8 || len(current.PreviewTypes) != 0 {
+		t.Errorf("after reload: MaxConnsPerIP = %d, PreviewTypes = %v, want 8 and none", current.MaxConnsPerIP, current.PreviewTypes)
+	}
+	if current.UploadDir != cfg.UploadDir {
+		t.Errorf("UploadDir = %q, want it unchanged until a restart", current.UploadDir)
+	}
+	if cfg.MaxConnsPerIP != 4 {
+		t.Errorf("the configuration in use before the reload was modified")
+	}
+
+	write("[limits]\nmax_conns_per_ip = -1\n")
+	if _, err := holder.Reload(); err == nil {
+		t.Errorf("Reload of an invalid configuration succeeded")
+	}
+	if holder.Current() != current {
+		t.Errorf("an invalid configuration replaced the current one")
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Holder gives atomic access to the current configuration so that a safe subset of
+// settings can be reloaded at runtime without restarting the server
+type Holder struct {
+	current atomic.Pointer[Config]
+	opts    *Options
+	mu      sync.Mutex // serializes reloads
+}
+
+// ReloadResult describes the outcome of a configuration reload
+type ReloadResult struct {
+	// Changed lists the settings that now have a new value
+	Changed []string `json:"changed"`
+	// RestartRequired lists changed settings that only take effect after a restart
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// NewHolder creates a Holder serving cfg, reloading later from the same options
+func NewHolder(cfg *Config, opts *Options) *Holder {
+	h := &Holder{opts: opts}
+	h.current.Store(cfg)
+	return h
+}
+
+// Current returns the configuration in effect. Callers should fetch it once per request
+// and use that snapshot throughout, so a request never sees a mix of old and new values.
+func (h *Holder) Current() *Config {
+	return h.current.Load()
+}
+
+// Reload reads the configuration file, environment and flags again and applies the settings
+// that can change at runtime. If the new configuration is invalid, nothing changes.
+func (h *Holder) Reload() (ReloadResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	loaded, err := Load(h.opts)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	old := h.Current()
+	next := *old
+	next.applyReloadable(loaded)
+
+	result := ReloadResult{Changed: []string{}, RestartRequired: []string{}}
+	for _, s := range settings {
+		if s.value == nil || reflect.DeepEqual(s.value(old), s.value(loaded)) {
+			continue
+		}
+		if s.Reloadable {
+			result.Changed = append(result.Changed, s.Env)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, s.Env)
+		}
+	}
+
+	if err := next.Validate(); err != nil {
+		return ReloadResult{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	h.current.Store(&next)
+	return result, nil
+}
+
+// applyReloadable copies the settings marked Reloadable from src
+func (c *Config) applyReloadable(src *Config) {
+	c.UploadPassword = src.UploadPassword
+	c.IsDefaultPassword = src.IsDefaultPassword
+	c.AdminPassword = src.AdminPassword
+	c.MetricsUsername = src.MetricsUsername
+	c.MetricsPassword = src.MetricsPassword
+	c.MetricsAllowedIPs = src.MetricsAllowedIPs
+	c.TrustedProxies = src.TrustedProxies
+	c.MaxFileSizeMB = src.MaxFileSizeMB
+	c.FileExpiryMinutes = src.FileExpiryMinutes
+	c.MaxExpiryMinutes = src.MaxExpiryMinutes
+	c.MaxDownloads = src.MaxDownloads
+	c.MaxPasteSizeKB = src.MaxPasteSizeKB
+	c.StripMetadata = src.StripMetadata
+	c.CompressUploads = src.CompressUploads
+	c.StorageQuotaMB = src.StorageQuotaMB
+	c.ClientQuotaMB = src.ClientQuotaMB
+	c.MinFreeSpaceMB = src.MinFreeSpaceMB
+	c.EvictOnLowSpace = src.EvictOnLowSpace
+	c.MaxConnsPerIP = src.MaxConnsPerIP
+	c.MinUploadRateKBps = src.MinUploadRateKBps
+	c.UploadRateWindowSecs = src.UploadRateWindowSecs
+	c.UploadStallSecs = src.UploadStallSecs
+	c.RelayMaxSizeMB = src.RelayMaxSizeMB
+	c.RelayWaitSecs = src.RelayWaitSecs
+	c.PreviewTypes = src.PreviewTypes
+}
+
+// DescribeReload summarizes a reload outcome for logs and audit records
+func DescribeReload(result ReloadResult, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if len(result.Changed) == 0 && len(result.RestartRequired) == 0 {
+		return "no changes"
+	}
+	summary := "changed: " + strings.Join(result.Changed, ", ")
+	if len(result.RestartRequired) > 0 {
+		summary += "; restart required: " + strings.Join(result.RestartRequired, ", ")
+	}
+	return summary
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Listener network types accepted in LISTEN
+const (
+	NetworkTCP     = "tcp"
+	NetworkUnix    = "unix"
+	NetworkSystemd = "systemd"
+)
+
+// ListenAddr is one address the server accepts connections on
+type ListenAddr struct {
+	Network string
+	// Address is host:port for TCP, the socket path for Unix sockets and the optional
+	// socket name (from FileDescriptorName=) for systemd
+	Address string
+	// Mode is applied to a Unix socket after it is created (zero keeps the umask default)
+	Mode fs.FileMode
+}
+
+// String formats the address the way it is written in LISTEN
+func (a ListenAddr) String() string {
+	switch a.Network {
+	case NetworkUnix:
+		s := "unix://" + a.Address
+		if a.Mode != 0 {
+			s += fmt.Sprintf("?mode=%04o", a.Mode)
+		}
+		return s
+	case NetworkSystemd:
+		return "systemd:" + a.Address
+	default:
+		return "tcp://" + a.Address
+	}
+}
+
+// parseListenAddrs parses a comma-separated LISTEN value such as
+// "tcp://:8088, unix:///run/qcus.sock?mode=0660, systemd:"
+func parseListenAddrs(value string) ([]ListenAddr, error) {
+	var addrs []ListenAddr
+	for _, item := range splitList(value) {
+		addr, err := parseListenAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no listen address given")
+	}
+	return addrs, nil
+}
+
+// parseListenAddr parses a single listen address. Addresses without a scheme are treated as TCP.
+func parseListenAddr(value string) (ListenAddr, error) {
+	switch {
+	case strings.HasPrefix(value, "systemd:"):
+		return ListenAddr{Network: NetworkSystemd, Address: strings.TrimPrefix(value, "systemd:")}, nil
+
+	case strings.HasPrefix(value, "unix://"):
+		u, err := url.Parse(value)
+		if err != nil {
+			return ListenAddr{}, err
+		}
+		path := u.Host + u.Path
+		if path == "" {
+			return ListenAddr{}, fmt.Errorf("missing socket path")
+		}
+		addr := ListenAddr{Network: NetworkUnix, Address: path}
+		if mode := u.Query().Get("mode"); mode != "" {
+			m, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil || m > 0777 {
+				return ListenAddr{}, fmt.Errorf("invalid socket mode %q", mode)
+			}
+			addr.Mode = fs.FileMode(m)
+		}
+		return addr, nil
+
+	default:
+		return parseTCPAddr(strings.TrimPrefix(value, "tcp://"))
+	}
+}
+
+// parseTCPAddr parses host:port, :port or a bare port number
+func parseTCPAddr(value string) (ListenAddr, error) {
+	if !strings.Contains(value, ":") {
+		value = ":" + value
+	}
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return ListenAddr{}, err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return ListenAddr{}, fmt.Errorf("invalid port %q", port)
+	}
+	return ListenAddr{Network: NetworkTCP, Address: value}, nil
+}
+
+// listenStrings formats listen addresses as strings
+func listenStrings(addrs []ListenAddr) []string {
+	items := make([]string, len(addrs))
+	for i, addr := range addrs {
+		items[i] = addr.String()
+	}
+	return items
+}
+
+// formatListenAddrs formats listen addresses for the configuration summary
+func formatListenAddrs(addrs []ListenAddr) string {
+	return strings.Join(listenStrings(addrs), ", ")
+}
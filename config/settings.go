@@ -0,0 +1,298 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// setting describes a configuration value that can be set in the configuration file,
+// through an environment variable or with a command-line flag
+type setting struct {
+	Env    string
+	Key    string // dotted path in the configuration file
+	Usage  string
+	Secret bool
+	// Reloadable settings take effect on a configuration reload, the others need a restart
+	Reloadable bool
+	// value returns the effective value for --print-config, nil for settings folded into another one
+	value func(c *Config) any
+}
+
+// settings lists every configuration setting, grouped by configuration file table
+var settings = []setting{
+	{Env: "UPLOAD_PASSWORD", Key: "auth.upload_password", Usage: "password required for uploads", Secret: true, Reloadable: true,
+		value: func(c *Config) any { return c.UploadPassword }},
+	{Env: "ADMIN_PASSWORD", Key: "auth.admin_password", Usage: "password enabling the admin dashboard and API", Secret: true, Reloadable: true,
+		value: func(c *Config) any { return c.AdminPassword }},
+
+	{Env: "LISTEN", Key: "server.listen", Usage: "comma-separated listen addresses (tcp://, unix://, systemd:)",
+		value: func(c *Config) any { return listenStrings(c.Listen) }},
+	{Env: "PORT", Key: "server.port", Usage: "TCP port to listen on when no listen address is set"},
+	{Env: "SHUTDOWN_GRACE_SECONDS", Key: "server.shutdown_grace_seconds", Usage: "time given to in-flight transfers on shutdown",
+		value: func(c *Config) any { return c.ShutdownGraceSecs }},
+	{Env: "READ_HEADER_TIMEOUT_SECONDS", Key: "server.read_header_timeout_seconds", Usage: "time a client may take to send request headers",
+		value: func(c *Config) any { return c.ReadHeaderTimeoutSecs }},
+	{Env: "IDLE_TIMEOUT_SECONDS", Key: "server.idle_timeout_seconds", Usage: "time an idle keep-alive connection is kept open",
+		value: func(c *Config) any { return c.IdleTimeoutSecs }},
+	{Env: "MAX_HEADER_BYTES", Key: "server.max_header_bytes", Usage: "maximum size of request headers",
+		value: func(c *Config) any { return c.MaxHeaderBytes }},
+	{Env: "TRUSTED_PROXIES", Key: "server.trusted_proxies", Usage: "comma-separated IPs or CIDR ranges of reverse proxies whose X-Forwarded-For is believed", Reloadable: true,
+		value: func(c *Config) any { return prefixStrings(c.TrustedProxies) }},
+
+	{Env: "TLS_CERT_FILE", Key: "tls.cert_file", Usage: "PEM certificate to serve HTTPS with",
+		value: func(c *Config) any { return c.TLSCertFile }},
+	{Env: "TLS_KEY_FILE", Key: "tls.key_file", Usage: "PEM private key matching the certificate",
+		value: func(c *Config) any { return c.TLSKeyFile }},
+	{Env: "TLS_SELF_SIGNED", Key: "tls.self_signed", Usage: "serve HTTPS with a generated self-signed certificate",
+		value: func(c *Config) any { return c.TLSSelfSigned }},
+	{Env: "TLS_HOSTS", Key: "tls.hosts", Usage: "comma-separated host names and IPs for the self-signed certificate",
+		value: func(c *Config) any { return c.TLSHosts }},
+	{Env: "TLS_REDIRECT_ADDR", Key: "tls.redirect_addr", Usage: "address of a plain HTTP listener redirecting to HTTPS",
+		value: func(c *Config) any { return c.TLSRedirectAddr }},
+	{Env: "TLS_RELOAD_INTERVAL_SECONDS", Key: "tls.reload_interval_seconds", Usage: "how often certificate files are checked for changes",
+		value: func(c *Config) any { return c.TLSReloadIntervalSecs }},
+
+	{Env: "UPLOAD_DIR", Key: "storage.upload_dir", Usage: "directory uploaded files are stored in",
+		value: func(c *Config) any { return c.UploadDir }},
+	{Env: "STRIP_METADATA", Key: "storage.strip_metadata", Usage: "remove EXIF and other metadata from JPEG and PNG uploads by default", Reloadable: true,
+		value: func(c *Config) any { return c.StripMetadata }},
+	{Env: "COMPRESS_UPLOADS", Key: "storage.compress", Usage: "store text uploads gzip-compressed", Reloadable: true,
+		value: func(c *Config) any { return c.CompressUploads }},
+	{Env: "MIN_FREE_SPACE_MB", Key: "storage.min_free_space_mb", Usage: "free space in megabytes to keep on the upload volume (0 disables)", Reloadable: true,
+		value: func(c *Config) any { return c.MinFreeSpaceMB }},
+	{Env: "EVICT_ON_LOW_SPACE", Key: "storage.evict_on_low_space", Usage: "delete the files expiring soonest when storage runs out, instead of rejecting uploads", Reloadable: true,
+		value: func(c *Config) any { return c.EvictOnLowSpace }},
+
+	{Env: "MAX_FILE_SIZE_MB", Key: "limits.max_file_size_mb", Usage: "maximum file size in megabytes", Reloadable: true,
+		value: func(c *Config) any { return c.MaxFileSizeMB }},
+	{Env: "FILE_EXPIRY_MINUTES", Key: "limits.file_expiry_minutes", Usage: "minutes before files auto-delete", Reloadable: true,
+		value: func(c *Config) any { return c.FileExpiryMinutes }},
+	{Env: "MAX_EXPIRY_MINUTES", Key: "limits.max_expiry_minutes", Usage: "maximum total lifetime of a file", Reloadable: true,
+		value: func(c *Config) any { return c.MaxExpiryMinutes }},
+	{Env: "MAX_DOWNLOADS", Key: "limits.max_downloads", Usage: "highest download count an uploader may allow for a file", Reloadable: true,
+		value: func(c *Config) any { return c.MaxDownloads }},
+	{Env: "MAX_PASTE_SIZE_KB", Key: "limits.max_paste_size_kb", Usage: "maximum size of a text paste in kilobytes", Reloadable: true,
+		value: func(c *Config) any { return c.MaxPasteSizeKB }},
+	{Env: "STORAGE_QUOTA_MB", Key: "limits.storage_quota_mb", Usage: "disk space in megabytes all stored files may take (0 is unlimited)", Reloadable: true,
+		value: func(c *Config) any { return c.StorageQuotaMB }},
+	{Env: "CLIENT_QUOTA_MB", Key: "limits.client_quota_mb", Usage: "megabytes of stored files per client IP or upload request (0 is unlimited)", Reloadable: true,
+		value: func(c *Config) any { return c.ClientQuotaMB }},
+	{Env: "MAX_CONNS_PER_IP", Key: "limits.max_conns_per_ip", Usage: "maximum concurrent connections per client IP (0 is unlimited)", Reloadable: true,
+		value: func(c *Config) any { return c.MaxConnsPerIP }},
+	{Env: "MIN_UPLOAD_RATE_KBPS", Key: "limits.min_upload_rate_kbps", Usage: "minimum upload rate (0 disables)", Reloadable: true,
+		value: func(c *Config) any { return c.MinUploadRateKBps }},
+	{Env: "UPLOAD_RATE_WINDOW_SECONDS", Key: "limits.upload_rate_window_seconds", Usage: "interval over which the minimum upload rate is measured", Reloadable: true,
+		value: func(c *Config) any { return c.UploadRateWindowSecs }},
+	{Env: "UPLOAD_STALL_TIMEOUT_SECONDS", Key: "limits.upload_stall_timeout_seconds", Usage: "time an upload or relay body may send nothing before it is aborted (0 disables)", Reloadable: true,
+		value: func(c *Config) any { return c.UploadStallSecs }},
+
+	{Env: "RELAY_MAX_SIZE_MB", Key: "relay.max_size_mb", Usage: "maximum size of a relayed transfer in megabytes", Reloadable: true,
+		value: func(c *Config) any { return c.RelayMaxSizeMB }},
+	{Env: "RELAY_WAIT_SECONDS", Key: "relay.wait_seconds", Usage: "time one side of a relay waits for the other", Reloadable: true,
+		value: func(c *Config) any { return c.RelayWaitSecs }},
+
+	{Env: "PREVIEW_TYPES", Key: "preview.types", Usage: "comma-separated media types (or type/*) shown inline in the browser, or none", Reloadable: true,
+		value: func(c *Config) any {
+			if len(c.PreviewTypes) == 0 {
+				return "none"
+			}
+			return c.PreviewTypes
+		}},
+
+	{Env: "METRICS_USERNAME", Key: "metrics.username", Usage: "basic auth username for /metrics", Reloadable: true,
+		value: func(c *Config) any { return c.MetricsUsername }},
+	{Env: "METRICS_PASSWORD", Key: "metrics.password", Usage: "basic auth password for /metrics", Secret: true, Reloadable: true,
+		value: func(c *Config) any { return c.MetricsPassword }},
+	{Env: "METRICS_ALLOWED_IPS", Key: "metrics.allowed_ips", Usage: "comma-separated IPs or CIDR ranges allowed to read /metrics", Reloadable: true,
+		value: func(c *Config) any { return prefixStrings(c.MetricsAllowedIPs) }},
+
+	{Env: "LOG_FORMAT", Key: "log.format", Usage: "log output format: text or json",
+		value: func(c *Config) any { return c.LogFormat }},
+	{Env: "LOG_LEVEL", Key: "log.level", Usage: "minimum log level: debug, info, warn or error",
+		value: func(c *Config) any { return strings.ToLower(c.LogLevel.String()) }},
+
+	{Env: "AUDIT_LOG", Key: "audit.path", Usage: "path of the JSON-lines audit log",
+		value: func(c *Config) any { return c.AuditLogPath }},
+	{Env: "AUDIT_LOG_MAX_SIZE_MB", Key: "audit.max_size_mb", Usage: "size at which the audit log is rotated",
+		value: func(c *Config) any { return c.AuditLogMaxSizeMB }},
+	{Env: "AUDIT_LOG_BACKUPS", Key: "audit.backups", Usage: "number of rotated audit logs to keep",
+		value: func(c *Config) any { return c.AuditLogBackups }},
+}
+
+// flagName returns the command-line flag for a setting, e.g. --max-file-size-mb
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.Env), "_", "-")
+}
+
+// sourceValue is a raw setting value and where it came from, for error messages
+type sourceValue struct {
+	raw    string
+	origin string
+}
+
+// source resolves settings from the configuration file, environment variables and flags,
+// later layers overriding earlier ones. Invalid values are collected rather than ignored.
+type source struct {
+	values map[string]sourceValue // keyed by environment variable name
+	errs   []error
+}
+
+// newSource layers the configuration file values, the environment and the flag overrides
+func newSource(fileValues map[string]sourceValue, overrides map[string]sourceValue) *source {
+	src := &source{values: make(map[string]sourceValue)}
+	for env, v := range fileValues {
+		src.values[env] = v
+	}
+	for _, s := range settings {
+		if value := os.Getenv(s.Env); value != "" {
+			src.values[s.Env] = sourceValue{raw: value, origin: "environment variable " + s.Env}
+		}
+	}
+	for env, v := range overrides {
+		src.values[env] = v
+	}
+	return src
+}
+
+// invalid records that a setting holds an unusable value
+func (src *source) invalid(key, expected string) {
+	v := src.values[key]
+	src.errs = append(src.errs, fmt.Errorf("%s: invalid value %q, expected %s", v.origin, v.raw, expected))
+}
+
+// lookup returns the raw value of a setting and whether it was set
+func (src *source) lookup(key string) (string, bool) {
+	v, ok := src.values[key]
+	if !ok || v.raw == "" {
+		return "", false
+	}
+	return v.raw, true
+}
+
+// str returns a setting or its default if not set
+func (src *source) str(key, defaultValue string) string {
+	if value, ok := src.lookup(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// positiveInt returns a setting as a positive integer or its default if not set
+func (src *source) positiveInt(key string, defaultValue int) int {
+	return src.intAtLeast(key, defaultValue, 1, "a positive integer")
+}
+
+// nonNegativeInt is like positiveInt but also accepts zero, used to disable a limit
+func (src *source) nonNegativeInt(key string, defaultValue int) int {
+	return src.intAtLeast(key, defaultValue, 0, "a non-negative integer")
+}
+
+func (src *source) intAtLeast(key string, defaultValue, minimum int, expected string) int {
+	value, ok := src.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil || intValue < minimum {
+		src.invalid(key, expected)
+		return defaultValue
+	}
+	return intValue
+}
+
+// boolean returns a setting as a boolean or its default if not set
+func (src *source) boolean(key string, defaultValue bool) bool {
+	value, ok := src.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		src.invalid(key, "true or false")
+		return defaultValue
+	}
+	return boolValue
+}
+
+// readConfigFile reads a TOML configuration file into values keyed by environment variable name
+func readConfigFile(path string) (map[string]sourceValue, []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to read config file: %w", err)}
+	}
+	parsed, err := parseTOML(string(data))
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %w", path, err)}
+	}
+
+	byKey := make(map[string]string, len(settings))
+	for _, s := range settings {
+		byKey[s.Key] = s.Env
+	}
+
+	keys := make([]string, 0, len(parsed))
+	for key := range parsed {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return parsed[keys[i]].line < parsed[keys[j]].line })
+
+	values := make(map[string]sourceValue, len(parsed))
+	var errs []error
+	for _, key := range keys {
+		v := parsed[key]
+		env, ok := byKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s line %d: unknown setting %q", path, v.line, key))
+			continue
+		}
+		values[env] = sourceValue{raw: v.raw, origin: fmt.Sprintf("%s line %d (%s)", path, v.line, key)}
+	}
+	return values, errs
+}
+
+// WriteTOML writes the effective configuration in configuration file format, with secrets redacted
+func (c *Config) WriteTOML(w io.Writer) error {
+	var b strings.Builder
+	table := ""
+	for _, s := range settings {
+		if s.value == nil {
+			continue
+		}
+		section, name, _ := strings.Cut(s.Key, ".")
+		if section != table {
+			if table != "" {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "[%s]\n", section)
+			table = section
+		}
+
+		value := s.value(c)
+		if s.Secret && value != "" {
+			value = "***"
+		}
+		fmt.Fprintf(&b, "%s = %s\n", name, formatTOMLValue(value))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatTOMLValue formats a string, integer, boolean or string list as a TOML value
+func formatTOMLValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []string:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = strconv.Quote(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,334 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlValue is a value read from the configuration file, flattened to the string form
+// used by environment variables (arrays are joined with commas)
+type tomlValue struct {
+	raw  string
+	line int
+}
+
+// tomlParser parses the subset of TOML used by the configuration file: [tables], key = value
+// pairs with strings, integers, booleans or arrays of those, and # comments
+type tomlParser struct {
+	s      string
+	pos    int
+	line   int
+	values map[string]tomlValue
+}
+
+// parseTOML parses a configuration file into values keyed by their dotted path
+func parseTOML(data string) (map[string]tomlValue, error) {
+	p := &tomlParser{s: data, line: 1, values: make(map[string]tomlValue)}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return p.values, nil
+}
+
+// ReadTOMLFile reads a TOML file into values keyed by their dotted path, in the string form
+// used by environment variables
+func ReadTOMLFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseTOML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := make(map[string]string, len(parsed))
+	for key, v := range parsed {
+		values[key] = v.raw
+	}
+	return values, nil
+}
+
+func (p *tomlParser) parse() error {
+	table := ""
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return nil
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			if p.peek() == '[' {
+				return fmt.Errorf("arrays of tables are not supported")
+			}
+			end := strings.IndexAny(p.s[p.pos:], "]\n")
+			if end < 0 || p.s[p.pos+end] != ']' {
+				return fmt.Errorf("unterminated table header")
+			}
+			table = strings.TrimSpace(p.s[p.pos : p.pos+end])
+			if !validKey(table) {
+				return fmt.Errorf("invalid table name %q", table)
+			}
+			p.pos += end + 1
+			if err := p.endOfLine(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		line := p.line
+		key, err := p.parseKey()
+		if err != nil {
+			return err
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		p.skipBlank(false)
+		if p.peek() != '=' {
+			return fmt.Errorf("expected '=' after key %q", key)
+		}
+		p.pos++
+		p.skipBlank(false)
+
+		value, err := p.parseValue(true)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if _, exists := p.values[key]; exists {
+			return fmt.Errorf("duplicate key %q", key)
+		}
+		p.values[key] = tomlValue{raw: value, line: line}
+
+		if err := p.endOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+// skipBlank skips spaces, tabs and comments, and newlines too when multiline is set
+func (p *tomlParser) skipBlank(multiline bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case c == '\n' && multiline:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine expects only whitespace or a comment until the end of the line
+func (p *tomlParser) endOfLine() error {
+	p.skipBlank(false)
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return fmt.Errorf("unexpected %q after value", p.peek())
+	}
+	return nil
+}
+
+func (p *tomlParser) parseKey() (string, error) {
+	start := p.pos
+	for !p.eof() && (isKeyChar(p.peek()) || p.peek() == '.') {
+		p.pos++
+	}
+	key := p.s[start:p.pos]
+	if !validKey(key) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return key, nil
+}
+
+// parseValue parses a scalar, or an array of scalars when allowArray is set
+func (p *tomlParser) parseValue(allowArray bool) (string, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		return p.parseBasicString()
+	case c == '\'':
+		end := strings.IndexAny(p.s[p.pos+1:], "'\n")
+		if end < 0 || p.s[p.pos+1+end] != '\'' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		value := p.s[p.pos+1 : p.pos+1+end]
+		if i := strings.IndexFunc(value, func(r rune) bool { return r < 0x80 && isTOMLControl(byte(r)) }); i >= 0 {
+			return "", fmt.Errorf("invalid string %s: control character %U", p.s[p.pos:p.pos+2+end], value[i])
+		}
+		p.pos += end + 2
+		return value, nil
+	case c == '[' && allowArray:
+		return p.parseArray()
+	default:
+		start := p.pos
+		for !p.eof() && !strings.ContainsRune(" \t\r\n#,]", rune(p.peek())) {
+			p.pos++
+		}
+		value := p.s[start:p.pos]
+		if value == "true" || value == "false" {
+			return value, nil
+		}
+		n, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64)
+		if err != nil || value == "" {
+			return "", fmt.Errorf("invalid value %q", value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	end := p.pos + 1
+	for ; end < len(p.s); end++ {
+		if p.s[end] == '\\' {
+			end++
+		} else if p.s[end] == '"' || p.s[end] == '\n' {
+			break
+		}
+	}
+	if end >= len(p.s) || p.s[end] != '"' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	value, err := unescapeBasicString(p.s[p.pos+1 : end])
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s: %w", p.s[p.pos:end+1], err)
+	}
+	p.pos = end + 1
+	return value, nil
+}
+
+// tomlEscapes maps the single-character escapes of TOML basic strings to what they stand for;
+// \e comes from TOML 1.1, \uXXXX and \UXXXXXXXX are handled by unescapeBasicString
+var tomlEscapes = map[byte]rune{
+	'b':  '\b',
+	't':  '\t',
+	'n':  '\n',
+	'f':  '\f',
+	'r':  '\r',
+	'e':  0x1b,
+	'"':  '"',
+	'\\': '\\',
+}
+
+// unescapeBasicString decodes the content of a basic string, between its quotes, with the
+// escape rules of TOML rather than those of Go
+func unescapeBasicString(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			if isTOMLControl(c) {
+				return "", fmt.Errorf("control character %U must be escaped", c)
+			}
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("incomplete escape sequence")
+		}
+		if r, ok := tomlEscapes[s[i]]; ok {
+			b.WriteRune(r)
+			continue
+		}
+		digits := 0
+		switch s[i] {
+		case 'u':
+			digits = 4
+		case 'U':
+			digits = 8
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c", s[i])
+		}
+		if i+digits >= len(s) {
+			return "", fmt.Errorf("incomplete escape sequence \\%s", s[i:])
+		}
+		hex := s[i+1 : i+1+digits]
+		code, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return "", fmt.Errorf("invalid escape sequence \\%c%s", s[i], hex)
+		}
+		b.WriteRune(rune(code))
+		i += digits
+	}
+	return b.String(), nil
+}
+
+// isTOMLControl reports whether c is a control character that TOML strings may not contain
+// unescaped: all of them but tab
+func isTOMLControl(c byte) bool {
+	return c < 0x20 && c != '\t' || c == 0x7f
+}
+
+func (p *tomlParser) parseArray() (string, error) {
+	p.pos++ // opening bracket
+	var items []string
+	for {
+		p.skipBlank(true)
+		if p.peek() == ']' {
+			p.pos++
+			return strings.Join(items, ","), nil
+		}
+		if p.eof() {
+			return "", fmt.Errorf("unterminated array")
+		}
+
+		item, err := p.parseValue(false)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item)
+
+		p.skipBlank(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return "", fmt.Errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func isKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// validKey reports whether key is a dotted sequence of bare keys
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, part := range strings.Split(key, ".") {
+		if part == "" {
+			return false
+		}
+		for i := 0; i < len(part); i++ {
+			if !isKeyChar(part[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
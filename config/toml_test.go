@@ -0,0 +1,222 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]string{},
+		},
+		{
+			name:  "comments and blank lines",
+			input: "# a comment\n\n   \t\n# another\n",
+			want:  map[string]string{},
+		},
+		{
+			name:  "top-level scalars",
+			input: "port = 8088\nupload_password = \"secret\"\nstrip_metadata = true\n",
+			want:  map[string]string{"port": "8088", "upload_password": "secret", "strip_metadata": "true"},
+		},
+		{
+			name:  "tables",
+			input: "[server]\nport = 80\n\n[limits]\nmax_file_size_mb = 1_000 # in MB\n",
+			want:  map[string]string{"server.port": "80", "limits.max_file_size_mb": "1000"},
+		},
+		{
+			name:  "dotted keys and table names",
+			input: "a.b = 1\n[c.d]\ne = 2\n",
+			want:  map[string]string{"a.b": "1", "c.d.e": "2"},
+		},
+		{
+			name:  "basic string escapes",
+			input: `note = "tab\there \"quoted\" \u00e9 # not a comment"` + "\n",
+			want:  map[string]string{"note": "tab\there \"quoted\" é # not a comment"},
+		},
+		{
+			name:  "literal string",
+			input: `path = 'C:\uploads\'` + "\n",
+			want:  map[string]string{"path": `C:\uploads\`},
+		},
+		{
+			name:  "negative integer",
+			input: "offset = -5\n",
+			want:  map[string]string{"offset": "-5"},
+		},
+		{
+			name:  "arrays joined with commas",
+			input: "ips = [\"10.0.0.0/8\", '192.168.0.0/16']\nports = [1, 2, 3,]\nnone = []\n",
+			want:  map[string]string{"ips": "10.0.0.0/8,192.168.0.0/16", "ports": "1,2,3", "none": ""},
+		},
+		{
+			name:  "multiline array with comments",
+			input: "ips = [\n  \"127.0.0.1\", # local\n  \"::1\",\n]\n",
+			want:  map[string]string{"ips": "127.0.0.1,::1"},
+		},
+		{
+			name:  "windows line endings",
+			input: "[server]\r\nport = 1\r\n",
+			want:  map[string]string{"server.port": "1"},
+		},
+		{
+			name:  "no trailing newline",
+			input: "port = 1",
+			want:  map[string]string{"port": "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseTOML(tt.input)
+			if err != nil {
+				t.Fatalf("parseTOML: %v", err)
+			}
+			got := make(map[string]string, len(values))
+			for key, v := range values {
+				got[key] = v.raw
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLLines(t *testing.T) {
+	values, err := parseTOML("# header\n\n[server]\nport = 1\n\nips = [\n  \"a\",\n]\nname = \"x\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, line := range map[string]int{"server.port": 4, "server.ips": 6, "server.name": 9} {
+		if values[key].line != line {
+			t.Errorf("%s: line = %d, want %d", key, values[key].line, line)
+		}
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // expected in the error message
+	}{
+		{"missing equals", "port 8088\n", "line 1: expected '='"},
+		{"missing value", "port =\n", `line 1: port: invalid value ""`},
+		{"invalid value", "port = eighty\n", `invalid value "eighty"`},
+		{"float", "ratio = 1.5\n", `invalid value "1.5"`},
+		{"unterminated basic string", "name = \"abc\n", "unterminated string"},
+		{"unterminated literal string", "name = 'abc\n", "unterminated string"},
+		{"invalid escape", `name = "\q"` + "\n", "invalid string"},
+		{"control character in basic string", "name = \"a\x01b\"\n", "control character"},
+		{"control character in literal string", "name = 'a\x7fb'\n", "control character"},
+		{"unterminated array", "ips = [1,\n", "unterminated array"},
+		{"missing comma in array", "ips = [1 2]\n", "expected ',' or ']' in array"},
+		{"nested array", "ips = [[1]]\n", "invalid value"},
+		{"trailing garbage", "port = 1 2\n", "unexpected '2' after value"},
+		{"duplicate key", "port = 1\nport = 2\n", `line 2: duplicate key "port"`},
+		{"duplicate key in table", "[a]\nb = 1\n[a]\nb = 2\n", `duplicate key "a.b"`},
+		{"unterminated table header", "[server\nport = 1\n", "unterminated table header"},
+		{"empty table name", "[]\n", "invalid table name"},
+		{"array of tables", "[[servers]]\n", "arrays of tables are not supported"},
+		{"invalid key", "por t = 1\n", "expected '='"},
+		{"empty key part", "a..b = 1\n", `invalid key "a..b"`},
+		{"quoted key", "\"port\" = 1\n", "invalid key"},
+		{"error line", "a = 1\n\n# comment\nb = ?\n", "line 4:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML(tt.input)
+			if err == nil {
+				t.Fatalf("parseTOML(%q) succeeded, want an error containing %q", tt.input, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnescapeBasicString(t *testing.T) {
+	valid := map[string]string{
+		`plain`:               "plain",
+		`\b`:                  "\b",
+		`\t`:                  "\t",
+		`\n`:                  "\n",
+		`\f`:                  "\f",
+		`\r`:                  "\r",
+		`\e`:                  "\x1b",
+		`\"`:                  `"`,
+		`\\`:                  `\`,
+		`\u00e9`:              "é",
+		`\u00E9`:              "é",
+		`\U0001F600`:          "😀",
+		`\U0010FFFF`:          "\U0010FFFF",
+		"tab\tstays":          "tab\tstays",
+		`a\\nb`:               `a\nb`,
+		`C:\\Program Files\\`: `C:\Program Files\`,
+	}
+	for input, want := range valid {
+		got, err := unescapeBasicString(input)
+		if err != nil || got != want {
+			t.Errorf("unescapeBasicString(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+
+	invalid := []string{
+		`\x41`, // Go only
+		`\a`,   // Go only
+		`\v`,   // Go only
+		`\0`,   // Go only octal
+		`\'`,   // Go only
+		`\q`,
+		`\`,
+		`\u12`,       // too few digits
+		`\u12G4`,     // not hexadecimal
+		`\u+123`,     // sign
+		`\uD800`,     // surrogate
+		`\U00110000`, // beyond Unicode
+		`\U0001F60`,  // too few digits
+		"a\x00b",     // unescaped control characters
+		"a\rb",
+		"a\x1fb",
+		"a\x7fb",
+	}
+	for _, input := range invalid {
+		if got, err := unescapeBasicString(input); err == nil {
+			t.Errorf("unescapeBasicString(%q) = %q, want an error", input, got)
+		}
+	}
+}
+
+func TestReadTOMLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qcus.toml")
+	if err := os.WriteFile(path, []byte("[server]\nport = 9000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	values, err := ReadTOMLFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"server.port": "9000"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("ReadTOMLFile = %v, want %v", values, want)
+	}
+
+	if err := os.WriteFile(path, []byte("port = \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTOMLFile(path); err == nil || !strings.HasPrefix(err.Error(), path+": line 1:") {
+		t.Errorf("error = %v, want it to start with the path and line", err)
+	}
+}
@@ -3,8 +3,12 @@ module go-quick-cli-upload-server
 go 1.23
 
 require (
-	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/mdp/qrterminal/v3 v3.2.1 // indirect
+	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.18.0
+	github.com/mdp/qrterminal/v3 v3.2.1
+)
+
+require (
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/term v0.13.0 // indirect
 	rsc.io/qr v0.2.0 // indirect
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/metrics"
+	"go-quick-cli-upload-server/storage"
+)
+
+// AdminHandler serves the admin API used to inspect and manage active uploads
+type AdminHandler struct {
+	Store  *storage.FileStore
+	Config *config.Holder
+	Audit  *audit.Logger
+}
+
+// NewAdminHandler creates a new AdminHandler
+func NewAdminHandler(store *storage.FileStore, cfg *config.Holder, auditLog *audit.Logger) *AdminHandler {
+	return &AdminHandler{
+		Store:  store,
+		Config: cfg,
+		Audit:  auditLog,
+	}
+}
+
+// AdminFile is the admin view of a stored file
+type AdminFile struct {
+	ID                 string          `json:"id"`
+	Name               string          `json:"name"`
+	Size               int64           `json:"size"`
+	Uploader           string          `json:"uploader"`
+	UploaderAgent      string          `json:"uploaderAgent"`
+	UploadTime         time.Time       `json:"uploadTime"`
+	ExpiresAt          time.Time       `json:"expiresAt"`
+	RemainingDownloads int             `json:"remainingDownloads"`
+	Downloads          []AdminDownload `json:"downloads"`
+	EarlierDownloads   int             `json:"earlierDownloads,omitempty"` // older attempts not listed
+}
+
+// AdminDownload is the admin view of a download attempt
+type AdminDownload struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"clientIP"`
+	UserAgent string    `json:"userAgent"`
+	Success   bool      `json:"success"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config.Current()
+	if !cfg.AdminEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !secureCompare(r.Header.Get("X-Admin-Password"), cfg.AdminPassword) {
+		metrics.AuthFailuresTotal.Inc("admin")
+		auditAuthFailure(h.Audit, r, "admin")
+		http.Error(w, "Unauthorized: Invalid or missing admin password", http.StatusUnauthorized)
+		requestLogger(r).Warn("Admin API access with invalid password", "client_ip", clientIP(r))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/admin/api/audit":
+		h.handleAudit(w, r)
+		return
+	case "/admin/api/config/reload":
+		h.handleReload(w, r)
+		return
+	case "/admin/api/storage":
+		h.handleStorage(w, r)
+		return
+	}
+
+	// Routes: /admin/api/files, /admin/api/files/{id}, /admin/api/files/{id}/expiry
+	rest, found := strings.CutPrefix(r.URL.Path, "/admin/api/files")
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	rest = strings.Trim(rest, "/")
+	switch {
+	case rest == "":
+		h.handleList(w, r)
+	case strings.HasSuffix(rest, "/expiry"):
+		h.handleExpiry(w, r, strings.TrimSuffix(rest, "/expiry"))
+	case !strings.Contains(rest, "/"):
+		h.handleDelete(w, r, rest)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleList returns all active files
+func (h *AdminHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	files := h.Store.List()
+	result := make([]AdminFile, 0, len(files))
+	for _, sf := range files {
+		result = append(result, toAdminFile(sf))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleDelete force-deletes a file
+func (h *AdminHandler) handleDelete(w http.ResponseWriter, r *http.Request, fileID string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sf, exists := h.Store.Get(fileID)
+	if !exists || !h.Store.Revoke(fileID) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	rec := newAuditRecord(r, audit.EventRevoke, audit.IdentityAdmin)
+	rec.Success = true
+	rec.FileID = fileID
+	rec.FileName = sf.OriginalName
+	rec.Size = sf.Size
+	rec.SHA256 = sf.SHA256
+	h.Audit.Log(rec)
+
+	requestLogger(r).Info("File force-deleted by admin", "file_id", fileID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleExpiry changes the remaining lifetime of a file
+func (h *AdminHandler) handleExpiry(w http.ResponseWriter, r *http.Request, fileID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	minutes, err := strconv.Atoi(r.FormValue("minutes"))
+	if err != nil || minutes <= 0 {
+		http.Error(w, "minutes must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	sf, exists := h.Store.Get(fileID)
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	sf, err = changeExpiry(h.Store, h.Config.Current(), fileID, sf, minutes)
+	if errors.Is(err, errFileNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	auditExpiryChange(h.Audit, r, audit.IdentityAdmin, sf)
+	requestLogger(r).Info("File expiry changed by admin", "file_id", fileID, "expires_at", sf.ExpiresAt)
+	writeJSON(w, http.StatusOK, toAdminFile(sf))
+}
+
+// handleReload reloads the runtime-changeable settings from the configuration sources
+func (h *AdminHandler) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := h.Config.Reload()
+
+	rec := newAuditRecord(r, audit.EventConfigReload, audit.IdentityAdmin)
+	rec.Success = err == nil
+	rec.Detail = config.DescribeReload(result, err)
+	h.Audit.Log(rec)
+
+	if err != nil {
+		requestLogger(r).Warn("Configuration reload failed", "error", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	requestLogger(r).Info("Configuration reloaded by admin", "changed", result.Changed, "restart_required", result.RestartRequired)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// AdminStorage is the admin view of the storage used by uploads. Quotas and the free space
+// watermark of zero are disabled.
+type AdminStorage struct {
+	Files             int                  `json:"files"`
+	Blobs             int                  `json:"blobs"`
+	Bytes             int64                `json:"bytes"` // on disk, counting shared content once
+	LogicalBytes      int64                `json:"logicalBytes"`
+	DeduplicatedBytes int64                `json:"deduplicatedBytes"`
+	CompressedBytes   int64                `json:"compressedBytes"` // saved by compression at rest
+	UsedBytes         int64                `json:"usedBytes"`       // counted against the quota, including uploads in progress
+	QuotaBytes        int64                `json:"quotaBytes"`
+	ClientQuotaBytes  int64                `json:"clientQuotaBytes"`
+	MinFreeBytes      int64                `json:"minFreeBytes"`
+	Evict             bool                 `json:"evict"`
+	DiskFreeBytes     *int64               `json:"diskFreeBytes,omitempty"` // unset where it cannot be queried
+	DiskTotalBytes    *int64               `json:"diskTotalBytes,omitempty"`
+	Clients           []storage.OwnerUsage `json:"clients"` // largest first
+}
+
+// handleStorage returns the storage usage against the quotas and the free disk space
+func (h *AdminHandler) handleStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := h.Config.Current()
+	usage := h.Store.Stats()
+	used, _ := h.Store.QuotaUsage("")
+	result := AdminStorage{
+		Files:             usage.Files,
+		Blobs:             usage.Blobs,
+		Bytes:             usage.Bytes,
+		LogicalBytes:      usage.LogicalBytes,
+		DeduplicatedBytes: usage.Deduplicated,
+		CompressedBytes:   usage.Compressed(),
+		UsedBytes:         used,
+		QuotaBytes:        cfg.StorageQuotaBytes(),
+		ClientQuotaBytes:  cfg.ClientQuotaBytes(),
+		MinFreeBytes:      cfg.MinFreeBytes(),
+		Evict:             cfg.EvictOnLowSpace,
+		Clients:           h.Store.OwnerUsages(),
+	}
+	if free, total, err := storage.DiskSpace(cfg.UploadDir); err == nil {
+		result.DiskFreeBytes, result.DiskTotalBytes = &free, &total
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAudit returns audit records filtered by the query parameters
+// event, file_id, ip, identity, since, until (RFC 3339) and limit
+func (h *AdminHandler) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.Audit.Enabled() {
+		http.Error(w, "Audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := audit.Filter{
+		Event:    q.Get("event"),
+		FileID:   q.Get("file_id"),
+		ClientIP: q.Get("ip"),
+		Identity: q.Get("identity"),
+		Limit:    1000,
+	}
+
+	var err error
+	if filter.Since, err = parseOptionalTime(q.Get("since")); err != nil {
+		http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseOptionalTime(q.Get("until")); err != nil {
+		http.Error(w, "until must be an RFC 3339 timestamp", http.StatusBadRequest)
+		return
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := h.Audit.Query(filter)
+	if err != nil {
+		requestLogger(r).Error("Error querying audit log", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []audit.Record{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// parseOptionalTime parses an RFC 3339 timestamp, returning the zero time for an empty string
+func parseOptionalTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// toAdminFile converts stored metadata to its admin API representation
+func toAdminFile(sf storage.StoredFile) AdminFile {
+	downloads := make([]AdminDownload, 0, len(sf.Downloads))
+	for _, d := range sf.Downloads {
+		downloads = append(downloads, AdminDownload{
+			Time:      d.Time,
+			ClientIP:  d.ClientIP,
+			UserAgent: d.UserAgent,
+			Success:   d.Success,
+		})
+	}
+
+	return AdminFile{
+		ID:                 sf.ID,
+		Name:               sf.OriginalName,
+		Size:               sf.Size,
+		Uploader:           sf.UploaderIP,
+		UploaderAgent:      sf.UploaderAgent,
+		UploadTime:         sf.UploadTime,
+		ExpiresAt:          sf.ExpiresAt,
+		RemainingDownloads: sf.RemainingDownloads,
+		Downloads:          downloads,
+		EarlierDownloads:   sf.EarlierDownloads,
+	}
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding JSON response", "error", err)
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/storage"
+)
+
+// newAuditRecord creates an audit record describing the client of r
+func newAuditRecord(r *http.Request, event, identity string) audit.Record {
+	client := clientAddressOf(r)
+	rec := audit.Record{
+		Event:        event,
+		Identity:     identity,
+		ClientIP:     client.Remote,
+		ForwardedFor: client.Forwarded,
+		UserAgent:    r.UserAgent(),
+	}
+	if id, ok := r.Context().Value(requestIDKey).(string); ok {
+		rec.RequestID = id
+	}
+	return rec
+}
+
+// auditExpiryChange records a new expiry of a file, set by identity
+func auditExpiryChange(log *audit.Logger, r *http.Request, identity string, sf storage.StoredFile) {
+	rec := newAuditRecord(r, audit.EventExpiryChange, identity)
+	rec.Success = true
+	rec.FileID = sf.ID
+	rec.FileName = sf.OriginalName
+	rec.Detail = "expires at " + sf.ExpiresAt.UTC().Format(time.RFC3339)
+	log.Log(rec)
+}
+
+// auditAuthFailure records a rejected credential for the given endpoint
+func auditAuthFailure(log *audit.Logger, r *http.Request, endpoint string) {
+	rec := newAuditRecord(r, audit.EventAuthFailure, audit.IdentityAnonymous)
+	rec.Detail = endpoint
+	log.Log(rec)
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"go-quick-cli-upload-server/config"
+)
+
+// requestPassword returns the upload password supplied via header or form value. Multipart
+// bodies are only searched up to their file part, as read by withMultipartUpload: parsing them
+// whole would store the uploaded files in temporary files before the password is checked.
+func requestPassword(r *http.Request) string {
+	if providedPassword := r.Header.Get("X-Upload-Password"); providedPassword != "" {
+		return providedPassword
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if mu := multipartUploadOf(r); mu != nil && mu.fields.Has("password") {
+			return mu.fields.Get("password")
+		}
+		return r.URL.Query().Get("password")
+	}
+	return r.FormValue("password")
+}
+
+// isUploadAuthorized checks the request's password against the configured upload password
+func isUploadAuthorized(r *http.Request, cfg *config.Config) bool {
+	return secureCompare(requestPassword(r), cfg.UploadPassword)
+}
+
+// secureCompare compares two secrets in constant time
+func secureCompare(provided, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+}
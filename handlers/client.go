@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientAddress describes where a request came from
+type clientAddress struct {
+	IP     string // the client: the connection address, or the address reported by a trusted proxy
+	Remote string // the address of the connection
+	// Forwarded is the X-Forwarded-For chain or X-Real-IP sent by a trusted proxy, empty for
+	// direct connections
+	Forwarded string
+	Scheme    string // the scheme the client used, as reported by a trusted proxy if any
+}
+
+// withClientAddress stores the client of r, resolved with the trusted proxies, in its context
+func withClientAddress(r *http.Request, trusted []netip.Prefix) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientKey, resolveClient(r, trusted)))
+}
+
+// resolveClient works out the client of r. X-Forwarded-For, X-Real-IP and X-Forwarded-Proto
+// are only believed on connections from a trusted proxy or over a Unix socket, which only
+// local processes can reach; anyone else could send them to pose as another client or to
+// choose the scheme of the links generated for it.
+func resolveClient(r *http.Request, trusted []netip.Prefix) clientAddress {
+	remote := remoteHost(r)
+	client := clientAddress{IP: remote, Remote: remote, Scheme: connScheme(r)}
+
+	remoteAddr, err := netip.ParseAddr(remote)
+	if err == nil && !containsAddr(trusted, remoteAddr.Unmap()) {
+		return client
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		client.Scheme = proto
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := range hops {
+			hops[i] = strings.TrimSpace(hops[i])
+		}
+		client.Forwarded = strings.Join(hops, ", ")
+		// Each proxy appends the address it got the request from: walking back from the last
+		// one, the first address that is not another trusted proxy is the client
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(hops[i])
+			if err != nil {
+				break
+			}
+			client.IP = addr.Unmap().String()
+			if !containsAddr(trusted, addr.Unmap()) {
+				break
+			}
+		}
+		return client
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		client.Forwarded = realIP
+		if addr, err := netip.ParseAddr(realIP); err == nil {
+			client.IP = addr.Unmap().String()
+		}
+	}
+	return client
+}
+
+// clientAddressOf returns the client of r as resolved by RequestLogger
+func clientAddressOf(r *http.Request) clientAddress {
+	if client, ok := r.Context().Value(clientKey).(clientAddress); ok {
+		return client
+	}
+	remote := remoteHost(r)
+	return clientAddress{IP: remote, Remote: remote, Scheme: connScheme(r)}
+}
+
+// clientIP returns the address of the client, as reported by a trusted reverse proxy if the
+// request came through one
+func clientIP(r *http.Request) string {
+	return clientAddressOf(r).IP
+}
+
+// remoteHost returns the address of the connection r came in on
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// containsAddr reports whether addr is in one of prefixes
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScheme returns the scheme the client used, "https" when the server terminates TLS itself
+// or a trusted reverse proxy reports it through X-Forwarded-Proto
+func requestScheme(r *http.Request) string {
+	return clientAddressOf(r).Scheme
+}
+
+// connScheme returns the scheme of the connection r came in on
+func connScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestResolveClient(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		tls     bool
+		want    clientAddress
+	}{
+		{
+			name:   "direct",
+			remote: "203.0.113.5:1234",
+			want:   clientAddress{IP: "203.0.113.5", Remote: "203.0.113.5", Scheme: "http"},
+		},
+		{
+			name:    "direct with forged headers",
+			remote:  "203.0.113.5:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2", "X-Forwarded-Proto": "https"},
+			want:    clientAddress{IP: "203.0.113.5", Remote: "203.0.113.5", Scheme: "http"},
+		},
+		{
+			name:    "direct over TLS with a forged scheme",
+			remote:  "203.0.113.5:1234",
+			headers: map[string]string{"X-Forwarded-Proto": "http"},
+			tls:     true,
+			want:    clientAddress{IP: "203.0.113.5", Remote: "203.0.113.5", Scheme: "https"},
+		},
+		{
+			name:    "trusted proxy",
+			remote:  "10.0.0.2:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.3", "X-Forwarded-Proto": "https"},
+			want:    clientAddress{IP: "198.51.100.1", Remote: "10.0.0.2", Forwarded: "198.51.100.1, 10.0.0.3", Scheme: "https"},
+		},
+		{
+			name:    "trusted proxy with X-Real-IP",
+			remote:  "10.0.0.2:1234",
+			headers: map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:    clientAddress{IP: "198.51.100.1", Remote: "10.0.0.2", Forwarded: "198.51.100.1", Scheme: "http"},
+		},
+		{
+			name:    "trusted proxy with an unknown scheme",
+			remote:  "10.0.0.2:1234",
+			headers: map[string]string{"X-Forwarded-Proto": "ftp"},
+			want:    clientAddress{IP: "10.0.0.2", Remote: "10.0.0.2", Scheme: "http"},
+		},
+		{
+			name:    "unix socket",
+			remote:  "@",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"},
+			want:    clientAddress{IP: "198.51.100.1", Remote: "@", Forwarded: "198.51.100.1", Scheme: "https"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			} else {
+				r.TLS = nil
+			}
+			if got := resolveClient(r, trusted); got != tt.want {
+				t.Errorf("resolveClient = %+v, want %+v", got, tt.want)
+			}
+			if got := requestScheme(withClientAddress(r, trusted)); got != tt.want.Scheme {
+				t.Errorf("requestScheme = %q, want %q", got, tt.want.Scheme)
+			}
+		})
+	}
+}
@@ -2,27 +2,44 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
 	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/storage"
 )
 
 // ConfigHandler provides public configuration information
 type ConfigHandler struct {
-	Config *config.Config
+	Config *config.Holder
+	Store  *storage.FileStore
 }
 
 // NewConfigHandler creates a new ConfigHandler
-func NewConfigHandler(cfg *config.Config) *ConfigHandler {
-	return &ConfigHandler{Config: cfg}
+func NewConfigHandler(cfg *config.Holder, store *storage.FileStore) *ConfigHandler {
+	return &ConfigHandler{Config: cfg, Store: store}
 }
 
 // PublicConfig contains non-sensitive configuration exposed to the frontend
 type PublicConfig struct {
 	IsDefaultPassword bool `json:"isDefaultPassword"`
 	FileExpiryMinutes int  `json:"fileExpiryMinutes"`
+	MaxExpiryMinutes  int  `json:"maxExpiryMinutes"`
 	MaxFileSizeMB     int  `json:"maxFileSizeMB"`
+	StripMetadata     bool `json:"stripMetadata"`
+	// Storage is the space left for uploads, overall and for the requesting client
+	Storage StorageUsage `json:"storage"`
+}
+
+// StorageUsage describes the storage used by uploads against the quotas; a quota of zero is
+// unlimited
+type StorageUsage struct {
+	UsedBytes        int64 `json:"usedBytes"`
+	QuotaBytes       int64 `json:"quotaBytes"`
+	ClientUsedBytes  int64 `json:"clientUsedBytes"`
+	ClientQuotaBytes int64 `json:"clientQuotaBytes"`
+	// AvailableBytes is how much more the client may upload within the quotas, or -1 if
+	// neither is set. Free disk space may still run out first.
+	AvailableBytes int64 `json:"availableBytes"`
 }
 
 // ServeHTTP implements http.Handler
@@ -32,15 +49,41 @@ func (h *ConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg := h.Config.Current()
 	publicConfig := PublicConfig{
-		IsDefaultPassword: h.Config.IsDefaultPassword,
-		FileExpiryMinutes: h.Config.FileExpiryMinutes,
-		MaxFileSizeMB:     h.Config.MaxFileSizeMB,
+		IsDefaultPassword: cfg.IsDefaultPassword,
+		FileExpiryMinutes: cfg.FileExpiryMinutes,
+		MaxExpiryMinutes:  cfg.MaxExpiryMinutes,
+		MaxFileSizeMB:     cfg.MaxFileSizeMB,
+		StripMetadata:     cfg.StripMetadata,
+		Storage:           h.storageUsage(r, cfg),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(publicConfig); err != nil {
-		log.Printf("Error encoding config response: %v", err)
+		requestLogger(r).Error("Error encoding config response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+// storageUsage returns the storage usage of the server and of the client of r
+func (h *ConfigHandler) storageUsage(r *http.Request, cfg *config.Config) StorageUsage {
+	used, clientUsed := h.Store.QuotaUsage(storage.QuotaOwner("", clientIP(r)))
+	usage := StorageUsage{
+		UsedBytes:        used,
+		QuotaBytes:       cfg.StorageQuotaBytes(),
+		ClientUsedBytes:  clientUsed,
+		ClientQuotaBytes: cfg.ClientQuotaBytes(),
+		AvailableBytes:   -1,
+	}
+	if usage.QuotaBytes > 0 {
+		usage.AvailableBytes = max(usage.QuotaBytes-used, 0)
+	}
+	if usage.ClientQuotaBytes > 0 {
+		available := max(usage.ClientQuotaBytes-clientUsed, 0)
+		if usage.AvailableBytes < 0 || available < usage.AvailableBytes {
+			usage.AvailableBytes = available
+		}
+	}
+	return usage
+}
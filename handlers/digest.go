@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"go-quick-cli-upload-server/storage"
+)
+
+// errDigestMismatch rejects uploads whose content does not match a checksum sent by the client
+var errDigestMismatch = errors.New("upload does not match the checksum sent by the client")
+
+// digestAlgorithms are the Repr-Digest algorithms (RFC 9530) uploads can be verified with
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// uploadDigest is a checksum of an upload announced by the client, checked against a hash of
+// the received bytes
+type uploadDigest struct {
+	header string // where the checksum came from, for error messages
+	hash   hash.Hash
+	want   []byte
+}
+
+// parseUploadDigests reads the Content-MD5 (RFC 1864) and Repr-Digest headers of a raw
+// upload. Repr-Digest algorithms other than SHA-256 and SHA-512 are ignored, as the RFC
+// allows; a malformed header is an error.
+func parseUploadDigests(header http.Header) ([]uploadDigest, error) {
+	var digests []uploadDigest
+
+	if value := header.Get("Content-MD5"); value != "" {
+		want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil || len(want) != md5.Size {
+			return nil, errors.New("Content-MD5 must be the base64-encoded MD5 of the content")
+		}
+		digests = append(digests, uploadDigest{header: "Content-MD5", hash: md5.New(), want: want})
+	}
+
+	for _, value := range header.Values("Repr-Digest") {
+		for _, member := range strings.Split(value, ",") {
+			algorithm, encoded, found := strings.Cut(strings.TrimSpace(member), "=")
+			if !found || len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+				return nil, errors.New("Repr-Digest must look like sha-256=:base64:")
+			}
+			newHash, ok := digestAlgorithms[strings.ToLower(algorithm)]
+			if !ok {
+				continue
+			}
+			want, err := base64.StdEncoding.DecodeString(encoded[1 : len(encoded)-1])
+			if err != nil {
+				return nil, fmt.Errorf("Repr-Digest %s is not valid base64", algorithm)
+			}
+			h := newHash()
+			if len(want) != h.Size() {
+				return nil, fmt.Errorf("Repr-Digest %s has the wrong length", algorithm)
+			}
+			digests = append(digests, uploadDigest{header: "Repr-Digest " + algorithm, hash: h, want: want})
+		}
+	}
+	return digests, nil
+}
+
+// verifyDigests checks the hashes of the received content against the client's checksums
+func verifyDigests(digests []uploadDigest) error {
+	for _, d := range digests {
+		if got := d.hash.Sum(nil); string(got) != string(d.want) {
+			return fmt.Errorf("%w (%s)", errDigestMismatch, d.header)
+		}
+	}
+	return nil
+}
+
+// setDigestHeaders announces the SHA-256 of a stored file with Repr-Digest (RFC 9530) and
+// the older Digest header (RFC 3230), which describe the whole file even for range requests
+func setDigestHeaders(w http.ResponseWriter, sf storage.StoredFile) {
+	sum, err := hex.DecodeString(sf.SHA256)
+	if err != nil || len(sum) != sha256.Size {
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	w.Header().Set("Repr-Digest", "sha-256=:"+encoded+":")
+	w.Header().Set("Digest", "sha-256="+encoded)
+}
+
+// downloadCommand returns a curl command line saving a file under name, or under fileID if
+// name cannot be used safely. Both the name, chosen by the uploader, and the URL are quoted
+// so that pasting the command never runs anything else.
+func downloadCommand(fileURL, fileID, name string) string {
+	return fmt.Sprintf("curl -o %s %s", shellQuote(commandFileName(fileID, name)), shellQuote(fileURL))
+}
+
+// verifyCommand returns a shell one-liner downloading a file like downloadCommand and
+// checking its SHA-256
+func verifyCommand(fileURL, fileID, name, sha256Hex string) string {
+	return fmt.Sprintf("%s && echo %s | sha256sum -c", downloadCommand(fileURL, fileID, name),
+		shellQuote(sha256Hex+"  "+commandFileName(fileID, name)))
+}
+
+// commandFileName returns name, or fileID for names that curl and sha256sum would not take
+// as a plain file name: empty, "-" (standard output) or containing control characters, such
+// as a newline that would split the command and the checksum line
+func commandFileName(fileID, name string) string {
+	if name == "" || name == "-" || strings.ContainsFunc(name, unicode.IsControl) {
+		return fileID
+	}
+	return name
+}
+
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	names := []string{
+		"report.pdf",
+		"$(touch pwned).txt",
+		"`id`",
+		`quote".txt`,
+		"it's here.txt",
+		"''",
+		`back\slash`,
+		"semi;colon&amp|pipe>out",
+		"spaces  and\ttab",
+	}
+	for _, name := range names {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(name)).Output()
+		if err != nil || string(out) != name {
+			t.Errorf("shell read %s as %q (%v), want %q", shellQuote(name), out, err, name)
+		}
+	}
+}
+
+func TestDownloadCommand(t *testing.T) {
+	const url, id, sum = "https://example.com/download/abc", "abc", "0123abcd"
+	tests := []struct {
+		name, wantCurl, wantVerify string
+	}{
+		{
+			name:       "report.pdf",
+			wantCurl:   "curl -o 'report.pdf' 'https://example.com/download/abc'",
+			wantVerify: "curl -o 'report.pdf' 'https://example.com/download/abc' && echo '0123abcd  report.pdf' | sha256sum -c",
+		},
+		{
+			name:     "it's $(rm -rf ~)",
+			wantCurl: `curl -o 'it'\''s $(rm -rf ~)' 'https://example.com/download/abc'`,
+		},
+		{name: "two\nlines", wantCurl: "curl -o 'abc' 'https://example.com/download/abc'"},
+		{name: "-", wantCurl: "curl -o 'abc' 'https://example.com/download/abc'"},
+		{name: "", wantCurl: "curl -o 'abc' 'https://example.com/download/abc'"},
+	}
+	for _, tt := range tests {
+		if got := downloadCommand(url, id, tt.name); got != tt.wantCurl {
+			t.Errorf("downloadCommand(%q) = %s, want %s", tt.name, got, tt.wantCurl)
+		}
+		verify := verifyCommand(url, id, tt.name, sum)
+		if !strings.HasPrefix(verify, tt.wantCurl+" && ") || (tt.wantVerify != "" && verify != tt.wantVerify) {
+			t.Errorf("verifyCommand(%q) = %s", tt.name, verify)
+		}
+	}
+}
@@ -1,66 +1,301 @@
 package handlers
 
 import (
+	"fmt"
 	"io"
-	"log"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/metrics"
 	"go-quick-cli-upload-server/storage"
 )
 
-// DownloadHandler handles file download requests
+// DownloadHandler handles file download requests. /download/{id} serves an attachment to
+// command-line clients, while browsers and link-preview bots are sent to the preview page,
+// whose Download button POSTs back here. /raw/{id} shows text pastes and /inline/{id}
+// previewable files in the browser.
 type DownloadHandler struct {
-	Store *storage.FileStore
+	Store  *storage.FileStore
+	Config *config.Holder
+	Audit  *audit.Logger
 }
 
 // NewDownloadHandler creates a new DownloadHandler
-func NewDownloadHandler(store *storage.FileStore) *DownloadHandler {
-	return &DownloadHandler{Store: store}
+func NewDownloadHandler(store *storage.FileStore, cfg *config.Holder, auditLog *audit.Logger) *DownloadHandler {
+	return &DownloadHandler{
+		Store:  store,
+		Config: cfg,
+		Audit:  auditLog,
+	}
 }
 
 // ServeHTTP implements http.Handler
 func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	fileID := r.URL.Path[len("/download/"):]
+	route, fileID, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	raw, inline := route == "raw", route == "inline"
 	if fileID == "" {
 		http.Error(w, "File ID required", http.StatusBadRequest)
 		return
 	}
 
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if route == "download" && r.Method == http.MethodGet && needsLandingPage(r) {
+			// Opening or unfurling the link must not consume the download
+			http.Redirect(w, r, "/f/"+fileID, http.StatusFound)
+			return
+		}
+	case http.MethodPost:
+		// The Download button of the preview page
+		if route != "download" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if isCrossSite(r) {
+			// Another site could otherwise submit the form and burn the download
+			http.Error(w, "Cross-site download requests are not allowed", http.StatusForbidden)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Range"), ",") {
+		// The bytes of a multi-range response cannot be told apart from the whole file, so
+		// such requests get the whole file and count as a download
+		r.Header.Del("Range")
+	}
+
+	rec := newAuditRecord(r, audit.EventDownload, audit.IdentityAnonymous)
+	rec.FileID = fileID
+
 	sf, exists := h.Store.Get(fileID)
+	if (raw && sf.Language == "") || (inline && previewKind(sf.ContentType, h.Config.Current()) == "") {
+		exists = false
+	}
 	if !exists {
+		metrics.DownloadsTotal.Inc("not_found")
+		rec.Detail = "file not found"
+		h.Audit.Log(rec)
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
-	f, err := os.Open(sf.Path)
+	if raw && r.Method != http.MethodHead && isLinkPreview(r) {
+		// Unfurling the link in a chat must not burn the paste: the paste page shows previews
+		// a summary without the content
+		http.Redirect(w, r, "/p/"+fileID, http.StatusFound)
+		return
+	}
+
+	rec.FileName = sf.OriginalName
+	rec.Size = sf.Size
+	rec.SHA256 = sf.SHA256
+
+	// Files stored compressed are sent as they are to clients accepting gzip. Range requests
+	// always get the decompressed content, so that offsets mean the same to every client.
+	encoded := sf.Encoding == storage.GzipEncoding && r.Header.Get("Range") == "" && acceptsGzip(r)
+	var f io.ReadSeekCloser
+	var err error
+	if encoded {
+		f, err = os.Open(sf.Path)
+	} else {
+		f, err = storage.OpenData(sf)
+	}
 	if err != nil {
+		metrics.DownloadsTotal.Inc("error")
+		rec.Detail = "failed to open file"
+		h.Audit.Log(rec)
 		http.Error(w, "Failed to open file", http.StatusInternalServerError)
-		log.Printf("Error opening file %s: %v", sf.Path, err)
+		requestLogger(r).Error("Error opening file", "file_id", fileID, "path", sf.Path, "error", err)
 		return
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
-			log.Printf("Error closing downloaded file: %v", err)
+			requestLogger(r).Warn("Error closing downloaded file", "file_id", fileID, "error", err)
 		}
 	}()
 
-	h.setDownloadHeaders(w, sf.OriginalName, fileID)
+	setDownloadHeaders(w, sf.OriginalName, fileID)
+	if sf.Language != "" {
+		setPasteHeaders(w, sf.OriginalName, raw)
+	}
+	if inline {
+		setInlineHeaders(w, sf)
+	}
+	if sf.Encoding != "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	size := sf.Size
+	if encoded {
+		size = sf.StoredSize
+		w.Header().Set("Content-Encoding", "gzip")
+		// ServeContent leaves the length of encoded content to the caller
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	switch {
+	case sf.SHA256 == "":
+	case encoded:
+		// The compressed bytes are a different representation, with their own tag and digest
+		w.Header().Set("ETag", `"`+sf.SHA256+`-gzip"`)
+	default:
+		// Lets clients resume with If-Range without risking a mix of two different files
+		w.Header().Set("ETag", `"`+sf.SHA256+`"`)
+		setDigestHeaders(w, sf)
+	}
+
+	if r.Method == http.MethodHead {
+		http.ServeContent(w, r, "", sf.UploadTime, f)
+		return
+	}
+	if r.Method == http.MethodPost {
+		// ServeContent only answers GET with content
+		r.Method = http.MethodGet
+	}
+	record := newDownloadRecord(r)
+
+	// ServeContent handles Range requests, so interrupted downloads can be resumed
+	resp := &responseRecorder{ResponseWriter: w}
+	http.ServeContent(resp, r, "", sf.UploadTime, f)
+	metrics.BytesOutTotal.Add(float64(resp.bytes))
+
+	if resp.status != http.StatusOK && resp.status != http.StatusPartialContent {
+		// Not modified, unsatisfiable range or similar: nothing was transferred
+		return
+	}
+
+	expected, toEnd := expectedTransfer(resp, size)
+	if resp.bytes >= expected && toEnd {
+		completeDownload(h.Store, h.Audit, r, rec, record, resp.bytes)
+		return
+	}
+
+	// Ranges that stop before the end of the file and interrupted transfers do not complete
+	// the download, but count as one once they have served as much as the whole file, so
+	// that the file cannot be read again and again in pieces
+	served := resp.bytes
+	if encoded && sf.StoredSize > 0 {
+		served = int64(float64(resp.bytes) * float64(sf.Size) / float64(sf.StoredSize))
+	}
+	if h.Store.RecordPartialDownload(fileID, record, served) {
+		rec.Detail = "incomplete transfers add up to the whole file"
+		completeDownload(h.Store, h.Audit, r, rec, record, resp.bytes)
+		return
+	}
+
+	if resp.bytes < expected {
+		metrics.DownloadsTotal.Inc("interrupted")
+		rec.Detail = fmt.Sprintf("transfer interrupted after %d bytes", resp.bytes)
+		h.Audit.Log(rec)
+		requestLogger(r).Warn("Download interrupted", "file_id", fileID, "bytes", resp.bytes, "expected", expected)
+		return
+	}
+	metrics.DownloadsTotal.Inc("partial")
+	rec.Detail = fmt.Sprintf("partial content: %s", resp.Header().Get("Content-Range"))
+	h.Audit.Log(rec)
+}
+
+// isCrossSite reports whether r was sent by a page of another origin, according to the
+// Sec-Fetch-Site header of browsers or, for older ones, the Origin header. Requests with
+// neither, from command-line clients for instance, are not cross-site.
+func isCrossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	origin := r.Header.Get("Origin")
+	return origin != "" && !checkOrigin(r)
+}
+
+// needsLandingPage reports whether a download request comes from a browser navigating to the
+// link or from a bot building a link preview, rather than from a command-line client
+func needsLandingPage(r *http.Request) bool {
+	if isLinkPreview(r) {
+		return true
+	}
+	if isTerminalRequest(r) {
+		return false
+	}
+	return r.Header.Get("Sec-Fetch-Dest") == "document" || strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// newDownloadRecord describes a download attempt by the client of r
+func newDownloadRecord(r *http.Request) storage.DownloadRecord {
+	return storage.DownloadRecord{
+		Time:      time.Now(),
+		ClientIP:  clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// completeDownload records a successful download of the file in rec and deletes the file
+// once it has no downloads left
+func completeDownload(store *storage.FileStore, auditLog *audit.Logger, r *http.Request,
+	rec audit.Record, record storage.DownloadRecord, bytes int64) {
 
-	if _, err := io.Copy(w, f); err != nil {
-		log.Printf("Error streaming file: %v", err)
+	metrics.DownloadsTotal.Inc("success")
+	rec.Success = true
+	auditLog.Log(rec)
+	record.Success = true
+	remaining := store.RecordDownload(rec.FileID, record)
+
+	if remaining > 0 {
+		requestLogger(r).Info("File downloaded", "file_id", rec.FileID, "bytes", bytes, "remaining_downloads", remaining)
 		return
 	}
+	requestLogger(r).Info("File downloaded and deleted", "file_id", rec.FileID, "bytes", bytes)
+	store.Delete(rec.FileID)
+}
 
-	log.Printf("File downloaded and deleted: %s", fileID)
-	h.Store.Delete(fileID)
+// expectedTransfer returns how many bytes a response should carry and whether it reaches the
+// end of the file: the whole file for a 200, or the range announced in Content-Range for a 206.
+// Multi-range requests are served whole, see ServeHTTP.
+func expectedTransfer(resp *responseRecorder, size int64) (expected int64, toEnd bool) {
+	if resp.status == http.StatusOK {
+		return size, true
+	}
+
+	var start, end, total int64
+	if _, err := fmt.Sscanf(resp.Header().Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return 0, false
+	}
+	return end - start + 1, end == total-1
+}
+
+// acceptsGzip reports whether the client of r accepts gzip content coding
+func acceptsGzip(r *http.Request) bool {
+	gzip, wildcard := false, false
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			accepted := true
+			if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+				weight, err := strconv.ParseFloat(q, 64)
+				accepted = err == nil && weight > 0
+			}
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				if !accepted {
+					return false
+				}
+				gzip = true
+			case "*":
+				wildcard = accepted
+			}
+		}
+	}
+	return gzip || wildcard
 }
 
 // setDownloadHeaders sets appropriate headers for file download
-func (h *DownloadHandler) setDownloadHeaders(w http.ResponseWriter, originalName, fileID string) {
+func setDownloadHeaders(w http.ResponseWriter, originalName, fileID string) {
 	name := originalName
 	if name == "" {
 		name = filepath.Base(fileID)
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/storage"
+)
+
+// newTestHandlers returns an upload and a download handler sharing a store in a temporary directory
+func newTestHandlers(t *testing.T) (*UploadHandler, *DownloadHandler) {
+	t.Helper()
+	cfg, err := config.Load(&config.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.UploadDir = t.TempDir()
+	cfg.UploadPassword = "secret"
+	cfg.CompressUploads = true
+	store := storage.NewFileStore(cfg.UploadDir)
+	t.Cleanup(store.Flush)
+	holder := config.NewHolder(cfg, &config.Options{})
+	return NewUploadHandler(store, holder, nil, nil), NewDownloadHandler(store, holder, nil)
+}
+
+// upload stores content under name and returns the stored file
+func upload(t *testing.T, h *UploadHandler, name string, content []byte) storage.StoredFile {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPut, "/"+name, bytes.NewReader(content))
+	r.Header.Set("X-Upload-Password", "secret")
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("X-Max-Downloads", "10")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload of %s: status %d: %s", name, w.Code, w.Body)
+	}
+	var resp UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	sf, ok := h.Store.Get(resp.ID)
+	if !ok {
+		t.Fatalf("uploaded file %s is not stored", resp.ID)
+	}
+	return sf
+}
+
+// assertNoLeftovers fails the test if an upload left unfinished files behind.
+// The index temp file is skipped since the store may be saving it in the background.
+func assertNoLeftovers(t *testing.T, h *UploadHandler) {
+	t.Helper()
+	indexTemp := storage.IndexFileName + storage.IncompleteSuffix
+	err := filepath.WalkDir(h.Config.Current().UploadDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && strings.HasSuffix(path, storage.IncompleteSuffix) && d.Name() != indexTemp {
+			t.Errorf("an upload left %s behind", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressedUpload(t *testing.T) {
+	uploadHandler, downloadHandler := newTestHandlers(t)
+	content := []byte(strings.Repeat("2024-05-01 12:00:00 INFO request served\n", 200))
+	sf := upload(t, uploadHandler, "app.log", content)
+
+	if sf.Encoding != storage.GzipEncoding || sf.StoredSize >= sf.Size || sf.Size != int64(len(content)) {
+		t.Fatalf("stored with encoding %q, %d of %d bytes, want gzip and smaller", sf.Encoding, sf.StoredSize, sf.Size)
+	}
+	if info, err := os.Stat(sf.Path); err != nil || info.Size() != sf.StoredSize {
+		t.Fatalf("blob %s: %v, want %d bytes", sf.Path, err, sf.StoredSize)
+	}
+	assertNoLeftovers(t, uploadHandler)
+
+	tests := []struct {
+		name         string
+		header       map[string]string
+		wantStatus   int
+		wantEncoding string
+		want         []byte
+	}{
+		{name: "plain", wantStatus: http.StatusOK, want: content},
+		{
+			name:         "gzip",
+			header:       map[string]string{"Accept-Encoding": "gzip"},
+			wantStatus:   http.StatusOK,
+			wantEncoding: "gzip",
+			want:         content,
+		},
+		{
+			name:       "range",
+			header:     map[string]string{"Range": "bytes=4000-4099"},
+			wantStatus: http.StatusPartialContent,
+			want:       content[4000:4100],
+		},
+		{
+			name:       "range with gzip accepted",
+			header:     map[string]string{"Range": "bytes=-50", "Accept-Encoding": "gzip"},
+			wantStatus: http.StatusPartialContent,
+			want:       content[len(content)-50:],
+		},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/download/"+sf.ID, nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		downloadHandler.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding != tt.wantEncoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tt.name, encoding, tt.wantEncoding)
+			continue
+		}
+		body := w.Body.Bytes()
+		if encoding == "gzip" {
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if body, err = io.ReadAll(gz); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		if !bytes.Equal(body, tt.want) {
+			t.Errorf("%s: got %d bytes that differ from the %d expected", tt.name, len(body), len(tt.want))
+		}
+	}
+}
+
+func TestUncompressedUploads(t *testing.T) {
+	uploadHandler, _ := newTestHandlers(t)
+	noise := make([]byte, 4096)
+	if _, err := rand.Read(noise); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"small.txt": []byte("too small to be worth compressing\n"),
+		"data.bin":  bytes.Repeat([]byte{0, 1, 2, 3}, 1024),
+		// Taken for text by its name, but compressing a sample of it saves nothing
+		"noise.txt": noise,
+	}
+	for name, content := range tests {
+		sf := upload(t, uploadHandler, name, content)
+		if sf.Encoding != "" {
+			t.Errorf("%s stored with encoding %q, want none", name, sf.Encoding)
+			continue
+		}
+		if stored, err := os.ReadFile(sf.Path); err != nil || !bytes.Equal(stored, content) {
+			t.Errorf("%s: stored content differs from the upload (%v)", name, err)
+		}
+	}
+	assertNoLeftovers(t, uploadHandler)
+}
+
+func TestIncompressibleTextNearQuota(t *testing.T) {
+	uploadHandler, _ := newTestHandlers(t)
+	uploadHandler.Config.Current().ClientQuotaMB = 1
+	// Fits the quota only if it is written once, as it is
+	noise := make([]byte, 1<<20-4096)
+	if _, err := rand.Read(noise); err != nil {
+		t.Fatal(err)
+	}
+	sf := upload(t, uploadHandler, "noise.txt", noise)
+	if sf.Encoding != "" || sf.Size != int64(len(noise)) {
+		t.Errorf("stored with encoding %q and %d bytes, want %d bytes as they are", sf.Encoding, sf.Size, len(noise))
+	}
+	assertNoLeftovers(t, uploadHandler)
+}
+
+func TestCompressedTextOverQuota(t *testing.T) {
+	uploadHandler, _ := newTestHandlers(t)
+	uploadHandler.Config.Current().ClientQuotaMB = 1
+	owner := storage.QuotaOwner("", "192.0.2.1")
+
+	// Compresses far under the quota, but the quota counts stored files at their full size
+	text := bytes.Repeat([]byte("the same line of text, over and over\n"), 3<<20/37)
+	r := httptest.NewRequest(http.MethodPut, "/log.txt", bytes.NewReader(text))
+	r.ContentLength = -1
+	r.Header.Set("X-Upload-Password", "secret")
+	w := httptest.NewRecorder()
+	uploadHandler.ServeHTTP(w, r)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("status %d, want %d: %s", w.Code, http.StatusInsufficientStorage, w.Body)
+	}
+	if _, ownerUsed := uploadHandler.Store.QuotaUsage(owner); ownerUsed != 0 {
+		t.Errorf("owner usage after the refused upload = %d, want 0", ownerUsed)
+	}
+
+	sf := upload(t, uploadHandler, "small.txt", text[:1<<19])
+	if sf.Encoding == "" {
+		t.Fatal("text stored uncompressed")
+	}
+	if _, ownerUsed := uploadHandler.Store.QuotaUsage(owner); ownerUsed != sf.Size {
+		t.Errorf("owner usage = %d, want the size of the file, %d", ownerUsed, sf.Size)
+	}
+	assertNoLeftovers(t, uploadHandler)
+}
+
+func TestCrossSiteDownloadPost(t *testing.T) {
+	uploadHandler, downloadHandler := newTestHandlers(t)
+	sf := upload(t, uploadHandler, "report.pdf", []byte("%PDF-1.4 one-time content"))
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+	}{
+		{name: "cross-site fetch", header: map[string]string{"Sec-Fetch-Site": "cross-site"}, wantStatus: http.StatusForbidden},
+		{name: "same-site fetch", header: map[string]string{"Sec-Fetch-Site": "same-site"}, wantStatus: http.StatusForbidden},
+		{name: "foreign origin", header: map[string]string{"Origin": "https://evil.example"}, wantStatus: http.StatusForbidden},
+		{name: "opaque origin", header: map[string]string{"Origin": "null"}, wantStatus: http.StatusForbidden},
+		{
+			name:       "same origin",
+			header:     map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://example.com"},
+			wantStatus: http.StatusOK,
+		},
+		{name: "same origin without fetch metadata", header: map[string]string{"Origin": "http://example.com"}, wantStatus: http.StatusOK},
+		{name: "command-line client", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/download/"+sf.ID, nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		downloadHandler.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+	}
+
+	// Only the three allowed requests count as downloads
+	got, ok := uploadHandler.Store.Get(sf.ID)
+	if !ok || got.RemainingDownloads != 7 {
+		t.Errorf("remaining downloads = %d (stored %v), want 7", got.RemainingDownloads, ok)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/metrics"
+	"go-quick-cli-upload-server/storage"
+)
+
+// ExpiryHandler lets uploaders extend or shorten the lifetime of a stored file
+type ExpiryHandler struct {
+	Store  *storage.FileStore
+	Config *config.Holder
+	Audit  *audit.Logger
+}
+
+// NewExpiryHandler creates a new ExpiryHandler
+func NewExpiryHandler(store *storage.FileStore, cfg *config.Holder, auditLog *audit.Logger) *ExpiryHandler {
+	return &ExpiryHandler{
+		Store:  store,
+		Config: cfg,
+		Audit:  auditLog,
+	}
+}
+
+// ExpiryResponse describes a file's expiry after a change
+type ExpiryResponse struct {
+	FileID       string    `json:"fileID"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	MaxExpiresAt time.Time `json:"maxExpiresAt"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *ExpiryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := h.Config.Current()
+	if !isUploadAuthorized(r, cfg) {
+		metrics.AuthFailuresTotal.Inc("expiry")
+		auditAuthFailure(h.Audit, r, "expiry")
+		http.Error(w, "Unauthorized: Invalid or missing password", http.StatusUnauthorized)
+		requestLogger(r).Warn("Expiry change attempt with invalid password", "client_ip", clientIP(r))
+		return
+	}
+
+	fileID := r.URL.Path[len("/expiry/"):]
+	if fileID == "" {
+		http.Error(w, "File ID required", http.StatusBadRequest)
+		return
+	}
+
+	minutes, err := strconv.Atoi(r.FormValue("minutes"))
+	if err != nil || minutes <= 0 {
+		http.Error(w, "minutes must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	sf, exists := h.Store.Get(fileID)
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	sf, err = changeExpiry(h.Store, cfg, fileID, sf, minutes)
+	if errors.Is(err, errFileNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	auditExpiryChange(h.Audit, r, audit.IdentityUploader, sf)
+	requestLogger(r).Info("File expiry changed", "file_id", fileID, "expires_at", sf.ExpiresAt)
+
+	writeJSON(w, http.StatusOK, ExpiryResponse{
+		FileID:       fileID,
+		ExpiresAt:    sf.ExpiresAt,
+		MaxExpiresAt: sf.UploadTime.Add(cfg.MaxLifetime()),
+	})
+}
+
+// errFileNotFound is returned when a file disappears while it is being modified
+var errFileNotFound = errors.New("file not found")
+
+// changeExpiry sets a file to expire the given number of minutes from now, or at the end of
+// the configured maximum lifetime if that comes first, and notifies WebSocket watchers of the
+// new expiry
+func changeExpiry(store *storage.FileStore, cfg *config.Config, fileID string, sf storage.StoredFile, minutes int) (storage.StoredFile, error) {
+	expiresAt := time.Now().Add(time.Duration(minutes) * time.Minute)
+	if maxExpiresAt := sf.UploadTime.Add(cfg.MaxLifetime()); expiresAt.After(maxExpiresAt) {
+		expiresAt = maxExpiresAt
+	}
+
+	updated, exists := store.SetExpiry(fileID, expiresAt)
+	if !exists {
+		return storage.StoredFile{}, errFileNotFound
+	}
+
+	store.BroadcastMessage(fileID, map[string]interface{}{
+		"event":     "expiry-changed",
+		"fileID":    fileID,
+		"expiresAt": updated.ExpiresAt,
+	})
+	return updated, nil
+}
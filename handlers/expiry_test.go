@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiryCutToMaxLifetime(t *testing.T) {
+	uploadHandler, _ := newTestHandlers(t)
+	expiryHandler := NewExpiryHandler(uploadHandler.Store, uploadHandler.Config, nil)
+	sf := upload(t, uploadHandler, "notes.txt", []byte("notes"))
+	maxExpiresAt := sf.UploadTime.Add(uploadHandler.Config.Current().MaxLifetime())
+
+	for _, minutes := range []string{"30", "100000"} {
+		r := httptest.NewRequest(http.MethodPost, "/expiry/"+sf.ID, strings.NewReader("minutes="+minutes))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Upload-Password", "secret")
+		w := httptest.NewRecorder()
+		expiryHandler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s minutes: status %d: %s", minutes, w.Code, w.Body)
+		}
+		var resp ExpiryResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.MaxExpiresAt.Equal(maxExpiresAt) {
+			t.Errorf("%s minutes: maxExpiresAt = %s, want %s", minutes, resp.MaxExpiresAt, maxExpiresAt)
+		}
+		if resp.ExpiresAt.After(maxExpiresAt) {
+			t.Errorf("%s minutes: expires at %s, after the maximum %s", minutes, resp.ExpiresAt, maxExpiresAt)
+		}
+		if minutes == "30" && time.Until(resp.ExpiresAt) < 29*time.Minute {
+			t.Errorf("30 minutes: expires at %s", resp.ExpiresAt)
+		}
+	}
+	if got, _ := uploadHandler.Store.Get(sf.ID); !got.ExpiresAt.Equal(maxExpiresAt) {
+		t.Errorf("stored expiry %s, want the maximum %s", got.ExpiresAt, maxExpiresAt)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go-quick-cli-upload-server/storage"
+)
+
+// FileInfo is the public metadata of a stored file returned by /info/{id}
+type FileInfo struct {
+	ID                 string                 `json:"id"`
+	Name               string                 `json:"name"`
+	Size               int64                  `json:"size"`
+	SHA256             string                 `json:"sha256"`
+	ContentType        string                 `json:"contentType,omitempty"`
+	Language           string                 `json:"language,omitempty"`
+	Image              *storage.ImageInfo     `json:"image,omitempty"`
+	ThumbnailURL       string                 `json:"thumbnailURL,omitempty"`
+	MetadataRemoved    int64                  `json:"metadataRemoved,omitempty"`
+	Archive            *storage.ArchiveInfo   `json:"archive,omitempty"`
+	Entries            []storage.ArchiveEntry `json:"entries,omitempty"` // the first entries of an archive
+	ExpiresAt          time.Time              `json:"expiresAt"`
+	RemainingDownloads int                    `json:"remainingDownloads"`
+}
+
+// InfoHandler serves the metadata of a file on /info/{id}, including the entries of an
+// archive, and the thumbnail of an image on /thumb/{id}. Neither counts as a download, so both
+// are safe for link previews and clients that want to know what they are about to download.
+// Browsers opening /info/{id} are sent to the preview page, which shows the same details.
+type InfoHandler struct {
+	Store *storage.FileStore
+}
+
+// NewInfoHandler creates a new InfoHandler
+func NewInfoHandler(store *storage.FileStore) *InfoHandler {
+	return &InfoHandler{Store: store}
+}
+
+// ServeHTTP implements http.Handler
+func (h *InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	route, fileID, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	sf, exists := h.Store.Get(fileID)
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if route == "thumb" {
+		h.serveThumbnail(w, r, sf)
+		return
+	}
+	// Paste pages count as a view, so pastes always get JSON
+	if needsLandingPage(r) && !strings.Contains(r.Header.Get("Accept"), "application/json") && sf.Language == "" {
+		http.Redirect(w, r, "/f/"+sf.ID, http.StatusFound)
+		return
+	}
+
+	name := sf.OriginalName
+	if name == "" {
+		name = sf.ID
+	}
+	info := FileInfo{
+		ID:                 sf.ID,
+		Name:               name,
+		Size:               sf.Size,
+		SHA256:             sf.SHA256,
+		ContentType:        sf.ContentType,
+		Language:           sf.Language,
+		Image:              sf.Image,
+		MetadataRemoved:    sf.MetadataRemoved,
+		Archive:            sf.Archive,
+		ExpiresAt:          sf.ExpiresAt,
+		RemainingDownloads: sf.RemainingDownloads,
+	}
+	if sf.HasThumbnail() {
+		info.ThumbnailURL = thumbnailURL(r, sf.ID)
+	}
+	if sf.Archive != nil {
+		entries, err := storage.ReadListing(sf)
+		if err != nil {
+			requestLogger(r).Warn("Error reading archive listing", "file_id", sf.ID, "error", err)
+		}
+		info.Entries = entries
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, info)
+}
+
+// serveThumbnail sends the JPEG thumbnail of an image
+func (h *InfoHandler) serveThumbnail(w http.ResponseWriter, r *http.Request, sf storage.StoredFile) {
+	if !sf.HasThumbnail() {
+		http.Error(w, "No thumbnail for this file", http.StatusNotFound)
+		return
+	}
+	thumb, err := os.Open(sf.ThumbnailPath())
+	if err != nil {
+		http.Error(w, "Failed to open thumbnail", http.StatusInternalServerError)
+		requestLogger(r).Error("Error opening thumbnail", "file_id", sf.ID, "error", err)
+		return
+	}
+	defer thumb.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	http.ServeContent(w, r, "", sf.UploadTime, thumb)
+}
+
+// infoURL returns the absolute URL of the metadata of a file
+func infoURL(r *http.Request, fileID string) string {
+	return fmt.Sprintf("%s://%s/info/%s", requestScheme(r), r.Host, fileID)
+}
+
+// thumbnailURL returns the absolute URL of the thumbnail of an image
+func thumbnailURL(r *http.Request, fileID string) string {
+	return fmt.Sprintf("%s://%s/thumb/%s", requestScheme(r), r.Host, fileID)
+}
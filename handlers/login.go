@@ -1,20 +1,25 @@
 package handlers
 
 import (
-	"log"
 	"net/http"
 
+	"go-quick-cli-upload-server/audit"
 	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/metrics"
 )
 
 // LoginHandler validates upload password
 type LoginHandler struct {
-	Config *config.Config
+	Config *config.Holder
+	Audit  *audit.Logger
 }
 
 // NewLoginHandler creates a new LoginHandler
-func NewLoginHandler(cfg *config.Config) *LoginHandler {
-	return &LoginHandler{Config: cfg}
+func NewLoginHandler(cfg *config.Holder, auditLog *audit.Logger) *LoginHandler {
+	return &LoginHandler{
+		Config: cfg,
+		Audit:  auditLog,
+	}
 }
 
 // ServeHTTP implements http.Handler
@@ -24,18 +29,15 @@ func (h *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	providedPassword := r.Header.Get("X-Upload-Password")
-	if providedPassword == "" {
-		providedPassword = r.FormValue("password")
-	}
-
-	if providedPassword == h.Config.UploadPassword {
+	if isUploadAuthorized(r, h.Config.Current()) {
 		w.Header().Set("Content-Type", "application/json")
 		if _, err := w.Write([]byte(`{"success": true}`)); err != nil {
-			log.Printf("Error writing login response: %v", err)
+			requestLogger(r).Error("Error writing login response", "error", err)
 		}
 	} else {
+		metrics.AuthFailuresTotal.Inc("login")
+		auditAuthFailure(h.Audit, r, "login")
 		http.Error(w, "Invalid password", http.StatusUnauthorized)
-		log.Printf("Failed login attempt from %s", r.RemoteAddr)
+		requestLogger(r).Warn("Failed login attempt", "client_ip", clientIP(r))
 	}
 }
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"net/netip"
+
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/metrics"
+)
+
+// MetricsHandler exposes Prometheus metrics, optionally restricted by IP and basic auth
+type MetricsHandler struct {
+	Config *config.Holder
+	Audit  *audit.Logger
+}
+
+// NewMetricsHandler creates a new MetricsHandler
+func NewMetricsHandler(cfg *config.Holder, auditLog *audit.Logger) *MetricsHandler {
+	return &MetricsHandler{
+		Config: cfg,
+		Audit:  auditLog,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := h.Config.Current()
+	if !h.isAllowedIP(r, cfg) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if cfg.MetricsUsername != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || !secureCompare(username, cfg.MetricsUsername) || !secureCompare(password, cfg.MetricsPassword) {
+			metrics.AuthFailuresTotal.Inc("metrics")
+			auditAuthFailure(h.Audit, r, "metrics")
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			requestLogger(r).Warn("Metrics access with invalid credentials", "client_ip", clientIP(r))
+			return
+		}
+	}
+
+	metrics.Handler().ServeHTTP(w, r)
+}
+
+// isAllowedIP checks the connection's remote address against the configured allow list.
+// Proxy headers are deliberately ignored because they can be set by any client.
+func (h *MetricsHandler) isAllowedIP(r *http.Request, cfg *config.Config) bool {
+	if len(cfg.MetricsAllowedIPs) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(remoteHost(r))
+	if err != nil {
+		return false
+	}
+	return containsAddr(cfg.MetricsAllowedIPs, addr.Unmap())
+}
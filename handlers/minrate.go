@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// errUploadTooSlow is returned when an upload body stalls or falls below the minimum transfer rate
+var errUploadTooSlow = errors.New("upload too slow")
+
+// minRateReader aborts a request body that does not deliver minBytes in every window, or whose
+// reads wait longer than stall for data. A read deadline on the connection bounds stalls, where
+// no Read call would ever return.
+type minRateReader struct {
+	body        io.ReadCloser
+	rc          *http.ResponseController
+	minBytes    int64
+	window      time.Duration
+	stall       time.Duration
+	windowStart time.Time
+	windowBytes int64
+	// rateDeadline is when the current window's quota must be met, zero without a minimum rate
+	rateDeadline time.Time
+	aborted      bool
+}
+
+// newMinRateReader wraps the request body of r, or returns it unchanged when neither the
+// minimum rate nor the stall timeout is enabled
+func newMinRateReader(w http.ResponseWriter, r *http.Request, minBytes int64, window, stall time.Duration) io.ReadCloser {
+	if minBytes <= 0 || window <= 0 {
+		minBytes = 0
+		if stall <= 0 {
+			return r.Body
+		}
+	}
+	mr := &minRateReader{
+		body:        r.Body,
+		rc:          http.NewResponseController(w),
+		minBytes:    minBytes,
+		window:      window,
+		stall:       max(stall, 0),
+		windowStart: time.Now(),
+	}
+	if minBytes > 0 {
+		mr.moveRateDeadline(mr.windowStart.Add(window))
+	}
+	return mr
+}
+
+// Read implements io.Reader
+func (mr *minRateReader) Read(p []byte) (int, error) {
+	if mr.stall > 0 {
+		// Only the time spent in Read counts as a stall, not the time between reads, when a
+		// relay receiver is slow for instance
+		deadline := time.Now().Add(mr.stall)
+		if !mr.rateDeadline.IsZero() && mr.rateDeadline.Before(deadline) {
+			deadline = mr.rateDeadline
+		}
+		mr.setDeadline(deadline)
+	}
+
+	n, err := mr.body.Read(p)
+	mr.windowBytes += int64(n)
+
+	if err != nil {
+		var netErr interface{ Timeout() bool }
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return n, mr.abort()
+		}
+		return n, err
+	}
+	if mr.minBytes == 0 {
+		return n, nil
+	}
+
+	now := time.Now()
+	windowEnd := mr.windowStart.Add(mr.window)
+	if now.After(windowEnd) {
+		if mr.windowBytes < mr.minBytes {
+			return n, mr.abort()
+		}
+		mr.windowStart = now
+		mr.windowBytes = 0
+		mr.moveRateDeadline(now.Add(mr.window))
+	} else if mr.windowBytes >= mr.minBytes && mr.windowBytes-int64(n) < mr.minBytes {
+		// This window's quota is met: the next one may use the whole of its own duration
+		mr.moveRateDeadline(windowEnd.Add(mr.window))
+	}
+	return n, nil
+}
+
+// moveRateDeadline sets when the current window's quota must be met. With a stall timeout,
+// Read applies it to the connection before every read.
+func (mr *minRateReader) moveRateDeadline(deadline time.Time) {
+	mr.rateDeadline = deadline
+	if mr.stall == 0 {
+		mr.setDeadline(deadline)
+	}
+}
+
+// abort makes every further read fail at once, so that neither the handler nor the
+// server's drain of the unread body waits on the slow client
+func (mr *minRateReader) abort() error {
+	mr.aborted = true
+	mr.setDeadline(time.Now())
+	return errUploadTooSlow
+}
+
+// Close implements io.Closer and lifts the read deadline of uploads that kept up.
+// Closing an aborted body is expected to fail on the expired deadline and is not reported.
+func (mr *minRateReader) Close() error {
+	if mr.aborted {
+		mr.body.Close()
+		return nil
+	}
+	mr.setDeadline(time.Time{})
+	return mr.body.Close()
+}
+
+// setDeadline moves the connection's read deadline. Writers without deadline
+// support fall back to the rate check in Read, which cannot catch a full stall.
+func (mr *minRateReader) setDeadline(deadline time.Time) {
+	_ = mr.rc.SetReadDeadline(deadline)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sendSlowly posts a 20-byte body to url, sending the first 10 bytes at once and the rest after delay
+func sendSlowly(t *testing.T, url string, delay time.Duration) {
+	t.Helper()
+	conn, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Length: 20\r\n\r\n0123456789")
+	time.Sleep(delay)
+	conn.Write([]byte("0123456789"))
+	io.Copy(io.Discard, conn)
+}
+
+func TestStallTimeout(t *testing.T) {
+	const stall = 200 * time.Millisecond
+	tests := []struct {
+		name      string
+		sendDelay time.Duration // pause of the client halfway through the body
+		readDelay time.Duration // pause of the handler between its reads
+		wantErr   error
+	}{
+		{name: "stalled client", sendDelay: 3 * stall, wantErr: errUploadTooSlow},
+		{name: "slow reader", readDelay: 3 * stall},
+		{name: "short pause", sendDelay: stall / 4},
+	}
+	for _, tt := range tests {
+		result := make(chan error, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := newMinRateReader(w, r, 0, 0, stall)
+			defer body.Close()
+			buf := make([]byte, 10)
+			_, err := io.ReadFull(body, buf)
+			if err == nil {
+				time.Sleep(tt.readDelay)
+				_, err = io.ReadFull(body, buf)
+			}
+			result <- err
+		}))
+		go sendSlowly(t, srv.URL, tt.sendDelay)
+
+		select {
+		case err := <-result:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: read error %v, want %v", tt.name, err, tt.wantErr)
+			}
+		case <-time.After(10 * stall):
+			t.Errorf("%s: the body was never aborted", tt.name)
+		}
+		srv.CloseClientConnections()
+		srv.Close()
+	}
+}
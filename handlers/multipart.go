@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// maxLeadingFieldBytes bounds the form fields read before the file part of a multipart upload
+const maxLeadingFieldBytes = 64 << 10
+
+// multipartUpload is a multipart body read up to its first file part: the fields sent before
+// it, such as the password, and the file part itself, which is streamed as the upload
+type multipartUpload struct {
+	fields url.Values
+	file   *multipart.Part
+	err    error
+}
+
+// withMultipartUpload reads the multipart body of r up to its first file part and stores the
+// result in the context of the returned request, for the password check and parseMultipartUpload.
+// The body must already be bounded by limitBody.
+func withMultipartUpload(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), multipartKey, readMultipartUpload(r)))
+}
+
+// multipartUploadOf returns the multipart upload read by withMultipartUpload, or nil
+func multipartUploadOf(r *http.Request) *multipartUpload {
+	mu, _ := r.Context().Value(multipartKey).(*multipartUpload)
+	return mu
+}
+
+// readMultipartUpload reads the fields of a multipart body until its first file part.
+// Fields after the file part are never read.
+func readMultipartUpload(r *http.Request) *multipartUpload {
+	mu := &multipartUpload{fields: url.Values{}}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		mu.err = err
+		return mu
+	}
+	remaining := int64(maxLeadingFieldBytes)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			mu.err = errors.New("no file part in multipart form")
+			return mu
+		}
+		if err != nil {
+			mu.err = err
+			return mu
+		}
+		if part.FileName() != "" {
+			mu.file = part
+			return mu
+		}
+		value, err := io.ReadAll(io.LimitReader(part, remaining+1))
+		if err != nil {
+			mu.err = err
+			return mu
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			mu.err = fmt.Errorf("form fields before the file exceed %d KB", maxLeadingFieldBytes>>10)
+			return mu
+		}
+		if name := part.FormName(); name != "" {
+			mu.fields.Add(name, string(value))
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/highlight"
+	"go-quick-cli-upload-server/metrics"
+	"go-quick-cli-upload-server/storage"
+)
+
+// linkPreviewAgents are substrings of the User-Agent of chat and social network unfurlers
+// that fetch links to show a preview. Other crawlers are recognised by their bot token.
+var linkPreviewAgents = []string{
+	"slackbot", "slack-imgproxy", "discordbot", "twitterbot", "facebookexternalhit", "whatsapp",
+	"telegrambot", "linkedinbot", "skypeuripreview", "microsoft teams", "redditbot",
+	"embedly", "iframely",
+}
+
+// PasteHandler shows text pastes as highlighted HTML pages on /p/{id}. Viewing the page
+// counts as a download; HEAD requests and link previews do not.
+type PasteHandler struct {
+	Store *storage.FileStore
+	Audit *audit.Logger
+}
+
+// NewPasteHandler creates a new PasteHandler
+func NewPasteHandler(store *storage.FileStore, auditLog *audit.Logger) *PasteHandler {
+	return &PasteHandler{
+		Store: store,
+		Audit: auditLog,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *PasteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := r.URL.Path[len("/p/"):]
+	rec := newAuditRecord(r, audit.EventDownload, audit.IdentityAnonymous)
+	rec.FileID = fileID
+
+	sf, exists := h.Store.Get(fileID)
+	if !exists || sf.Language == "" {
+		if r.Method == http.MethodGet {
+			metrics.DownloadsTotal.Inc("not_found")
+			rec.Detail = "paste not found"
+			h.Audit.Log(rec)
+		}
+		h.render(w, r, http.StatusNotFound, pastePage{Missing: true})
+		return
+	}
+
+	page := pastePage{
+		Name:      sf.OriginalName,
+		Language:  sf.Language,
+		Size:      formatFileSize(sf.Size),
+		ExpiresAt: sf.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"),
+	}
+	if r.Method == http.MethodHead || isLinkPreview(r) {
+		// Previews get the page without the content, leaving the paste untouched
+		page.Preview = true
+		h.render(w, r, http.StatusOK, page)
+		return
+	}
+
+	rec.FileName = sf.OriginalName
+	rec.Size = sf.Size
+	rec.SHA256 = sf.SHA256
+
+	data, err := readData(sf)
+	if err != nil {
+		metrics.DownloadsTotal.Inc("error")
+		rec.Detail = "failed to read paste"
+		h.Audit.Log(rec)
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		requestLogger(r).Error("Error reading paste", "file_id", fileID, "path", sf.Path, "error", err)
+		return
+	}
+
+	page.Lines = highlight.Lines(string(data), sf.Language)
+	page.Remaining = sf.RemainingDownloads - 1
+	if page.Remaining > 0 {
+		page.RawURL = "/raw/" + sf.ID
+	}
+
+	var buf bytes.Buffer
+	if err := pasteTemplate.Execute(&buf, page); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		requestLogger(r).Error("Error rendering paste", "file_id", fileID, "error", err)
+		return
+	}
+
+	record := newDownloadRecord(r)
+	setPageHeaders(w)
+	n, err := w.Write(buf.Bytes())
+	metrics.BytesOutTotal.Add(float64(n))
+	if err != nil {
+		metrics.DownloadsTotal.Inc("interrupted")
+		rec.Detail = fmt.Sprintf("transfer interrupted after %d bytes", n)
+		h.Audit.Log(rec)
+		h.Store.RecordDownload(fileID, record)
+		return
+	}
+	completeDownload(h.Store, h.Audit, r, rec, record, int64(n))
+}
+
+// render writes a paste page that does not count as a download
+func (h *PasteHandler) render(w http.ResponseWriter, r *http.Request, status int, page pastePage) {
+	setPageHeaders(w)
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := pasteTemplate.Execute(w, page); err != nil {
+		requestLogger(r).Warn("Error rendering paste page", "error", err)
+	}
+}
+
+// setPageHeaders sets the headers of the server-rendered pages, which need neither scripts
+// nor external resources
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+}
+
+// setPasteHeaders serves a paste as UTF-8 text, inline when it is fetched raw
+func setPasteHeaders(w http.ResponseWriter, originalName string, inline bool) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if inline {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": sanitizeFilename(originalName)}))
+	}
+}
+
+// pasteLanguage returns the language of a paste from the X-Language header or lang query
+// parameter, or else from its file name
+func pasteLanguage(r *http.Request, originalName string) string {
+	if lang := r.Header.Get("X-Language"); lang != "" {
+		return highlight.Normalize(lang)
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return highlight.Normalize(lang)
+	}
+	return highlight.FromFilename(originalName)
+}
+
+// isTextContent reports whether a Content-Type announces text that may be shown as a paste
+func isTextContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json"
+}
+
+// isLinkPreview reports whether the request comes from a crawler building a link preview
+func isLinkPreview(r *http.Request) bool {
+	userAgent := strings.ToLower(r.UserAgent())
+	for _, agent := range linkPreviewAgents {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return hasBotToken(userAgent)
+}
+
+// hasBotToken reports whether a lowercased User-Agent has a product token naming a bot, such
+// as Googlebot/2.1 or Mattermost-Bot/1.1. Words merely containing "bot" elsewhere do not count.
+func hasBotToken(userAgent string) bool {
+	tokens := strings.FieldsFunc(userAgent, func(r rune) bool {
+		return r == ' ' || r == ';' || r == '(' || r == ')' || r == ','
+	})
+	for _, token := range tokens {
+		if product, _, ok := strings.Cut(token, "/"); ok && strings.HasSuffix(product, "bot") {
+			return true
+		}
+	}
+	return false
+}
+
+// pasteURL returns the absolute URL of the page of a paste
+func pasteURL(r *http.Request, fileID string) string {
+	return fmt.Sprintf("%s://%s/p/%s", requestScheme(r), r.Host, fileID)
+}
+
+// rawURL returns the absolute URL of the plain text of a paste
+func rawURL(r *http.Request, fileID string) string {
+	return fmt.Sprintf("%s://%s/raw/%s", requestScheme(r), r.Host, fileID)
+}
+
+// pastePage is the data shown on a paste page
+type pastePage struct {
+	Name      string
+	Language  string
+	Size      string
+	ExpiresAt string
+	Lines     []template.HTML
+	Remaining int
+	RawURL    string
+	Preview   bool
+	Missing   bool
+}
+
+var pasteTemplate = template.Must(template.New("paste").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{if .Missing}}Paste not found{{else}}{{.Name}}{{end}}</title>
+{{if not .Missing}}<meta property="og:title" content="{{.Name}}">
+<meta property="og:description" content="Text paste ({{.Language}}, {{.Size}}), open the link to read it">{{end}}
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; color: #1f2328; background: #fff; }
+header { padding: .75rem 1rem; border-bottom: 1px solid #d0d7de; background: #f6f8fa; }
+header h1 { font-size: 1.1rem; margin: 0 0 .25rem; word-break: break-all; }
+.meta, .notice { color: #59636e; font-size: .875rem; }
+.notice { padding: .5rem 1rem; background: #fff8c5; border-bottom: 1px solid #d4a72c66; color: #1f2328; }
+table { border-collapse: collapse; width: 100%; font: .8125rem/1.5 ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; }
+td { padding: 0 .75rem; vertical-align: top; }
+td.ln { width: 1%; text-align: right; user-select: none; color: #8c959f; }
+td.ln a { color: inherit; text-decoration: none; }
+td.code { white-space: pre-wrap; word-break: break-all; }
+tr:target { background: #fff8c5; }
+.c { color: #6e7781; font-style: italic; } .s { color: #0a3069; } .n, .l { color: #0550ae; }
+.k { color: #cf222e; } .gi { color: #116329; background: #dafbe1; } .gd { color: #82071e; background: #ffebe9; }
+.gh { color: #8250df; }
+main.empty { padding: 2rem 1rem; }
+</style>
+</head>
+<body>
+{{if .Missing}}<main class="empty"><h1>Paste not found</h1>
+<p>This paste does not exist, has expired or has already been read.</p></main>
+{{else}}<header>
+<h1>{{.Name}}</h1>
+<div class="meta">{{.Language}} · {{.Size}} · expires {{.ExpiresAt}}{{if .RawURL}} · <a href="{{.RawURL}}">raw</a>{{end}}</div>
+</header>
+{{if .Preview}}<main class="empty"><p>Open this link in a browser to read the paste.</p></main>
+{{else}}{{if le .Remaining 0}}<div class="notice">This paste was deleted after this view; reloading the page will not show it again.</div>
+{{else}}<div class="notice">This paste can be opened {{.Remaining}} more time(s), including the raw link.</div>
+{{end}}<table>
+{{range $i, $line := .Lines}}<tr id="L{{inc $i}}"><td class="ln"><a href="#L{{inc $i}}">{{inc $i}}</a></td><td class="code">{{$line}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// readData returns the content of a stored file, decompressing it if it is stored compressed
+func readData(sf storage.StoredFile) ([]byte, error) {
+	f, err := storage.OpenData(sf)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
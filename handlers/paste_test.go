@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsLinkPreview(t *testing.T) {
+	tests := map[string]bool{
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)":                true,
+		"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)":         true,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":  true,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)": true,
+		"WhatsApp/2.23.20.0":            true,
+		"Mattermost-Bot/1.1":            true,
+		"TelegramBot (like TwitterBot)": true,
+		"Mozilla/5.0 (X11; Linux x86_64) Gecko/20100101 Firefox/128.0": false,
+		"curl/8.5.0":                 false,
+		"PreviewApp/1.0 (Macintosh)": false,
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8 Build/AP1A) Chrome/126.0 Mobile":     false,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) Robotics-Browser/3.1": false,
+		"": false,
+	}
+	for userAgent, want := range tests {
+		r := httptest.NewRequest("GET", "/raw/abc", nil)
+		r.Header.Set("User-Agent", userAgent)
+		if got := isLinkPreview(r); got != want {
+			t.Errorf("isLinkPreview(%q) = %v, want %v", userAgent, got, want)
+		}
+	}
+}
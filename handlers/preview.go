@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/storage"
+)
+
+// sniffLength is how many leading bytes http.DetectContentType looks at
+const sniffLength = 512
+
+// Preview kinds, deciding how the preview page embeds a file
+const (
+	previewImage = "image"
+	previewVideo = "video"
+	previewAudio = "audio"
+	previewPDF   = "pdf"
+	previewText  = "text"
+)
+
+// inlineImageTypes are the image formats browsers display without running any content.
+// SVG is missing on purpose: it can carry scripts.
+var inlineImageTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/bmp":                true,
+	"image/avif":               true,
+	"image/vnd.microsoft.icon": true,
+	"image/x-icon":             true,
+}
+
+// sniffBuffer keeps the first bytes written to it, for content type detection
+type sniffBuffer struct {
+	head []byte
+}
+
+func (s *sniffBuffer) Write(p []byte) (int, error) {
+	if n := sniffLength - len(s.head); n > 0 {
+		s.head = append(s.head, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// detectContentType determines the MIME type of an upload from its first bytes. The file
+// name extension only refines generic results, such as SVG sniffed as XML or media formats
+// the sniffer does not know; it never overrides a specific sniffed type.
+func detectContentType(head []byte, name string) string {
+	sniffed := http.DetectContentType(head)
+	mediaType, _, _ := mime.ParseMediaType(sniffed)
+	switch mediaType {
+	case "application/octet-stream", "text/plain", "text/xml":
+		if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+			return byExt
+		}
+	}
+	return sniffed
+}
+
+// previewKind returns how a file of the given content type can be shown inline, or "" if it
+// must only be downloaded, as its type is not among the preview types of cfg. Active content
+// such as HTML, SVG and XML is never shown inline.
+func previewKind(contentType string, cfg *config.Config) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !cfg.PreviewAllowed(mediaType) {
+		return ""
+	}
+	switch {
+	case inlineImageTypes[mediaType]:
+		return previewImage
+	case strings.HasPrefix(mediaType, "video/"):
+		return previewVideo
+	case strings.HasPrefix(mediaType, "audio/"):
+		return previewAudio
+	case mediaType == "application/pdf":
+		return previewPDF
+	case mediaType == "text/plain":
+		return previewText
+	}
+	return ""
+}
+
+// setInlineHeaders serves a previewable file for display in the browser. The stored type is
+// enforced with nosniff so that browsers cannot be tricked into rendering it as something else.
+func setInlineHeaders(w http.ResponseWriter, sf storage.StoredFile) {
+	contentType := sf.ContentType
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "text/plain" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": sanitizeFilename(sf.OriginalName)}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cross-Origin-Resource-Policy", "same-origin")
+}
+
+// PreviewHandler serves the browser-facing page of a file on /f/{id}, which embeds images,
+// video, audio, PDFs and text from /inline/{id}. It is also the landing page of download links
+// opened in a browser or by a link-preview bot. The page itself never counts as a download;
+// the content is only embedded after clicking Show preview, and loading it or clicking
+// Download counts like any other transfer of the file.
+type PreviewHandler struct {
+	Store  *storage.FileStore
+	Config *config.Holder
+}
+
+// NewPreviewHandler creates a new PreviewHandler
+func NewPreviewHandler(store *storage.FileStore, cfg *config.Holder) *PreviewHandler {
+	return &PreviewHandler{Store: store, Config: cfg}
+}
+
+// ServeHTTP implements http.Handler
+func (h *PreviewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID := r.URL.Path[len("/f/"):]
+	sf, exists := h.Store.Get(fileID)
+	if !exists {
+		h.render(w, r, http.StatusNotFound, previewPage{Missing: true})
+		return
+	}
+	if sf.Language != "" {
+		// Pastes have their own page
+		http.Redirect(w, r, "/p/"+fileID, http.StatusFound)
+		return
+	}
+
+	name := sf.OriginalName
+	if name == "" {
+		name = sf.ID
+	}
+	page := previewPage{
+		Name:        name,
+		Size:        formatFileSize(sf.Size),
+		ContentType: sf.ContentType,
+		ExpiresAt:   sf.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"),
+		Remaining:   sf.RemainingDownloads,
+		DownloadURL: "/download/" + sf.ID,
+		Kind:        previewKind(sf.ContentType, h.Config.Current()),
+	}
+	if page.Kind != "" {
+		page.InlineURL = "/inline/" + sf.ID
+		// Loading the embedded content is a download, so opening the link must not do it:
+		// the content is only embedded on request, and never for link-preview bots
+		page.Embed = r.URL.Query().Get("show") == "1" && !isLinkPreview(r)
+		page.ShowURL = fmt.Sprintf("/f/%s?show=1", sf.ID)
+	}
+	if sf.Image != nil {
+		page.Dimensions = fmt.Sprintf("%d×%d", sf.Image.Width, sf.Image.Height)
+	}
+	if sf.Archive != nil {
+		page.Archive = sf.Archive
+		entries, err := storage.ReadListing(sf)
+		if err != nil {
+			requestLogger(r).Warn("Error reading archive listing", "file_id", sf.ID, "error", err)
+		}
+		page.Entries = entries
+	}
+	if sf.HasThumbnail() {
+		page.ThumbnailURL = "/thumb/" + sf.ID
+		// Link previews need an absolute image URL
+		page.OGImage = thumbnailURL(r, sf.ID)
+	}
+	h.render(w, r, http.StatusOK, page)
+}
+
+// render writes the preview page
+func (h *PreviewHandler) render(w http.ResponseWriter, r *http.Request, status int, page previewPage) {
+	setPageHeaders(w)
+	// The embedded content comes from this server only
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; "+
+		"img-src 'self'; media-src 'self'; frame-src 'self'; object-src 'self'; form-action 'self'")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := previewTemplate.Execute(w, page); err != nil {
+		requestLogger(r).Warn("Error rendering preview page", "error", err)
+	}
+}
+
+// previewURL returns the absolute URL of the preview page of a file
+func previewURL(r *http.Request, fileID string) string {
+	return fmt.Sprintf("%s://%s/f/%s", requestScheme(r), r.Host, fileID)
+}
+
+// previewPage is the data shown on a preview page
+type previewPage struct {
+	Name         string
+	Size         string
+	ContentType  string
+	ExpiresAt    string
+	Remaining    int
+	DownloadURL  string
+	InlineURL    string
+	ShowURL      string
+	Kind         string
+	Dimensions   string
+	ThumbnailURL string
+	OGImage      string
+	Archive      *storage.ArchiveInfo
+	Entries      []storage.ArchiveEntry
+	Embed        bool
+	Missing      bool
+}
+
+var previewTemplate = template.Must(template.New("preview").Funcs(template.FuncMap{
+	"size": formatFileSize,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{if .Missing}}File not found{{else}}{{.Name}}{{end}}</title>
+{{if not .Missing}}<meta property="og:title" content="{{.Name}}">
+<meta property="og:description" content="{{.Size}}{{if .Dimensions}}, {{.Dimensions}}{{end}}, open the link to download">
+{{if .OGImage}}<meta property="og:image" content="{{.OGImage}}">
+{{end}}{{end}}<style>
+body { font-family: system-ui, sans-serif; margin: 0; color: #1f2328; background: #f6f8fa; }
+main { max-width: 60rem; margin: 0 auto; padding: 1.5rem 1rem; }
+h1 { font-size: 1.25rem; margin: 0 0 .25rem; word-break: break-all; }
+h2 { font-size: 1rem; margin: 1.5rem 0 .25rem; }
+.meta, .note { color: #59636e; font-size: .875rem; }
+.actions { margin: 1rem 0; display: flex; gap: .75rem; align-items: center; flex-wrap: wrap; }
+.actions form { margin: 0; }
+.button { display: inline-block; padding: .5rem 1.25rem; border: 0; border-radius: .375rem; background: #1f883d; color: #fff; text-decoration: none; font: inherit; font-weight: 600; cursor: pointer; }
+.button.secondary { background: #fff; color: #1f2328; border: 1px solid #d0d7de; }
+.preview { background: #fff; border: 1px solid #d0d7de; border-radius: .5rem; padding: .5rem; }
+.preview img, .preview video { display: block; max-width: 100%; max-height: 80vh; margin: 0 auto; }
+.preview img.thumbnail { max-height: 320px; }
+table.entries { width: 100%; border-collapse: collapse; font-size: .875rem; background: #fff; border: 1px solid #d0d7de; }
+table.entries th, table.entries td { padding: .25rem .75rem; text-align: left; border-bottom: 1px solid #d8dee4; }
+table.entries td.path { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; word-break: break-all; }
+table.entries td.num, table.entries th.num { text-align: right; white-space: nowrap; }
+.preview audio { width: 100%; }
+.preview iframe { width: 100%; height: 80vh; border: 0; }
+</style>
+</head>
+<body>
+<main>
+{{if .Missing}}<h1>File not found</h1>
+<p>This file does not exist, has expired or has already been downloaded.</p>
+{{else}}<h1>{{.Name}}</h1>
+<div class="meta">{{.Size}}{{if .ContentType}} · {{.ContentType}}{{end}}{{if .Dimensions}} · {{.Dimensions}}{{end}} · expires {{.ExpiresAt}} · {{.Remaining}} download(s) left</div>
+<div class="actions">
+<form method="post" action="{{.DownloadURL}}"><button class="button" type="submit">Download</button></form>
+{{if and .Kind (not .Embed)}}<a class="button secondary" href="{{.ShowURL}}">Show preview</a>
+<span class="note">{{if eq .Remaining 1}}Showing the preview uses the last download.{{else}}Showing the preview counts as a download.{{end}}</span>{{end}}
+</div>
+{{if .Embed}}<div class="preview">
+{{if eq .Kind "image"}}<img src="{{.InlineURL}}" alt="{{.Name}}">
+{{else if eq .Kind "video"}}<video src="{{.InlineURL}}" controls preload="metadata"></video>
+{{else if eq .Kind "audio"}}<audio src="{{.InlineURL}}" controls preload="metadata"></audio>
+{{else}}<iframe src="{{.InlineURL}}" title="{{.Name}}"></iframe>
+{{end}}</div>
+<p class="note">Loading the preview counts as a download once the whole file has been transferred.</p>
+{{else if .ThumbnailURL}}<div class="preview"><img class="thumbnail" src="{{.ThumbnailURL}}" alt="{{.Name}}"></div>
+{{end}}{{if .Archive}}<h2>Contents</h2>
+<p class="meta">{{.Archive.Format}} archive · {{.Archive.Count}}{{if .Archive.Truncated}}+{{end}} entries · {{size .Archive.TotalSize}} uncompressed</p>
+<table class="entries">
+<tr><th>Path</th><th class="num">Size</th><th class="num">Modified</th></tr>
+{{range .Entries}}<tr><td class="path">{{.Path}}</td><td class="num">{{if not .Dir}}{{size .Size}}{{end}}</td><td class="num">{{date .Modified}}</td></tr>
+{{end}}</table>
+{{if lt (len .Entries) .Archive.Count}}<p class="note">Only the first {{len .Entries}} entries are listed.</p>
+{{end}}{{if .Archive.Truncated}}<p class="note">The archive was only partly read, so it may contain more entries.</p>
+{{end}}{{else if and (not .Embed) (not .Kind)}}<p class="note">This file type cannot be previewed in the browser.</p>
+{{end}}{{end}}
+</main>
+</body>
+</html>
+`))
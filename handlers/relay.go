@@ -0,0 +1,523 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/metrics"
+	"go-quick-cli-upload-server/storage"
+)
+
+// relayIDPattern rejects paths that cannot be relay IDs before looking them up
+var relayIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	// errRelayBusy is returned when a relay ID already has a sender or receiver on the same side
+	errRelayBusy = errors.New("relay is already in use")
+	// errRelayNotFound is returned for relay IDs that were never created, were used or expired
+	errRelayNotFound = errors.New("relay not found or expired")
+	// errRelayIDTaken is returned when a client picks the ID of a file or upload request,
+	// whose WebSocket watchers share the relay's
+	errRelayIDTaken = errors.New("relay ID is in use by a file or upload request")
+)
+
+// RelayHub pairs relay senders with the receivers waiting on the same ID
+type RelayHub struct {
+	mu     sync.Mutex
+	relays map[string]*relay
+}
+
+// relay is a rendezvous between one sender and one receiver
+type relay struct {
+	id          string
+	expires     time.Time // until when the relay may be joined again after both sides left
+	hasSender   bool
+	hasReceiver bool
+	joined      chan struct{}    // closed once both sides are present
+	source      chan relaySource // handed from the sender to the receiver
+	result      chan relayResult // reported by the receiver to the sender
+}
+
+// relaySource is the sender's side of a transfer
+type relaySource struct {
+	body io.Reader
+	name string
+	size int64 // -1 when unknown
+}
+
+// relayResult is the outcome of a transfer as seen by the receiver
+type relayResult struct {
+	bytes     int64
+	senderErr error // reading from the sender failed
+	err       error // the transfer failed, whichever side caused it
+}
+
+// NewRelayHub creates an empty RelayHub
+func NewRelayHub() *RelayHub {
+	return &RelayHub{relays: make(map[string]*relay)}
+}
+
+// Active reports whether a sender or receiver is waiting on id or a transfer is in progress
+func (hub *RelayHub) Active(id string) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	_, ok := hub.relays[id]
+	return ok
+}
+
+// Create reserves a relay with a random, unguessable ID that both sides may join until ttl
+// has elapsed. Only one transfer goes through it.
+func (hub *RelayHub) Create(ttl time.Duration) (string, time.Time, error) {
+	id, err := storage.GenerateID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	rel := hub.add(id, ttl)
+	return id, rel.expires, nil
+}
+
+// add reserves a relay under id until ttl has elapsed.
+// Must be called with hub.mu lock held.
+func (hub *RelayHub) add(id string, ttl time.Duration) *relay {
+	rel := &relay{
+		id:      id,
+		expires: time.Now().Add(ttl),
+		joined:  make(chan struct{}),
+		source:  make(chan relaySource, 1),
+		result:  make(chan relayResult, 1),
+	}
+	hub.relays[id] = rel
+	return rel
+}
+
+// expire removes a relay whose reservation ran out unless a side is waiting on it, and
+// reports whether it did
+func (hub *RelayHub) expire(id string) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	rel, ok := hub.relays[id]
+	if !ok || rel.hasSender || rel.hasReceiver {
+		return false
+	}
+	delete(hub.relays, id)
+	return true
+}
+
+// join registers a sender or receiver on a relay. If no relay exists under id, it is reserved
+// until ttl has elapsed when create is set, and errRelayNotFound is returned otherwise.
+func (hub *RelayHub) join(id string, sender, create bool, ttl time.Duration) (rel *relay, created bool, err error) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	rel, ok := hub.relays[id]
+	if !ok {
+		if !create {
+			return nil, false, errRelayNotFound
+		}
+		rel, created = hub.add(id, ttl), true
+	}
+
+	if sender {
+		if rel.hasSender {
+			return nil, false, errRelayBusy
+		}
+		rel.hasSender = true
+	} else {
+		if rel.hasReceiver {
+			return nil, false, errRelayBusy
+		}
+		rel.hasReceiver = true
+	}
+
+	if rel.hasSender && rel.hasReceiver {
+		close(rel.joined)
+	}
+	return rel, created, nil
+}
+
+// leave withdraws a side that is still waiting for its peer and reports whether the relay,
+// now empty and past its reservation, was removed. It returns ok false if the peer joined in
+// the meantime, in which case the transfer must go ahead.
+func (hub *RelayHub) leave(rel *relay, sender bool) (empty, ok bool) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if rel.hasSender && rel.hasReceiver {
+		return false, false
+	}
+	if sender {
+		rel.hasSender = false
+	} else {
+		rel.hasReceiver = false
+	}
+	if !rel.hasSender && !rel.hasReceiver && time.Now().After(rel.expires) {
+		delete(hub.relays, rel.id)
+		return true, true
+	}
+	return false, true
+}
+
+// finish removes a relay once its transfer is over, freeing the ID for reuse
+func (hub *RelayHub) finish(rel *relay) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.relays[rel.id] == rel {
+		delete(hub.relays, rel.id)
+	}
+}
+
+// RelayHandler streams an upload straight to a waiting downloader without storing it.
+// POST /relay creates a relay with a server-generated ID, then PUT or POST /relay/{id} sends
+// and GET /relay/{id} receives; whichever side comes first waits. Authenticated clients may
+// also pick the ID themselves by joining a relay that does not exist yet.
+type RelayHandler struct {
+	Store    *storage.FileStore
+	Relays   *RelayHub
+	Requests *storage.RequestStore
+	Config   *config.Holder
+	Audit    *audit.Logger
+
+	draining atomic.Bool
+}
+
+// NewRelayHandler creates a new RelayHandler
+func NewRelayHandler(store *storage.FileStore, relays *RelayHub, requests *storage.RequestStore, cfg *config.Holder, auditLog *audit.Logger) *RelayHandler {
+	return &RelayHandler{
+		Store:    store,
+		Relays:   relays,
+		Requests: requests,
+		Config:   cfg,
+		Audit:    auditLog,
+	}
+}
+
+// RelayResponse describes a newly created relay
+type RelayResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *RelayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	relayID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/relay"), "/")
+	if relayID != "" && !relayIDPattern.MatchString(relayID) {
+		http.Error(w, errRelayNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if h.draining.Load() {
+		metrics.RelayTransfersTotal.Inc("rejected")
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Server is shutting down, please retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	if relayID == "" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleCreate(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		h.serveSender(w, r, relayID)
+	case http.MethodGet:
+		h.serveReceiver(w, r, relayID)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// StopAccepting makes the handler reject new relays while running transfers complete
+func (h *RelayHandler) StopAccepting() {
+	h.draining.Store(true)
+}
+
+// handleCreate creates a relay for an authenticated sender and returns its link
+func (h *RelayHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config.Current()
+	if !isUploadAuthorized(r, cfg) {
+		metrics.AuthFailuresTotal.Inc("relay")
+		auditAuthFailure(h.Audit, r, "relay")
+		http.Error(w, "Unauthorized: Invalid or missing password", http.StatusUnauthorized)
+		requestLogger(r).Warn("Relay creation with invalid password", "client_ip", clientIP(r))
+		return
+	}
+
+	relayID, expires, err := h.Relays.Create(cfg.RelayWait())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		requestLogger(r).Error("Failed to create relay", "error", err)
+		return
+	}
+	h.expireAfter(relayID, cfg.RelayWait())
+	requestLogger(r).Info("Relay created", "file_id", relayID, "expires_at", expires)
+
+	resp := RelayResponse{
+		ID:        relayID,
+		URL:       fmt.Sprintf("%s://%s/relay/%s", requestScheme(r), r.Host, relayID),
+		ExpiresAt: expires,
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, resp.URL)
+}
+
+// expireAfter forgets the relay once its reservation ran out if nobody is waiting on it
+func (h *RelayHandler) expireAfter(relayID string, wait time.Duration) {
+	time.AfterFunc(wait, func() {
+		if h.Relays.expire(relayID) {
+			h.Store.CloseWatchers(relayID, storage.ReasonExpired)
+		}
+	})
+}
+
+// join registers a side on the relay, reserving a client-chosen ID for authenticated clients
+// unless it belongs to a file or upload request: the end of the relay would otherwise close
+// their WebSocket watchers
+func (h *RelayHandler) join(r *http.Request, cfg *config.Config, relayID string, sender bool) (*relay, error) {
+	create := sender || isUploadAuthorized(r, cfg)
+	if create && !h.Relays.Active(relayID) && h.idTaken(relayID) {
+		return nil, errRelayIDTaken
+	}
+	rel, created, err := h.Relays.join(relayID, sender, create, cfg.RelayWait())
+	if created {
+		h.expireAfter(relayID, cfg.RelayWait())
+		requestLogger(r).Info("Relay created", "file_id", relayID, "client_chosen", true)
+	}
+	return rel, err
+}
+
+// serveSender waits for a receiver, hands it the request body and reports how the transfer went
+func (h *RelayHandler) serveSender(w http.ResponseWriter, r *http.Request, relayID string) {
+	cfg := h.Config.Current()
+	if !isUploadAuthorized(r, cfg) {
+		metrics.AuthFailuresTotal.Inc("relay")
+		auditAuthFailure(h.Audit, r, "relay")
+		http.Error(w, "Unauthorized: Invalid or missing password", http.StatusUnauthorized)
+		requestLogger(r).Warn("Relay attempt with invalid password", "client_ip", clientIP(r))
+		return
+	}
+
+	name := relayID
+	if decoded, err := url.PathUnescape(r.Header.Get("X-File-Name")); err == nil && decoded != "" {
+		name = path.Base(decoded)
+	}
+
+	rec := newAuditRecord(r, audit.EventRelay, audit.IdentityUploader)
+	rec.FileID = relayID
+	rec.FileName = name
+
+	maxBytes := cfg.RelayMaxBytes()
+	if r.ContentLength > maxBytes {
+		metrics.RelayTransfersTotal.Inc("rejected")
+		rec.Detail = "content length exceeds maximum relay size"
+		h.Audit.Log(rec)
+		http.Error(w, fmt.Sprintf("Transfer too large (max: %d MB)", cfg.RelayMaxSizeMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	rel, err := h.join(r, cfg, relayID, true)
+	if err != nil {
+		metrics.RelayTransfersTotal.Inc("rejected")
+		h.rejectJoin(w, err, "A sender is already waiting on this relay")
+		return
+	}
+	requestLogger(r).Info("Relay sender waiting", "file_id", relayID, "name", name, "size", r.ContentLength)
+
+	if !h.awaitPeer(r, rel, true, cfg.RelayWait()) {
+		rec.Detail = "no receiver joined"
+		h.Audit.Log(rec)
+		http.Error(w, fmt.Sprintf("No receiver joined within %d seconds", cfg.RelayWaitSecs), http.StatusRequestTimeout)
+		return
+	}
+
+	// The receiver copies the body to its response, so the sender is only read as fast as
+	// the receiver consumes. A minimum rate would hold a slow receiver against the sender, so
+	// only stalls of the sender itself are limited.
+	body := newMinRateReader(w, r, 0, 0, cfg.UploadStallTimeout())
+	defer body.Close()
+	rel.source <- relaySource{
+		body: http.MaxBytesReader(w, body, maxBytes),
+		name: name,
+		size: r.ContentLength,
+	}
+	result := <-rel.result
+	h.Relays.finish(rel)
+	rec.Size = result.bytes
+
+	if result.err != nil {
+		reason := result.err.Error()
+		status := http.StatusBadGateway
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(result.senderErr, &maxErr):
+			status = http.StatusRequestEntityTooLarge
+			reason = fmt.Sprintf("transfer too large (max: %d MB)", cfg.RelayMaxSizeMB)
+		case errors.Is(result.senderErr, errUploadTooSlow):
+			status = http.StatusRequestTimeout
+			reason = fmt.Sprintf("the sender stalled for %d seconds", cfg.UploadStallSecs)
+		}
+		metrics.RelayTransfersTotal.Inc("aborted")
+		h.Store.CloseWatchers(relayID, storage.ReasonAborted)
+		rec.Detail = reason
+		h.Audit.Log(rec)
+		requestLogger(r).Warn("Relay transfer aborted", "file_id", relayID, "bytes", result.bytes, "reason", reason)
+		w.Header().Set("Connection", "close")
+		http.Error(w, fmt.Sprintf("Relay aborted after %d bytes: %s", result.bytes, reason), status)
+		return
+	}
+
+	metrics.RelayTransfersTotal.Inc("success")
+	metrics.RelayBytesTotal.Add(float64(result.bytes))
+	h.Store.CloseWatchers(relayID, storage.ReasonDownloaded)
+	rec.Success = true
+	h.Audit.Log(rec)
+	requestLogger(r).Info("Relay transfer completed", "file_id", relayID, "bytes", result.bytes)
+	fmt.Fprintf(w, "Relayed %d bytes to the receiver\n", result.bytes)
+}
+
+// serveReceiver waits for a sender and streams its body into the response
+func (h *RelayHandler) serveReceiver(w http.ResponseWriter, r *http.Request, relayID string) {
+	cfg := h.Config.Current()
+	rel, err := h.join(r, cfg, relayID, false)
+	if err != nil {
+		metrics.RelayTransfersTotal.Inc("rejected")
+		h.rejectJoin(w, err, "A receiver is already waiting on this relay")
+		return
+	}
+	requestLogger(r).Info("Relay receiver waiting", "file_id", relayID)
+
+	if !h.awaitPeer(r, rel, false, cfg.RelayWait()) {
+		http.Error(w, fmt.Sprintf("No sender joined within %d seconds", cfg.RelayWaitSecs), http.StatusRequestTimeout)
+		return
+	}
+
+	src := <-rel.source
+	setDownloadHeaders(w, src.name, relayID)
+	if src.size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(src.size, 10))
+	}
+
+	body := &senderReader{r: src.body}
+	n, err := io.Copy(w, body)
+	result := relayResult{bytes: n, senderErr: body.err, err: err}
+	if err == nil && src.size >= 0 && n != src.size {
+		result.err = io.ErrUnexpectedEOF
+	}
+	rel.result <- result
+
+	if result.err != nil {
+		// Cut the connection so the receiver cannot mistake a truncated body for the whole file
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// idTaken reports whether id names a stored or recently removed file, an upload request or
+// the owner token of one
+func (h *RelayHandler) idTaken(id string) bool {
+	if _, ok := h.Store.Get(id); ok {
+		return true
+	}
+	if _, ok := h.Store.RemovedReason(id); ok {
+		return true
+	}
+	if _, ok := h.Requests.Get(id); ok {
+		return true
+	}
+	return h.Requests.HasOwner(id)
+}
+
+// rejectJoin answers a sender or receiver that could not join a relay
+func (h *RelayHandler) rejectJoin(w http.ResponseWriter, err error, busyMessage string) {
+	if errors.Is(err, errRelayNotFound) {
+		http.Error(w, "Relay not found or expired, create one with POST /relay or start the sender first", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errRelayIDTaken) {
+		http.Error(w, "This ID is taken, pick another one or create a relay with POST /relay", http.StatusConflict)
+		return
+	}
+	http.Error(w, busyMessage, http.StatusConflict)
+}
+
+// awaitPeer waits until the other side of the relay joins. It returns false if the wait timed
+// out or the client went away, after notifying the WebSocket watchers if the relay is abandoned.
+func (h *RelayHandler) awaitPeer(r *http.Request, rel *relay, sender bool, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var outcome string
+	select {
+	case <-rel.joined:
+	case <-timer.C:
+		outcome = "timeout"
+	case <-r.Context().Done():
+		outcome = "aborted"
+	}
+
+	if outcome != "" {
+		empty, ok := h.Relays.leave(rel, sender)
+		if ok {
+			metrics.RelayTransfersTotal.Inc(outcome)
+			if empty {
+				reason := storage.ReasonExpired
+				if outcome == "aborted" {
+					reason = storage.ReasonAborted
+				}
+				h.Store.CloseWatchers(rel.id, reason)
+			}
+			requestLogger(r).Info("Relay abandoned before the peer joined", "file_id", rel.id, "reason", outcome)
+			return false
+		}
+		// The peer arrived at the last moment: go ahead with the transfer
+	}
+
+	if sender {
+		h.Store.BroadcastMessage(rel.id, map[string]interface{}{
+			"event":  "relay-started",
+			"fileID": rel.id,
+		})
+	}
+	return true
+}
+
+// senderReader remembers the error of the sender's body, so that failures of the sender
+// can be told apart from failures of the receiver
+type senderReader struct {
+	r   io.Reader
+	err error
+}
+
+func (cr *senderReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if err != nil && err != io.EOF {
+		cr.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-quick-cli-upload-server/storage"
+)
+
+// isClosed reports whether ch is closed
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestRelayHubJoin(t *testing.T) {
+	hub := NewRelayHub()
+	if _, _, err := hub.join("r1", false, false, time.Minute); !errors.Is(err, errRelayNotFound) {
+		t.Fatalf("joining a missing relay without create: %v, want %v", err, errRelayNotFound)
+	}
+	if hub.Active("r1") {
+		t.Fatal("a failed join left a relay behind")
+	}
+
+	sender, created, err := hub.join("r1", true, true, time.Minute)
+	if err != nil || !created {
+		t.Fatalf("first sender: created %v, %v", created, err)
+	}
+	if _, _, err := hub.join("r1", true, true, time.Minute); !errors.Is(err, errRelayBusy) {
+		t.Errorf("second sender: %v, want %v", err, errRelayBusy)
+	}
+	if isClosed(sender.joined) {
+		t.Fatal("joined before the receiver came")
+	}
+
+	receiver, created, err := hub.join("r1", false, false, time.Minute)
+	if err != nil || created || receiver != sender {
+		t.Fatalf("receiver: created %v, same relay %v, %v", created, receiver == sender, err)
+	}
+	if !isClosed(sender.joined) {
+		t.Error("not joined once both sides are present")
+	}
+	if _, _, err := hub.join("r1", false, true, time.Minute); !errors.Is(err, errRelayBusy) {
+		t.Errorf("second receiver: %v, want %v", err, errRelayBusy)
+	}
+	if _, ok := hub.leave(sender, true); ok {
+		t.Error("a side left after its peer joined")
+	}
+
+	hub.finish(sender)
+	if hub.Active("r1") {
+		t.Error("finished relay still active")
+	}
+}
+
+func TestRelayHubLeave(t *testing.T) {
+	hub := NewRelayHub()
+	rel, _, _ := hub.join("r1", true, true, time.Minute)
+	if empty, ok := hub.leave(rel, true); empty || !ok {
+		t.Errorf("leaving within the reservation: empty %v, ok %v, want false, true", empty, ok)
+	}
+	if !hub.Active("r1") {
+		t.Fatal("relay dropped before its reservation ran out")
+	}
+	// The reservation holds, so the side may come back
+	if _, created, err := hub.join("r1", true, false, time.Minute); err != nil || created {
+		t.Fatalf("rejoining: created %v, %v", created, err)
+	}
+
+	expired, _, _ := hub.join("r2", false, true, -time.Second)
+	if empty, ok := hub.leave(expired, false); !empty || !ok {
+		t.Errorf("leaving after the reservation: empty %v, ok %v, want true, true", empty, ok)
+	}
+	if hub.Active("r2") {
+		t.Error("empty relay past its reservation still active")
+	}
+}
+
+func TestRelayHubExpire(t *testing.T) {
+	hub := NewRelayHub()
+	id, expires, err := hub.Create(time.Minute)
+	if err != nil || !relayIDPattern.MatchString(id) || time.Until(expires) <= 0 {
+		t.Fatalf("Create = %q, %s, %v", id, expires, err)
+	}
+	if !hub.expire(id) || hub.Active(id) {
+		t.Error("unused relay not expired")
+	}
+	if hub.expire(id) {
+		t.Error("expired twice")
+	}
+
+	rel, _, _ := hub.join("waiting", true, true, time.Minute)
+	if hub.expire("waiting") || !hub.Active("waiting") {
+		t.Error("expired a relay with a sender waiting")
+	}
+	hub.leave(rel, true)
+	if !hub.expire("waiting") {
+		t.Error("relay left by its sender not expired")
+	}
+}
+
+func TestRelayRejectsTakenIDs(t *testing.T) {
+	uploadHandler, _ := newTestHandlers(t)
+	requests := storage.NewRequestStore()
+	req, err := requests.Create(storage.UploadRequest{MaxSizeMB: 1, MaxFiles: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayHandler := NewRelayHandler(uploadHandler.Store, NewRelayHub(), requests, uploadHandler.Config, nil)
+	sf := upload(t, uploadHandler, "kept.txt", []byte("still here"))
+
+	for name, id := range map[string]string{"file": sf.ID, "request": req.ID, "owner token": req.OwnerToken} {
+		for _, method := range []string{http.MethodPut, http.MethodGet} {
+			r := httptest.NewRequest(method, "/relay/"+id, strings.NewReader("relayed"))
+			r.Header.Set("X-Upload-Password", "secret")
+			w := httptest.NewRecorder()
+			relayHandler.ServeHTTP(w, r)
+			if w.Code != http.StatusConflict {
+				t.Errorf("%s %s ID: status %d, want %d", method, name, w.Code, http.StatusConflict)
+			}
+		}
+		if relayHandler.Relays.Active(id) {
+			t.Errorf("a relay was created on the %s ID", name)
+		}
+	}
+	if _, ok := uploadHandler.Store.Get(sf.ID); !ok {
+		t.Error("the file is gone")
+	}
+}
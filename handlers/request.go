@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/metrics"
+	"go-quick-cli-upload-server/storage"
+)
+
+const (
+	// maxRequestFiles caps the number of files a single upload request may accept
+	maxRequestFiles = 100
+	// maxRequestNoteLength caps the note shown on the upload page, in characters
+	maxRequestNoteLength = 1000
+	// maxLongPollWait caps how long a status request may wait for new files
+	maxLongPollWait = 120 * time.Second
+)
+
+// RequestHandler serves upload requests, links that let someone without the upload password
+// send files to the request's creator
+type RequestHandler struct {
+	Store    *storage.FileStore
+	Requests *storage.RequestStore
+	Uploads  *UploadHandler
+	Config   *config.Holder
+	Audit    *audit.Logger
+}
+
+// NewRequestHandler creates a new RequestHandler
+func NewRequestHandler(store *storage.FileStore, requests *storage.RequestStore, uploads *UploadHandler,
+	cfg *config.Holder, auditLog *audit.Logger) *RequestHandler {
+	return &RequestHandler{
+		Store:    store,
+		Requests: requests,
+		Uploads:  uploads,
+		Config:   cfg,
+		Audit:    auditLog,
+	}
+}
+
+// RequestResponse describes an upload request to its creator
+type RequestResponse struct {
+	ID        string           `json:"id"`
+	Note      string           `json:"note,omitempty"`
+	UploadURL string           `json:"uploadURL"`
+	StatusURL string           `json:"statusURL"`
+	WatchURL  string           `json:"watchURL"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+	MaxFiles  int              `json:"maxFiles"`
+	MaxSizeMB int              `json:"maxSizeMB"`
+	Remaining int              `json:"remaining"`
+	Closed    bool             `json:"closed"` // the link expired, the files received stay listed
+	Files     []UploadResponse `json:"files"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/requests":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleCreate(w, r)
+
+	case strings.HasPrefix(r.URL.Path, "/requests/"):
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleStatus(w, r, r.URL.Path[len("/requests/"):])
+
+	case strings.HasPrefix(r.URL.Path, "/r/"):
+		// curl -T appends the file name to links ending in a slash: /r/{id}/{name}
+		id, _, _ := strings.Cut(r.URL.Path[len("/r/"):], "/")
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.handlePage(w, r, id)
+		case http.MethodPost, http.MethodPut:
+			h.handleUpload(w, r, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleCreate creates an upload request for an authenticated user
+func (h *RequestHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config.Current()
+	if !isUploadAuthorized(r, cfg) {
+		metrics.AuthFailuresTotal.Inc("request")
+		auditAuthFailure(h.Audit, r, "request")
+		http.Error(w, "Unauthorized: Invalid or missing password", http.StatusUnauthorized)
+		requestLogger(r).Warn("Upload request creation with invalid password", "client_ip", clientIP(r))
+		return
+	}
+
+	minutes, err := intFormValue(r, "minutes", cfg.FileExpiryMinutes, 1, cfg.MaxExpiryMinutes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxSizeMB, err := intFormValue(r, "maxSizeMB", cfg.MaxFileSizeMB, 1, cfg.MaxFileSizeMB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxFiles, err := intFormValue(r, "files", 1, 1, maxRequestFiles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	if utf8.RuneCountInString(note) > maxRequestNoteLength {
+		http.Error(w, fmt.Sprintf("note must be at most %d characters", maxRequestNoteLength), http.StatusBadRequest)
+		return
+	}
+
+	req, err := h.Requests.Create(storage.UploadRequest{
+		Note:      note,
+		MaxSizeMB: maxSizeMB,
+		MaxFiles:  maxFiles,
+	}, time.Duration(minutes)*time.Minute)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		requestLogger(r).Error("Failed to create upload request", "error", err)
+		return
+	}
+
+	rec := newAuditRecord(r, audit.EventRequestCreate, audit.IdentityUploader)
+	rec.Success = true
+	rec.FileID = req.ID
+	rec.Detail = fmt.Sprintf("%d files of at most %d MB, valid %d minutes", maxFiles, maxSizeMB, minutes)
+	h.Audit.Log(rec)
+	requestLogger(r).Info("Upload request created", "request_id", req.ID, "max_files", maxFiles, "expires_at", req.ExpiresAt)
+
+	resp := h.requestResponse(r, req)
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	var text string
+	if isTerminalRequest(r) {
+		if qrCode := generateTerminalQRCode(resp.UploadURL); qrCode != "" {
+			text = qrCode + "\n"
+		}
+	}
+	text += fmt.Sprintf("Upload request created!\nShare this upload link: %s\n", resp.UploadURL)
+	text += fmt.Sprintf("Accepts %d file(s) of at most %d MB until %s\n", maxFiles, maxSizeMB, req.ExpiresAt.Format(time.RFC3339))
+	text += fmt.Sprintf("Keep these private, they give access to the files:\n  Status: %s\n  Notifications: %s\n", resp.StatusURL, resp.WatchURL)
+	if _, err := w.Write([]byte(text)); err != nil {
+		requestLogger(r).Warn("Error writing response", "request_id", req.ID, "error", err)
+	}
+}
+
+// handleStatus shows the creator of a request the files received so far, also once the link
+// expired for as long as some of them are stored. With ?wait=SECONDS it long-polls until more
+// than ?since=N files have arrived or the link expires.
+func (h *RequestHandler) handleStatus(w http.ResponseWriter, r *http.Request, token string) {
+	req, changed, ok := h.Requests.GetByOwner(token)
+	if !ok {
+		http.Error(w, "Upload request not found or expired", http.StatusNotFound)
+		return
+	}
+
+	since, err := intFormValue(r, "since", 0, 0, maxRequestFiles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wait, err := intFormValue(r, "wait", 0, 0, int(maxLongPollWait/time.Second))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wait > 0 && len(req.Files) <= since {
+		timer := time.NewTimer(time.Duration(wait) * time.Second)
+		defer timer.Stop()
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+		if req, _, ok = h.Requests.GetByOwner(token); !ok {
+			http.Error(w, "Upload request not found or expired", http.StatusNotFound)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, h.requestResponse(r, req))
+}
+
+// handlePage shows the upload form of a request, or how to upload with curl to terminal clients
+func (h *RequestHandler) handlePage(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := h.Requests.Get(id)
+	if !ok {
+		if isTerminalRequest(r) {
+			http.Error(w, "Upload link not found or expired", http.StatusNotFound)
+			return
+		}
+		h.renderPage(w, r, http.StatusNotFound, requestPage{Error: "This upload link does not exist or has expired."})
+		return
+	}
+
+	if isTerminalRequest(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		text := "Upload request\n"
+		if req.Note != "" {
+			text += "Note: " + req.Note + "\n"
+		}
+		text += fmt.Sprintf("Accepts %d more file(s) of at most %d MB until %s\n", req.Remaining(), req.MaxSizeMB, req.ExpiresAt.Format(time.RFC3339))
+		text += fmt.Sprintf("Send a file with: curl -T FILE %s/\n", h.uploadURL(r, req.ID))
+		fmt.Fprint(w, text)
+		return
+	}
+
+	h.renderPage(w, r, http.StatusOK, newRequestPage(req))
+}
+
+// handleUpload receives a file sent through an upload request
+func (h *RequestHandler) handleUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if h.Uploads.rejectDraining(w) {
+		return
+	}
+
+	cfg := h.Config.Current()
+	strip, err := stripMetadataOption(r, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req, err := h.Requests.Reserve(id)
+	if err != nil {
+		metrics.UploadsTotal.Inc("rejected")
+		status := http.StatusNotFound
+		if errors.Is(err, storage.ErrRequestFull) {
+			status = http.StatusGone
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	rec := newAuditRecord(r, audit.EventUpload, audit.IdentityAnonymous)
+	rec.Detail = "upload request " + id
+	opts := uploadOptions{ExpiryMinutes: cfg.FileExpiryMinutes, MaxDownloads: 1, MaxBytes: int64(req.MaxSizeMB) << 20, StripMetadata: strip, RequestID: id}
+
+	limitBody(w, r, cfg, opts.MaxBytes)
+	sf, ok := h.Uploads.receive(w, r, cfg, opts, rec)
+	if !ok {
+		h.Requests.Release(id)
+		return
+	}
+
+	updated, ok := h.Requests.Complete(id, sf)
+	if !ok {
+		// Nobody can retrieve the file any more
+		h.Store.Revoke(sf.ID)
+		http.Error(w, storage.ErrRequestNotFound.Error(), http.StatusGone)
+		return
+	}
+	if _, exists := h.Store.Get(sf.ID); !exists {
+		// Deleted before the request recorded it, by an eviction for instance
+		h.Requests.FileRemoved(id, sf.ID)
+	}
+
+	h.Store.BroadcastMessage(req.OwnerToken, map[string]interface{}{
+		"event":     "file-received",
+		"fileID":    req.OwnerToken,
+		"requestID": id,
+		"file":      newUploadResponse(r, cfg, sf),
+		"remaining": updated.Remaining(),
+	})
+	requestLogger(r).Info("File received through upload request", "request_id", id, "file_id", sf.ID)
+
+	// The sender gets a receipt, never the download link
+	if isTerminalRequest(r) || r.Method == http.MethodPut {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "File received: %s (%s)\nThank you! This link accepts %d more file(s).\n",
+			sf.OriginalName, formatFileSize(sf.Size), updated.Remaining())
+		return
+	}
+	page := newRequestPage(updated)
+	page.Message = fmt.Sprintf("Received %s (%s). Thank you!", sf.OriginalName, formatFileSize(sf.Size))
+	h.renderPage(w, r, http.StatusOK, page)
+}
+
+// requestResponse describes a request and its received files to the creator
+func (h *RequestHandler) requestResponse(r *http.Request, req storage.UploadRequest) RequestResponse {
+	wsScheme := "ws"
+	if requestScheme(r) == "https" {
+		wsScheme = "wss"
+	}
+	cfg := h.Config.Current()
+	files := make([]UploadResponse, 0, len(req.Files))
+	for _, sf := range req.Files {
+		files = append(files, newUploadResponse(r, cfg, sf))
+	}
+	return RequestResponse{
+		ID:        req.ID,
+		Note:      req.Note,
+		UploadURL: h.uploadURL(r, req.ID),
+		StatusURL: fmt.Sprintf("%s://%s/requests/%s", requestScheme(r), r.Host, req.OwnerToken),
+		WatchURL:  fmt.Sprintf("%s://%s/ws/%s", wsScheme, r.Host, req.OwnerToken),
+		ExpiresAt: req.ExpiresAt,
+		MaxFiles:  req.MaxFiles,
+		MaxSizeMB: req.MaxSizeMB,
+		Remaining: req.Remaining(),
+		Closed:    req.Closed,
+		Files:     files,
+	}
+}
+
+// uploadURL returns the public upload link of a request
+func (h *RequestHandler) uploadURL(r *http.Request, id string) string {
+	return fmt.Sprintf("%s://%s/r/%s", requestScheme(r), r.Host, id)
+}
+
+// intFormValue parses an optional integer form or query value within [min, max]
+func intFormValue(r *http.Request, name string, def, min, max int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// requestPage is the data shown on the upload page of a request
+type requestPage struct {
+	Note      string
+	MaxSizeMB int
+	ExpiresAt string
+	Remaining int
+	Message   string
+	Error     string
+}
+
+func newRequestPage(req storage.UploadRequest) requestPage {
+	return requestPage{
+		Note:      req.Note,
+		MaxSizeMB: req.MaxSizeMB,
+		ExpiresAt: req.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"),
+		Remaining: req.Remaining(),
+	}
+}
+
+// renderPage writes the upload page, which works without JavaScript
+func (h *RequestHandler) renderPage(w http.ResponseWriter, r *http.Request, status int, page requestPage) {
+	setPageHeaders(w)
+	w.WriteHeader(status)
+	if err := requestPageTemplate.Execute(w, page); err != nil {
+		requestLogger(r).Warn("Error rendering upload request page", "error", err)
+	}
+}
+
+var requestPageTemplate = template.Must(template.New("request").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Send a file</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 32rem; margin: 3rem auto; padding: 0 1rem; color: #222; }
+.note { white-space: pre-wrap; background: #f4f4f5; padding: .75rem 1rem; border-radius: .5rem; }
+.message { color: #166534; } .error { color: #b91c1c; } .limits { color: #555; font-size: .9rem; }
+button { margin-top: 1rem; padding: .5rem 1.25rem; }
+</style>
+</head>
+<body>
+<h1>Send a file</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{else}}
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+{{if .Note}}<p class="note">{{.Note}}</p>{{end}}
+{{if gt .Remaining 0}}
+<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" required>
+<br><button type="submit">Upload</button>
+</form>
+<p class="limits">Up to {{.Remaining}} more file(s) of at most {{.MaxSizeMB}} MB, until {{.ExpiresAt}}.</p>
+{{else}}<p>This link has received all the files it accepts.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -3,17 +3,29 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"go-quick-cli-upload-server/audit"
 	"go-quick-cli-upload-server/config"
+	"go-quick-cli-upload-server/highlight"
+	"go-quick-cli-upload-server/imagemeta"
+	"go-quick-cli-upload-server/metrics"
+	"go-quick-cli-upload-server/processing"
 	"go-quick-cli-upload-server/storage"
 
 	"github.com/mdp/qrterminal/v3"
@@ -21,18 +33,78 @@ import (
 
 // UploadHandler handles file upload requests via POST or PUT
 type UploadHandler struct {
-	Store  *storage.FileStore
-	Config *config.Config
+	Store    *storage.FileStore
+	Config   *config.Holder
+	Audit    *audit.Logger
+	Pipeline *processing.Pipeline // runs on each upload before it becomes available
+
+	draining atomic.Bool
 }
 
 // NewUploadHandler creates a new UploadHandler
-func NewUploadHandler(store *storage.FileStore, cfg *config.Config) *UploadHandler {
+func NewUploadHandler(store *storage.FileStore, cfg *config.Holder, auditLog *audit.Logger, pipeline *processing.Pipeline) *UploadHandler {
 	return &UploadHandler{
-		Store:  store,
-		Config: cfg,
+		Store:    store,
+		Config:   cfg,
+		Audit:    auditLog,
+		Pipeline: pipeline,
 	}
 }
 
+// uploadOptions are the limits and settings applied to an upload
+type uploadOptions struct {
+	ExpiryMinutes int
+	MaxDownloads  int
+	MaxBytes      int64
+	// Paste is set for uploads to /paste, which are always stored as text pastes;
+	// DetectPaste for text uploads, which become pastes when they are small enough
+	Paste       bool
+	DetectPaste bool
+	// StripMetadata removes EXIF and other metadata from JPEG and PNG files as they are saved
+	StripMetadata bool
+	// Digests are checksums sent by the client that the received bytes must match
+	Digests []uploadDigest
+	// RequestID is the upload request the file is sent through, whose quota it counts against
+	RequestID string
+}
+
+// UploadResponse describes a stored upload to clients that accept application/json
+type UploadResponse struct {
+	ID           string               `json:"id"`
+	Name         string               `json:"name"`
+	Size         int64                `json:"size"`
+	SHA256       string               `json:"sha256"`
+	URL          string               `json:"url"`
+	ContentType  string               `json:"contentType,omitempty"`
+	PreviewURL   string               `json:"previewURL,omitempty"`
+	ViewURL      string               `json:"viewURL,omitempty"`
+	Language     string               `json:"language,omitempty"`
+	Image        *storage.ImageInfo   `json:"image,omitempty"`
+	Archive      *storage.ArchiveInfo `json:"archive,omitempty"`
+	ThumbnailURL string               `json:"thumbnailURL,omitempty"`
+	// MetadataStripped is set when EXIF or other metadata was removed from an image
+	MetadataStripped bool      `json:"metadataStripped,omitempty"`
+	MetadataRemoved  int64     `json:"metadataRemoved,omitempty"`
+	InfoURL          string    `json:"infoURL"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	MaxDownloads     int       `json:"maxDownloads"`
+}
+
+// savedFile describes an upload written to disk
+type savedFile struct {
+	Path   string // the blob holding the data, shared with identical uploads
+	Size   int64
+	SHA256 string
+	Head   []byte // first bytes, for content type detection
+	// Encoding and StoredSize describe the blob when it is stored compressed
+	Encoding   string
+	StoredSize int64
+	// Deduplicated is set when another stored file already had the same content
+	Deduplicated bool
+	// MetadataRemoved is the number of metadata bytes stripped from an image
+	MetadataRemoved int64
+}
+
 // ServeHTTP implements http.Handler
 func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodPut {
@@ -40,56 +112,316 @@ func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.validatePassword(r) {
+	if h.rejectDraining(w) {
+		return
+	}
+
+	cfg := h.Config.Current()
+	limitBody(w, r, cfg, max(cfg.MaxFileBytes(), cfg.MaxPasteBytes()))
+	if h.isMultipartRequest(r.Header.Get("Content-Type")) {
+		// The password may be a form field sent before the file
+		r = withMultipartUpload(r)
+	}
+	if !h.validatePassword(r, cfg) {
+		metrics.AuthFailuresTotal.Inc("upload")
+		metrics.UploadsTotal.Inc("unauthorized")
+		auditAuthFailure(h.Audit, r, "upload")
+		// Close rather than drain the unread body of the rejected upload
+		w.Header().Set("Connection", "close")
 		http.Error(w, "Unauthorized: Invalid or missing password", http.StatusUnauthorized)
-		log.Printf("Upload attempt with invalid password from %s", r.RemoteAddr)
+		requestLogger(r).Warn("Upload attempt with invalid password", "client_ip", clientIP(r))
 		return
 	}
 
-	maxBytes := h.Config.MaxFileBytes()
+	rec := newAuditRecord(r, audit.EventUpload, audit.IdentityUploader)
+	opts, err := parseUploadOptions(r, cfg)
+	if err != nil {
+		metrics.UploadsTotal.Inc("rejected")
+		h.auditFailure(rec, "", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sf, ok := h.receive(w, r, cfg, opts, rec)
+	if !ok {
+		return
+	}
+	h.sendSuccessResponse(w, r, cfg, sf)
+}
+
+// rejectDraining refuses an upload while the server shuts down and reports whether it did
+func (h *UploadHandler) rejectDraining(w http.ResponseWriter) bool {
+	if !h.draining.Load() {
+		return false
+	}
+	metrics.UploadsTotal.Inc("rejected")
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "Server is shutting down, please retry shortly", http.StatusServiceUnavailable)
+	return true
+}
+
+// multipartOverhead is the room left in a request body for the headers and boundaries of a
+// multipart form beyond the size limit of the file in it
+const multipartOverhead = 1 << 20
+
+// limitBody bounds the body of an upload before anything reads it, the password check
+// included: bodies that stall or trickle in below the minimum rate are aborted, and reading
+// past maxBytes and the multipart overhead fails
+func limitBody(w http.ResponseWriter, r *http.Request, cfg *config.Config, maxBytes int64) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+	r.Body = newMinRateReader(w, r, cfg.MinUploadBytesPerWindow(), cfg.UploadRateWindow(), cfg.UploadStallTimeout())
+}
+
+// receive reads an upload within the limits of opts, saves it and adds it to the store.
+// rec is the audit record template describing the client. On failure the client has been
+// answered and ok is false.
+func (h *UploadHandler) receive(w http.ResponseWriter, r *http.Request, cfg *config.Config, opts uploadOptions, rec audit.Record) (
+	sf storage.StoredFile, ok bool) {
+
+	maxBytes := opts.MaxBytes
 	ct := r.Header.Get("Content-Type")
 	isMultipart := h.isMultipartRequest(ct)
 
 	if !isMultipart && r.ContentLength > maxBytes {
-		http.Error(w, fmt.Sprintf("File too large (max: %d MB)", h.Config.MaxFileSizeMB), http.StatusRequestEntityTooLarge)
-		log.Printf("Rejected upload: Content-Length %d exceeds max %d bytes", r.ContentLength, maxBytes)
+		metrics.UploadsTotal.Inc("rejected")
+		h.auditFailure(rec, "", "content length exceeds maximum size")
+		http.Error(w, fmt.Sprintf("File too large (max: %s)", opts.limit()), http.StatusRequestEntityTooLarge)
+		requestLogger(r).Warn("Rejected upload: Content-Length exceeds maximum", "content_length", r.ContentLength, "max_bytes", maxBytes)
+		return
+	}
+
+	if !isMultipart {
+		// Checksum headers of a multipart request describe the form, not the file in it
+		digests, err := parseUploadDigests(r.Header)
+		if err != nil {
+			metrics.UploadsTotal.Inc("rejected")
+			h.auditFailure(rec, "", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Digests = digests
+	}
+
+	// Multipart bodies are a little larger than the file in them, which errs on the safe side
+	res, err := h.Store.Reserve(storage.QuotaOwner(opts.RequestID, clientIP(r)), max(r.ContentLength, 0), storageLimits(cfg))
+	if err != nil {
+		h.rejectInsufficientStorage(w, r, rec, "", err)
 		return
 	}
+	defer res.Release()
 
 	fileID, err := storage.GenerateID()
 	if err != nil {
+		metrics.UploadsTotal.Inc("error")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		log.Printf("Failed to generate file ID: %v", err)
+		requestLogger(r).Error("Failed to generate file ID", "error", err)
 		return
 	}
 
-	originalName, src, closeSrc, err := h.parseUpload(r, isMultipart, maxBytes)
+	originalName, src, closeSrc, err := h.parseUpload(r, opts, isMultipart)
+	if errors.Is(err, errUploadTooSlow) {
+		h.rejectSlowUpload(w, r, cfg, rec, originalName)
+		return
+	}
 	if err != nil {
+		metrics.UploadsTotal.Inc("rejected")
+		h.auditFailure(rec, originalName, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer closeSrc()
 
-	fileSize, err := h.saveFile(fileID, src, maxBytes)
+	saved, err := h.saveFile(cfg, opts, res, fileID, originalName, src)
+	if errors.Is(err, errUploadTooSlow) {
+		h.rejectSlowUpload(w, r, cfg, rec, originalName)
+		return
+	}
+	if errors.Is(err, storage.ErrInsufficientStorage) {
+		h.rejectInsufficientStorage(w, r, rec, originalName, err)
+		return
+	}
+	if errors.Is(err, errDigestMismatch) {
+		metrics.UploadsTotal.Inc("rejected")
+		h.auditFailure(rec, originalName, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		requestLogger(r).Warn("Rejected upload: checksum mismatch", "original_name", originalName, "error", err)
+		return
+	}
 	if err != nil {
+		metrics.UploadsTotal.Inc("error")
+		h.auditFailure(rec, originalName, err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	fileSize := saved.Size
+	metrics.UploadsTotal.Inc("success")
+	metrics.BytesInTotal.Add(float64(fileSize))
+	metrics.UploadSizeBytes.Observe(float64(fileSize))
+
+	language := ""
+	if opts.Paste || (opts.DetectPaste && fileSize <= cfg.MaxPasteBytes()) {
+		language = pasteLanguage(r, originalName)
+		if originalName == "" || (opts.Paste && originalName == "paste") {
+			originalName = "paste." + highlight.Extension(language)
+		}
+	}
 
-	filePath := filepath.Join(h.Config.UploadDir, fileID)
-	h.Store.Add(fileID, filePath, originalName, h.Config.FileExpiryMinutes)
+	sf = storage.StoredFile{
+		ID:                 fileID,
+		Path:               saved.Path,
+		OriginalName:       originalName,
+		Size:               fileSize,
+		SHA256:             saved.SHA256,
+		Blob:               saved.SHA256,
+		Encoding:           saved.Encoding,
+		StoredSize:         saved.StoredSize,
+		UploaderIP:         clientIP(r),
+		UploaderAgent:      r.UserAgent(),
+		RequestID:          opts.RequestID,
+		RemainingDownloads: opts.MaxDownloads,
+		ContentType:        detectContentType(saved.Head, originalName),
+		Language:           language,
+		MetadataRemoved:    saved.MetadataRemoved,
+	}
+	processed := false
+	if saved.Deduplicated && language == "" {
+		// The thumbnail and listing of identical content are already stored
+		if other, found := h.Store.FindBlob(sf.Path); found && other.Language == "" {
+			sf.Image, sf.Archive = other.Image, other.Archive
+			processed = true
+		}
+	}
+	if !processed && language == "" && h.Pipeline != nil {
+		// Pastes are text and have nothing to process
+		h.Pipeline.Run(&sf)
+	}
+	sf = h.Store.Add(fileID, sf, opts.ExpiryMinutes)
+
+	rec.Success = true
+	rec.FileID = fileID
+	rec.FileName = originalName
+	rec.Size = fileSize
+	rec.SHA256 = saved.SHA256
+	h.Audit.Log(rec)
+
+	requestLogger(r).Info("File uploaded", "file_id", fileID, "original_name", originalName, "size", fileSize,
+		"language", language, "deduplicated", saved.Deduplicated)
+	return sf, true
+}
 
-	h.sendSuccessResponse(w, r, fileID, originalName, fileSize)
-	log.Printf("File uploaded: %s (original: %s, size: %d bytes)", fileID, originalName, fileSize)
+// rejectSlowUpload answers an upload aborted for being too slow; saveFile already removed its data
+func (h *UploadHandler) rejectSlowUpload(w http.ResponseWriter, r *http.Request, cfg *config.Config, rec audit.Record, originalName string) {
+	metrics.UploadsTotal.Inc("timeout")
+	h.auditFailure(rec, originalName, errUploadTooSlow.Error())
+	requestLogger(r).Warn("Aborted upload below minimum transfer rate or stalled",
+		"original_name", originalName, "min_rate_kbps", cfg.MinUploadRateKBps, "stall_timeout_seconds", cfg.UploadStallSecs)
+	var limits []string
+	if cfg.MinUploadRateKBps > 0 {
+		limits = append(limits, fmt.Sprintf("minimum rate: %d KB/s", cfg.MinUploadRateKBps))
+	}
+	if cfg.UploadStallSecs > 0 {
+		limits = append(limits, fmt.Sprintf("stall timeout: %d seconds", cfg.UploadStallSecs))
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, fmt.Sprintf("Upload too slow (%s)", strings.Join(limits, ", ")), http.StatusRequestTimeout)
 }
 
-// validatePassword checks if the provided password matches the configured password
-func (h *UploadHandler) validatePassword(r *http.Request) bool {
-	providedPassword := r.Header.Get("X-Upload-Password")
-	if providedPassword == "" {
-		providedPassword = r.FormValue("password")
+// rejectInsufficientStorage answers an upload that does not fit in the storage quotas or the
+// free disk space with 507 Insufficient Storage
+func (h *UploadHandler) rejectInsufficientStorage(w http.ResponseWriter, r *http.Request, rec audit.Record, originalName string, err error) {
+	metrics.UploadsTotal.Inc("rejected")
+	h.auditFailure(rec, originalName, err.Error())
+	requestLogger(r).Warn("Rejected upload: insufficient storage", "original_name", originalName, "error", err)
+	w.Header().Set("Connection", "close")
+	http.Error(w, err.Error(), http.StatusInsufficientStorage)
+}
+
+// storageLimits returns the storage quotas and free space watermark uploads must fit in
+func storageLimits(cfg *config.Config) storage.Limits {
+	return storage.Limits{
+		Quota:      cfg.StorageQuotaBytes(),
+		OwnerQuota: cfg.ClientQuotaBytes(),
+		MinFree:    cfg.MinFreeBytes(),
+		Evict:      cfg.EvictOnLowSpace,
 	}
-	return providedPassword == h.Config.UploadPassword
+}
+
+// auditFailure records an upload that was rejected or failed, based on the template rec
+func (h *UploadHandler) auditFailure(rec audit.Record, originalName, reason string) {
+	rec.FileName = originalName
+	rec.Detail = reason
+	h.Audit.Log(rec)
+}
+
+// StopAccepting makes the handler reject new uploads while in-flight ones complete
+func (h *UploadHandler) StopAccepting() {
+	h.draining.Store(true)
+}
+
+// parseUploadOptions reads the optional X-Expiry-Minutes and X-Max-Downloads headers,
+// defaulting to the configured expiry and a single download, and detects text pastes
+func parseUploadOptions(r *http.Request, cfg *config.Config) (uploadOptions, error) {
+	opts := uploadOptions{ExpiryMinutes: cfg.FileExpiryMinutes, MaxDownloads: 1, MaxBytes: cfg.MaxFileBytes()}
+
+	switch {
+	case r.URL.Path == "/paste" || strings.HasPrefix(r.URL.Path, "/paste/"):
+		opts.Paste = true
+		opts.MaxBytes = cfg.MaxPasteBytes()
+	case isTextContent(r.Header.Get("Content-Type")):
+		opts.DetectPaste = true
+	}
+
+	strip, err := stripMetadataOption(r, cfg)
+	if err != nil {
+		return opts, err
+	}
+	// Pastes are text and have no metadata to strip
+	opts.StripMetadata = strip && !opts.Paste
+
+	if value := r.Header.Get("X-Expiry-Minutes"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes <= 0 || minutes > cfg.MaxExpiryMinutes {
+			return opts, fmt.Errorf("X-Expiry-Minutes must be between 1 and %d", cfg.MaxExpiryMinutes)
+		}
+		opts.ExpiryMinutes = minutes
+	}
+
+	if value := r.Header.Get("X-Max-Downloads"); value != "" {
+		downloads, err := strconv.Atoi(value)
+		if err != nil || downloads <= 0 || downloads > cfg.MaxDownloads {
+			return opts, fmt.Errorf("X-Max-Downloads must be between 1 and %d", cfg.MaxDownloads)
+		}
+		opts.MaxDownloads = downloads
+	}
+
+	return opts, nil
+}
+
+// stripMetadataOption reads the X-Strip-Metadata header, defaulting to the configured setting
+func stripMetadataOption(r *http.Request, cfg *config.Config) (bool, error) {
+	value := r.Header.Get("X-Strip-Metadata")
+	if value == "" {
+		return cfg.StripMetadata, nil
+	}
+	strip, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("X-Strip-Metadata must be true or false")
+	}
+	return strip, nil
+}
+
+// limit describes the size limit of the upload for error messages
+func (opts uploadOptions) limit() string {
+	if opts.MaxBytes%(1<<20) == 0 {
+		return fmt.Sprintf("%d MB", opts.MaxBytes>>20)
+	}
+	return fmt.Sprintf("%d KB", opts.MaxBytes>>10)
+}
+
+// validatePassword checks if the provided password matches the configured password
+func (h *UploadHandler) validatePassword(r *http.Request, cfg *config.Config) bool {
+	return isUploadAuthorized(r, cfg)
 }
 
 // isMultipartRequest checks if the Content-Type indicates multipart form data
@@ -99,59 +431,41 @@ func (h *UploadHandler) isMultipartRequest(contentType string) bool {
 }
 
 // parseUpload extracts the file from either multipart or raw upload
-func (h *UploadHandler) parseUpload(r *http.Request, isMultipart bool, maxBytes int64) (
+func (h *UploadHandler) parseUpload(r *http.Request, opts uploadOptions, isMultipart bool) (
 	originalName string, src io.ReadCloser, closeSrc func(), err error) {
 
 	closeSrc = func() {}
 
 	if isMultipart {
-		return h.parseMultipartUpload(r, maxBytes)
+		return h.parseMultipartUpload(r, opts)
 	}
 	return h.parseRawUpload(r)
 }
 
 // parseMultipartUpload handles multipart/form-data uploads
-func (h *UploadHandler) parseMultipartUpload(r *http.Request, maxBytes int64) (
+func (h *UploadHandler) parseMultipartUpload(r *http.Request, opts uploadOptions) (
 	originalName string, src io.ReadCloser, closeSrc func(), err error) {
 
-	maxMemory := h.Config.MaxFileBytes()
-	if parseErr := r.ParseMultipartForm(maxMemory); parseErr != nil {
-		err = fmt.Errorf("failed to parse multipart form (max size: %d MB)", h.Config.MaxFileSizeMB)
-		return
+	closeSrc = func() {}
+	mu := multipartUploadOf(r)
+	if mu == nil {
+		mu = readMultipartUpload(r)
 	}
-
-	var fh *multipart.FileHeader
-	if r.MultipartForm != nil {
-		for _, fhs := range r.MultipartForm.File {
-			if len(fhs) > 0 {
-				fh = fhs[0]
-				break
-			}
+	if mu.err != nil {
+		if errors.Is(mu.err, errUploadTooSlow) {
+			err = mu.err
+			return
 		}
-	}
-
-	if fh == nil {
-		err = fmt.Errorf("no file part in multipart form")
+		err = fmt.Errorf("failed to parse multipart form (max size: %s): %w", opts.limit(), mu.err)
 		return
 	}
 
-	if fh.Size > maxBytes {
-		err = fmt.Errorf("file too large (max: %d MB)", h.Config.MaxFileSizeMB)
-		log.Printf("Rejected upload: File %s size %d exceeds max %d bytes", fh.Filename, fh.Size, maxBytes)
-		return
-	}
-
-	originalName = fh.Filename
-	file, openErr := fh.Open()
-	if openErr != nil {
-		err = fmt.Errorf("failed to open uploaded file: %w", openErr)
-		return
-	}
-
-	src = file
+	// The file part is streamed; saveFile enforces the size limit while reading it
+	originalName = mu.file.FileName()
+	src = mu.file
 	closeSrc = func() {
-		if closeErr := file.Close(); closeErr != nil {
-			log.Printf("Error closing uploaded file: %v", closeErr)
+		if closeErr := mu.file.Close(); closeErr != nil {
+			requestLogger(r).Warn("Error closing uploaded file", "error", closeErr)
 		}
 	}
 	return
@@ -161,87 +475,260 @@ func (h *UploadHandler) parseMultipartUpload(r *http.Request, maxBytes int64) (
 func (h *UploadHandler) parseRawUpload(r *http.Request) (
 	originalName string, src io.ReadCloser, closeSrc func(), err error) {
 
-	// Extract filename from URL path
-	if p := path.Base(r.URL.Path); p != "" && p != "/" {
+	// Extract filename from the X-File-Name header (percent-encoded) or the URL path
+	if name, decodeErr := url.PathUnescape(r.Header.Get("X-File-Name")); decodeErr == nil && name != "" {
+		originalName = path.Base(name)
+	} else if p := path.Base(r.URL.Path); p != "" && p != "/" {
 		originalName = p
 	}
 
 	src = r.Body
 	closeSrc = func() {
 		if closeErr := r.Body.Close(); closeErr != nil {
-			log.Printf("Error closing request body: %v", closeErr)
+			requestLogger(r).Warn("Error closing request body", "error", closeErr)
 		}
 	}
 	return
 }
 
-// saveFile writes the uploaded file to disk with size limits and returns the file size
-func (h *UploadHandler) saveFile(fileID string, src io.Reader, maxBytes int64) (int64, error) {
-	pathToSave := filepath.Join(h.Config.UploadDir, fileID)
+// saveFile writes the uploaded file to disk with size limits, compressing it if it is text,
+// counting it against the storage limits of res and hashing it on the way, and stores it as
+// the blob of its content unless an identical file is already stored
+func (h *UploadHandler) saveFile(cfg *config.Config, opts uploadOptions, res *storage.Reservation, fileID, originalName string, src io.Reader) (savedFile, error) {
+	maxBytes := opts.MaxBytes
+
+	// Write to a temporary name so that interrupted uploads are recognisable and never served
+	pathToSave := filepath.Join(cfg.UploadDir, fileID+storage.IncompleteSuffix)
 
 	f, err := os.Create(pathToSave)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create file: %w", err)
+		return savedFile{}, fmt.Errorf("failed to create file: %w", err)
 	}
 
+	hasher := sha256.New()
+	sniffer := &sniffBuffer{}
+	var store io.Writer = &quotaWriter{w: f, res: res}
+	var compressor *storage.Compressor
+	if cfg.CompressUploads {
+		// Text is compressed on its way to disk, so only the compressed bytes are stored and counted
+		compressor = storage.NewCompressor(store, func(head []byte) bool {
+			return storage.Compressible(detectContentType(head, originalName), int64(len(head)))
+		})
+		store = compressor
+	}
+	var dst io.Writer = io.MultiWriter(&ownerQuotaWriter{res: res}, store, hasher, sniffer)
+	var stripper *imagemeta.Stripper
+	if opts.StripMetadata {
+		// The limit and hash apply to what is received and what is stored respectively
+		stripper = imagemeta.NewStripper(dst)
+		dst = stripper
+	}
 	limitedReader := io.LimitReader(src, maxBytes+1)
-	written, err := io.Copy(f, limitedReader)
+	if len(opts.Digests) > 0 {
+		// Client checksums describe the bytes sent, before any metadata is stripped
+		hashes := make([]io.Writer, len(opts.Digests))
+		for i, d := range opts.Digests {
+			hashes[i] = d.hash
+		}
+		limitedReader = io.TeeReader(limitedReader, io.MultiWriter(hashes...))
+	}
+	written, err := io.Copy(dst, limitedReader)
+	if err == nil && stripper != nil {
+		err = stripper.Close()
+	}
+	if err == nil && compressor != nil {
+		err = compressor.Close()
+	}
 
 	if closeErr := f.Close(); closeErr != nil {
-		log.Printf("Error closing file: %v", closeErr)
+		slog.Warn("Error closing file", "file_id", fileID, "error", closeErr)
 	}
 
+	if errors.Is(err, syscall.ENOSPC) {
+		err = fmt.Errorf("%w: the server ran out of disk space", storage.ErrInsufficientStorage)
+	}
 	if err != nil {
 		os.Remove(pathToSave)
-		return 0, fmt.Errorf("failed to save file: %w", err)
+		if errors.Is(err, storage.ErrInsufficientStorage) {
+			return savedFile{}, err
+		}
+		return savedFile{}, fmt.Errorf("failed to save file: %w", err)
 	}
 
 	// Check if file exceeded size limit
 	if written > maxBytes {
 		os.Remove(pathToSave)
-		return 0, fmt.Errorf("file too large (max: %d MB)", h.Config.MaxFileSizeMB)
+		return savedFile{}, fmt.Errorf("file too large (max: %s)", opts.limit())
 	}
 
-	return written, nil
+	if err := verifyDigests(opts.Digests); err != nil {
+		os.Remove(pathToSave)
+		return savedFile{}, err
+	}
+
+	saved := savedFile{
+		Size:   written,
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+		Head:   sniffer.head,
+	}
+	if stripper != nil {
+		saved.Size = stripper.Written()
+		saved.MetadataRemoved = stripper.Removed()
+	}
+
+	blob := storage.StoredFile{SHA256: saved.SHA256, Size: saved.Size}
+	if compressor != nil && compressor.Compressed() {
+		info, err := os.Stat(pathToSave)
+		if err != nil {
+			os.Remove(pathToSave)
+			return savedFile{}, fmt.Errorf("failed to save file: %w", err)
+		}
+		blob.Encoding, blob.StoredSize = storage.GzipEncoding, info.Size()
+	}
+	saved.Deduplicated, err = h.Store.CommitBlob(&blob, pathToSave)
+	if err != nil {
+		os.Remove(pathToSave)
+		return savedFile{}, fmt.Errorf("failed to save file: %w", err)
+	}
+	saved.Path, saved.Encoding, saved.StoredSize = blob.Path, blob.Encoding, blob.StoredSize
+	return saved, nil
 }
 
-// sendSuccessResponse sends the upload success response with download URL and cURL command
-func (h *UploadHandler) sendSuccessResponse(w http.ResponseWriter, r *http.Request, fileID, originalName string, fileSize int64) {
-	if originalName == "" {
-		originalName = fileID
+// quotaWriter counts the bytes written to disk by an upload against its reservation before
+// writing them
+type quotaWriter struct {
+	w   io.Writer
+	res *storage.Reservation
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if err := q.res.Grow(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return q.w.Write(p)
+}
+
+// ownerQuotaWriter counts the bytes of the uploaded file against the owner quota of its
+// reservation; it sits before the compressor, so that uploads count like stored files
+type ownerQuotaWriter struct {
+	res *storage.Reservation
+}
+
+func (q *ownerQuotaWriter) Write(p []byte) (int, error) {
+	if err := q.res.GrowOwner(int64(len(p))); err != nil {
+		return 0, err
 	}
+	return len(p), nil
+}
 
-	// Determine the scheme (http or https)
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
+// sendSuccessResponse sends the upload success response with download URL and cURL command,
+// or an UploadResponse to clients asking for JSON
+func (h *UploadHandler) sendSuccessResponse(w http.ResponseWriter, r *http.Request, cfg *config.Config, sf storage.StoredFile) {
+	originalName := sf.OriginalName
+	if originalName == "" {
+		originalName = sf.ID
 	}
-	// Check X-Forwarded-Proto header for reverse proxy setups
-	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
-		scheme = proto
+
+	fileURL := downloadURL(r, sf.ID)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, newUploadResponse(r, cfg, sf))
+		return
 	}
 
-	downloadURL := fmt.Sprintf("%s://%s/download/%s", scheme, r.Host, fileID)
-	curlCommand := fmt.Sprintf("curl -o \"%s\" %s", originalName, downloadURL)
-	fileSizeStr := formatFileSize(fileSize)
+	curlCommand := downloadCommand(fileURL, sf.ID, originalName)
+	fileSizeStr := formatFileSize(sf.Size)
 
 	w.Header().Set("Content-Type", "text/plain")
 
 	var response string
 
+	// Pastes are shared through their page
+	shareURL := fileURL
+	if sf.Language != "" {
+		shareURL = pasteURL(r, sf.ID)
+	}
+
 	if isTerminalRequest(r) {
-		qrCode := generateTerminalQRCode(downloadURL)
+		qrCode := generateTerminalQRCode(shareURL)
 		if qrCode != "" {
 			response = qrCode + "\n"
 		}
 	}
 
-	response += fmt.Sprintf("File uploaded successfully!\nOriginal name: %s\nFile size: %s\nDownload URL: %s\ncURL command: %s\n",
-		originalName, fileSizeStr, downloadURL, curlCommand)
+	response += fmt.Sprintf("File uploaded successfully!\nOriginal name: %s\nFile size: %s\nExpires at: %s\n",
+		originalName, fileSizeStr, sf.ExpiresAt.Format(time.RFC3339))
+	if sf.RemainingDownloads > 1 {
+		response += fmt.Sprintf("Max downloads: %d\n", sf.RemainingDownloads)
+	}
+	if sf.Language == "" && (previewKind(sf.ContentType, cfg) != "" || sf.Archive != nil) {
+		response += fmt.Sprintf("Preview URL: %s\n", previewURL(r, sf.ID))
+	}
+	if sf.Archive != nil {
+		response += fmt.Sprintf("Archive: %s, %d entries, %s uncompressed\n",
+			sf.Archive.Format, sf.Archive.Count, formatFileSize(sf.Archive.TotalSize))
+	}
+	if sf.MetadataRemoved > 0 {
+		response += fmt.Sprintf("Metadata stripped: %s removed\n", formatFileSize(sf.MetadataRemoved))
+	}
+	if sf.Image != nil {
+		response += fmt.Sprintf("Image: %s, %dx%d\n", sf.Image.Format, sf.Image.Width, sf.Image.Height)
+	}
+	if sf.HasThumbnail() {
+		response += fmt.Sprintf("Thumbnail URL: %s\n", thumbnailURL(r, sf.ID))
+	}
+	if sf.Language != "" {
+		response += fmt.Sprintf("Language: %s\nView URL: %s\nRaw URL: %s\n", sf.Language, shareURL, rawURL(r, sf.ID))
+	}
+	response += fmt.Sprintf("Download URL: %s\ncURL command: %s\n", fileURL, curlCommand)
+	if sf.SHA256 != "" {
+		response += fmt.Sprintf("SHA-256: %s\nVerify command: %s\n", sf.SHA256, verifyCommand(fileURL, sf.ID, originalName, sf.SHA256))
+	}
 
 	if _, err := w.Write([]byte(response)); err != nil {
-		log.Printf("Error writing response: %v", err)
+		requestLogger(r).Warn("Error writing response", "file_id", sf.ID, "error", err)
+	}
+}
+
+// newUploadResponse describes a stored file with its download URL
+func newUploadResponse(r *http.Request, cfg *config.Config, sf storage.StoredFile) UploadResponse {
+	name := sf.OriginalName
+	if name == "" {
+		name = sf.ID
+	}
+	resp := UploadResponse{
+		ID:           sf.ID,
+		Name:         name,
+		Size:         sf.Size,
+		SHA256:       sf.SHA256,
+		URL:          downloadURL(r, sf.ID),
+		ExpiresAt:    sf.ExpiresAt,
+		MaxDownloads: sf.RemainingDownloads,
+		ContentType:  sf.ContentType,
+		Image:        sf.Image,
+		Archive:      sf.Archive,
+		InfoURL:      infoURL(r, sf.ID),
+	}
+	if sf.MetadataRemoved > 0 {
+		resp.MetadataStripped = true
+		resp.MetadataRemoved = sf.MetadataRemoved
+	}
+	if sf.HasThumbnail() {
+		resp.ThumbnailURL = thumbnailURL(r, sf.ID)
 	}
+	if previewKind(sf.ContentType, cfg) != "" || sf.Archive != nil {
+		resp.PreviewURL = previewURL(r, sf.ID)
+	}
+	if sf.Language != "" {
+		resp.ViewURL = pasteURL(r, sf.ID)
+		resp.Language = sf.Language
+	}
+	return resp
+}
+
+// downloadURL returns the absolute download URL of a file as seen by the client of r
+func downloadURL(r *http.Request, fileID string) string {
+	return fmt.Sprintf("%s://%s/download/%s", requestScheme(r), r.Host, fileID)
 }
 
 // formatFileSize formats bytes into human-readable format
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMultipartPassword(t *testing.T) {
+	uploadHandler, _ := newTestHandlers(t)
+	content := []byte("multipart upload content\n")
+
+	tests := []struct {
+		name       string
+		fields     map[string]string // sent before the file
+		after      map[string]string // sent after the file
+		header     string
+		query      string
+		wantStatus int
+	}{
+		{name: "field before the file", fields: map[string]string{"password": "secret"}, wantStatus: http.StatusOK},
+		{name: "header", header: "secret", wantStatus: http.StatusOK},
+		{name: "query", query: "?password=secret", wantStatus: http.StatusOK},
+		{name: "wrong field", fields: map[string]string{"password": "guess"}, wantStatus: http.StatusUnauthorized},
+		{name: "field after the file", after: map[string]string{"password": "secret"}, wantStatus: http.StatusUnauthorized},
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k, v := range tt.fields {
+			mw.WriteField(k, v)
+		}
+		fw, err := mw.CreateFormFile("file", "notes.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(content)
+		for k, v := range tt.after {
+			mw.WriteField(k, v)
+		}
+		mw.Close()
+
+		r := httptest.NewRequest(http.MethodPost, "/"+tt.query, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r.Header.Set("Accept", "application/json")
+		if tt.header != "" {
+			r.Header.Set("X-Upload-Password", tt.header)
+		}
+		w := httptest.NewRecorder()
+		uploadHandler.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp UploadResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		sf, _ := uploadHandler.Store.Get(resp.ID)
+		if sf.OriginalName != "notes.txt" {
+			t.Errorf("%s: stored as %q, want notes.txt", tt.name, sf.OriginalName)
+		}
+		if stored, err := os.ReadFile(sf.Path); err != nil || !bytes.Equal(stored, content) {
+			t.Errorf("%s: stored content differs from the upload (%v)", tt.name, err)
+		}
+	}
+	assertNoLeftovers(t, uploadHandler)
+}
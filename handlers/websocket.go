@@ -1,9 +1,10 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
+	"go-quick-cli-upload-server/metrics"
 	"go-quick-cli-upload-server/storage"
 
 	"github.com/gorilla/websocket"
@@ -12,13 +13,17 @@ import (
 // WebSocketHandler handles WebSocket connections for download notifications
 type WebSocketHandler struct {
 	Store    *storage.FileStore
+	Relays   *RelayHub
+	Requests *storage.RequestStore
 	Upgrader websocket.Upgrader
 }
 
 // NewWebSocketHandler creates a new WebSocketHandler
-func NewWebSocketHandler(store *storage.FileStore) *WebSocketHandler {
+func NewWebSocketHandler(store *storage.FileStore, relays *RelayHub, requests *storage.RequestStore) *WebSocketHandler {
 	return &WebSocketHandler{
-		Store: store,
+		Store:    store,
+		Relays:   relays,
+		Requests: requests,
 		Upgrader: websocket.Upgrader{
 			CheckOrigin: checkOrigin,
 		},
@@ -35,33 +40,55 @@ func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	conn, err := h.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		requestLogger(r).Warn("WebSocket upgrade error", "file_id", fileID, "error", err)
 		return
 	}
 
 	_, exists := h.Store.Get(fileID)
-	if !exists {
-		// File already downloaded or doesn't exist - notify and close
-		h.sendDownloadedNotification(conn, fileID)
+	// Besides files, relays and upload requests (watched with their owner token) have lifecycle events
+	if !exists && !h.Relays.Active(fileID) && !h.Requests.HasOwner(fileID) {
+		if reason, ok := h.Store.RemovedReason(fileID); ok {
+			// Removed just before the watcher connected, e.g. by a fast download
+			h.sendRemovedNotification(conn, fileID, reason)
+			return
+		}
+		h.sendNotFoundNotification(conn, fileID)
 		return
 	}
 
 	h.Store.AddWSClient(fileID, conn)
-	log.Printf("WebSocket client connected for file: %s", fileID)
+	metrics.WebSocketConnections.Inc()
+	requestLogger(r).Info("WebSocket client connected", "file_id", fileID)
 
 	h.handleConnection(fileID, conn)
 }
 
-// sendDownloadedNotification sends a "downloaded" message and closes the connection
-func (h *WebSocketHandler) sendDownloadedNotification(conn *websocket.Conn, fileID string) {
+// sendRemovedNotification sends the event watchers got when the file was removed, with
+// "downloaded" set if it was downloaded, and closes the connection
+func (h *WebSocketHandler) sendRemovedNotification(conn *websocket.Conn, fileID string, reason storage.DeleteReason) {
 	if err := conn.WriteJSON(map[string]interface{}{
-		"downloaded": true,
+		"downloaded": reason == storage.ReasonDownloaded,
+		"event":      reason,
 		"fileID":     fileID,
 	}); err != nil {
-		log.Printf("Error writing WebSocket message: %v", err)
+		slog.Warn("Error writing WebSocket message", "file_id", fileID, "error", err)
+	}
+	if err := conn.Close(); err != nil {
+		slog.Warn("Error closing WebSocket connection", "file_id", fileID, "error", err)
+	}
+}
+
+// sendNotFoundNotification sends a "not-found" event, for IDs that are unknown or were
+// removed too long ago to remember, and closes the connection
+func (h *WebSocketHandler) sendNotFoundNotification(conn *websocket.Conn, fileID string) {
+	if err := conn.WriteJSON(map[string]interface{}{
+		"event":  "not-found",
+		"fileID": fileID,
+	}); err != nil {
+		slog.Warn("Error writing WebSocket message", "file_id", fileID, "error", err)
 	}
 	if err := conn.Close(); err != nil {
-		log.Printf("Error closing WebSocket connection: %v", err)
+		slog.Warn("Error closing WebSocket connection", "file_id", fileID, "error", err)
 	}
 }
 
@@ -69,9 +96,10 @@ func (h *WebSocketHandler) sendDownloadedNotification(conn *websocket.Conn, file
 func (h *WebSocketHandler) handleConnection(fileID string, conn *websocket.Conn) {
 	go func() {
 		defer func() {
+			metrics.WebSocketConnections.Dec()
 			h.Store.RemoveWSClient(fileID, conn)
 			if err := conn.Close(); err != nil && !isClosedError(err) {
-				log.Printf("Error closing WebSocket: %v", err)
+				slog.Warn("Error closing WebSocket", "file_id", fileID, "error", err)
 			}
 		}()
 
@@ -79,7 +107,7 @@ func (h *WebSocketHandler) handleConnection(fileID string, conn *websocket.Conn)
 			_, _, err := conn.ReadMessage()
 			if err != nil {
 				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !isClosedError(err) {
-					log.Printf("WebSocket error: %v", err)
+					slog.Warn("WebSocket error", "file_id", fileID, "error", err)
 				}
 				break
 			}
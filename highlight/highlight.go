@@ -0,0 +1,289 @@
+// Package highlight renders source code as HTML with lightweight syntax highlighting.
+// It recognises comments, strings, numbers, keywords and literals with a small table-driven
+// scanner; it is meant for reading pastes, not for exact parsing.
+package highlight
+
+import (
+	"html/template"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Plain is the language of text without highlighting
+const Plain = "text"
+
+// Token classes, used as CSS class names
+const (
+	classComment = "c"
+	classString  = "s"
+	classNumber  = "n"
+	classKeyword = "k"
+	classLiteral = "l"
+	classInsert  = "gi"
+	classDelete  = "gd"
+	classHunk    = "gh"
+)
+
+// Normalize returns the canonical name of a language or alias, or Plain if it is not supported
+func Normalize(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if canonical, ok := aliases[lang]; ok {
+		lang = canonical
+	}
+	if _, ok := languages[lang]; ok || lang == "diff" {
+		return lang
+	}
+	return Plain
+}
+
+// FromFilename guesses the language of a file from its name, or returns Plain
+func FromFilename(name string) string {
+	base := strings.ToLower(path.Base(name))
+	if lang, ok := filenames[base]; ok {
+		return lang
+	}
+	ext := strings.TrimPrefix(path.Ext(base), ".")
+	if ext == "" {
+		return Plain
+	}
+	return Normalize(ext)
+}
+
+// Extension returns the usual file name extension of a language, without the dot
+func Extension(lang string) string {
+	if ext, ok := extensions[lang]; ok {
+		return ext
+	}
+	return "txt"
+}
+
+// Lines highlights src as lang and returns one HTML fragment per line. Invalid UTF-8 is
+// replaced, and a final newline does not start an extra line.
+func Lines(src, lang string) []template.HTML {
+	src = strings.ToValidUTF8(src, "�")
+	src = strings.TrimSuffix(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var tokens []token
+	if lang == "diff" {
+		tokens = scanDiff(src)
+	} else if def, ok := languages[lang]; ok {
+		tokens = def.scan(src)
+	} else {
+		tokens = []token{{text: src}}
+	}
+	return splitLines(tokens)
+}
+
+// token is a run of source text rendered with one CSS class (none when empty)
+type token struct {
+	class string
+	text  string
+}
+
+// splitLines renders tokens as HTML, closing and reopening spans at line breaks so that
+// each line is a self-contained fragment
+func splitLines(tokens []token) []template.HTML {
+	var lines []template.HTML
+	var b strings.Builder
+	for _, t := range tokens {
+		for i, part := range strings.Split(t.text, "\n") {
+			if i > 0 {
+				lines = append(lines, template.HTML(b.String()))
+				b.Reset()
+			}
+			if part == "" {
+				continue
+			}
+			if t.class == "" {
+				b.WriteString(template.HTMLEscapeString(part))
+				continue
+			}
+			b.WriteString(`<span class="` + t.class + `">`)
+			b.WriteString(template.HTMLEscapeString(part))
+			b.WriteString(`</span>`)
+		}
+	}
+	return append(lines, template.HTML(b.String()))
+}
+
+// scanDiff colours added, removed and hunk header lines of a unified diff
+func scanDiff(src string) []token {
+	var tokens []token
+	for i, line := range strings.Split(src, "\n") {
+		if i > 0 {
+			tokens = append(tokens, token{text: "\n"})
+		}
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "@@"):
+			class = classHunk
+		case strings.HasPrefix(line, "+"):
+			class = classInsert
+		case strings.HasPrefix(line, "-"):
+			class = classDelete
+		}
+		tokens = append(tokens, token{class: class, text: line})
+	}
+	return tokens
+}
+
+// language describes the lexical conventions used to highlight one language
+type language struct {
+	lineComments  []string
+	blockComment  [2]string
+	quotes        string   // characters delimiting single-line strings
+	multiline     string   // characters delimiting strings that may span lines
+	tripleQuotes  bool     // """ and ''' strings, as in Python
+	keywords      []string // matched case-sensitively unless caseless is set
+	literals      []string
+	caseless      bool
+	keywordLookup map[string]string
+}
+
+// scan splits src into tokens
+func (l *language) scan(src string) []token {
+	var tokens []token
+	plainStart := 0
+	emit := func(start, end int, class string) {
+		if plainStart < start {
+			tokens = append(tokens, token{text: src[plainStart:start]})
+		}
+		tokens = append(tokens, token{class: class, text: src[start:end]})
+		plainStart = end
+	}
+
+	for i := 0; i < len(src); {
+		rest := src[i:]
+
+		if open := l.blockComment[0]; open != "" && strings.HasPrefix(rest, open) {
+			end := strings.Index(rest[len(open):], l.blockComment[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len(open) + len(l.blockComment[1])
+			}
+			emit(i, i+end, classComment)
+			i += end
+			continue
+		}
+
+		if l.isLineComment(src, i) {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			emit(i, i+end, classComment)
+			i += end
+			continue
+		}
+
+		c := src[i]
+		if l.tripleQuotes && (strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, `'''`)) {
+			end := strings.Index(rest[3:], rest[:3])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 6
+			}
+			emit(i, i+end, classString)
+			i += end
+			continue
+		}
+		if strings.IndexByte(l.quotes, c) >= 0 || strings.IndexByte(l.multiline, c) >= 0 {
+			end := scanString(rest, strings.IndexByte(l.multiline, c) >= 0)
+			emit(i, i+end, classString)
+			i += end
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		if isDigit(c) && (i == 0 || !isIdentPart(lastRune(src[:i]))) {
+			end := 1
+			for end < len(rest) && (isIdentPart(rune(rest[end])) || rest[end] == '.') {
+				end++
+			}
+			emit(i, i+end, classNumber)
+			i += end
+			continue
+		}
+		if isIdentStart(r) && (i == 0 || !isIdentPart(lastRune(src[:i]))) {
+			end := size
+			for end < len(rest) {
+				next, n := utf8.DecodeRuneInString(rest[end:])
+				if !isIdentPart(next) {
+					break
+				}
+				end += n
+			}
+			word := rest[:end]
+			if l.caseless {
+				word = strings.ToLower(word)
+			}
+			if class, ok := l.keywordLookup[word]; ok {
+				emit(i, i+end, class)
+			}
+			i += end
+			continue
+		}
+		i += size
+	}
+	if plainStart < len(src) {
+		tokens = append(tokens, token{text: src[plainStart:]})
+	}
+	return tokens
+}
+
+// isLineComment reports whether a line comment starts at src[i]. A "#" only starts a
+// comment at the beginning of a word, so that shell expressions like $# are left alone.
+func (l *language) isLineComment(src string, i int) bool {
+	for _, prefix := range l.lineComments {
+		if !strings.HasPrefix(src[i:], prefix) {
+			continue
+		}
+		if prefix == "#" && i > 0 && !unicode.IsSpace(lastRune(src[:i])) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// scanString returns the length of the string literal at the start of s, which ends at the
+// matching unescaped quote, or at the end of the line unless multiline is set
+func scanString(s string, multiline bool) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case '\n':
+			if !multiline {
+				return i
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,168 @@
+package highlight
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// spanTag matches the only markup the highlighter may produce
+var spanTag = regexp.MustCompile(`<span class="[a-z]+">|</span>`)
+
+// allLanguages returns every supported language, Plain and diff included
+func allLanguages() []string {
+	langs := []string{Plain, "diff"}
+	for lang := range languages {
+		langs = append(langs, lang)
+	}
+	return langs
+}
+
+func TestLinesEscapesHTML(t *testing.T) {
+	const script = `<script>alert("x" + 'y' & 1)</script>`
+	sources := []struct {
+		name string
+		src  string
+	}{
+		{"bare", script},
+		{"double-quoted string", `x = "` + script + `"`},
+		{"single-quoted string", `x = '` + script + `'`},
+		{"backquoted string", "x = `" + script + "`"},
+		{"line comment", "// " + script},
+		{"hash comment", "# " + script},
+		{"block comment", "/* " + script + "\n" + script + " */"},
+		{"unterminated string", `x = "` + script},
+		{"keywords around it", "if true " + script + " return nil"},
+		{"diff lines", "+" + script + "\n-" + script + "\n@@ " + script},
+		{"entities", `&lt;script&gt; &amp; &#60;`},
+	}
+
+	for _, lang := range allLanguages() {
+		for _, tt := range sources {
+			t.Run(lang+"/"+tt.name, func(t *testing.T) {
+				lines := Lines(tt.src, lang)
+				srcLines := strings.Split(tt.src, "\n")
+				if len(lines) != len(srcLines) {
+					t.Fatalf("got %d lines, want %d", len(lines), len(srcLines))
+				}
+				for i, line := range lines {
+					text := spanTag.ReplaceAllString(string(line), "")
+					if strings.ContainsAny(text, `<>"'`) {
+						t.Errorf("line %d is not escaped: %s", i+1, line)
+					}
+					if got := html.UnescapeString(text); got != srcLines[i] {
+						t.Errorf("line %d renders %q, want %q", i+1, got, srcLines[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestLinesBalancesSpans(t *testing.T) {
+	src := "/* a comment\nover two lines */\nx := `raw\nstring`\n"
+	for i, line := range Lines(src, "go") {
+		opened := strings.Count(string(line), "<span")
+		closed := strings.Count(string(line), "</span>")
+		if opened != closed {
+			t.Errorf("line %d has %d opening and %d closing tags: %s", i+1, opened, closed, line)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		lang string
+		want []string
+	}{
+		{
+			name: "go keywords, strings and numbers",
+			src:  `return "a", 42, nil`,
+			lang: "go",
+			want: []string{`<span class="k">return</span> <span class="s">&#34;a&#34;</span>, <span class="n">42</span>, <span class="l">nil</span>`},
+		},
+		{
+			name: "python comment",
+			src:  "pass # done",
+			lang: "python",
+			want: []string{`<span class="k">pass</span> <span class="c"># done</span>`},
+		},
+		{
+			name: "diff",
+			src:  "@@ -1 +1 @@\n-old\n+new\n same",
+			lang: "diff",
+			want: []string{`<span class="gh">@@ -1 +1 @@</span>`, `<span class="gd">-old</span>`, `<span class="gi">+new</span>`, ` same`},
+		},
+		{
+			name: "plain text",
+			src:  "if x < 1",
+			lang: Plain,
+			want: []string{`if x &lt; 1`},
+		},
+		{
+			name: "final newline and CRLF",
+			src:  "a\r\nb\n",
+			lang: Plain,
+			want: []string{"a", "b"},
+		},
+		{
+			name: "invalid UTF-8",
+			src:  "a\xffb",
+			lang: Plain,
+			want: []string{"a�b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := Lines(tt.src, tt.lang)
+			if len(lines) != len(tt.want) {
+				t.Fatalf("Lines = %q, want %q", lines, tt.want)
+			}
+			for i := range lines {
+				if string(lines[i]) != tt.want[i] {
+					t.Errorf("line %d = %s, want %s", i+1, lines[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"go":       "go",
+		" Golang ": "go",
+		"JS":       "javascript",
+		"c++":      "cpp",
+		"patch":    "diff",
+		"diff":     "diff",
+		"cobol":    Plain,
+		"":         Plain,
+	}
+	for lang, want := range tests {
+		if got := Normalize(lang); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", lang, got, want)
+		}
+	}
+}
+
+func TestFromFilename(t *testing.T) {
+	tests := map[string]string{
+		"main.go":         "go",
+		"dir/App.TSX":     "typescript",
+		"Dockerfile":      "shell",
+		"src/Makefile":    "shell",
+		"fix.patch":       "diff",
+		"notes":           Plain,
+		"archive.unknown": Plain,
+		"config.yml":      "yaml",
+	}
+	for name, want := range tests {
+		if got := FromFilename(name); got != want {
+			t.Errorf("FromFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
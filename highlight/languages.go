@@ -0,0 +1,168 @@
+package highlight
+
+import "strings"
+
+// cFamily are the comment conventions shared by C-like languages
+var cFamily = language{
+	lineComments: []string{"//"},
+	blockComment: [2]string{"/*", "*/"},
+	quotes:       `"'`,
+}
+
+// languages maps canonical language names to their definitions; "diff" is handled separately
+var languages = map[string]*language{
+	"go": withWords(cFamily, `
+		break case chan const continue default defer else fallthrough for func go goto if import
+		interface map package range return select struct switch type var`,
+		"true false nil iota", "`"),
+	"c": withWords(cFamily, `
+		auto break case char const continue default do double else enum extern float for goto if
+		inline int long register return short signed sizeof static struct switch typedef union
+		unsigned void volatile while`,
+		"NULL true false", ""),
+	"cpp": withWords(cFamily, `
+		auto bool break case catch char class const constexpr continue default delete do double else
+		enum explicit extern float for friend goto if inline int long namespace new noexcept operator
+		private protected public return short signed sizeof static struct switch template this throw
+		try typedef typename union unsigned using virtual void volatile while`,
+		"nullptr NULL true false", ""),
+	"java": withWords(cFamily, `
+		abstract boolean break byte case catch char class continue default do double else enum extends
+		final finally float for if implements import instanceof int interface long new package private
+		protected public return short static super switch synchronized this throw throws try var void
+		volatile while record`,
+		"true false null", ""),
+	"javascript": withWords(cFamily, `
+		async await break case catch class const continue debugger default delete do else export
+		extends finally for from function if import in instanceof let new of return static super switch
+		this throw try typeof var void while yield`,
+		"true false null undefined NaN", "`"),
+	"typescript": withWords(cFamily, `
+		abstract any as async await boolean break case catch class const continue declare default delete
+		do else enum export extends finally for from function if implements import in instanceof
+		interface keyof let namespace never new number of private protected public readonly return
+		static string super switch this throw try type typeof unknown var void while yield`,
+		"true false null undefined NaN", "`"),
+	"rust": withWords(cFamily, `
+		as async await break const continue crate dyn else enum extern fn for if impl in let loop match
+		mod move mut pub ref return self Self static struct super trait type unsafe use where while`,
+		"true false None Some Ok Err", ""),
+	"python": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		tripleQuotes: true,
+		keywords: words(`
+			and as assert async await break class continue def del elif else except finally for from
+			global if import in is lambda nonlocal not or pass raise return try while with yield`),
+		literals: words("True False None self"),
+	},
+	"ruby": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords: words(`
+			alias and begin break case class def do else elsif end ensure for if in module next
+			not or redo rescue retry return self super then unless until when while yield require`),
+		literals: words("true false nil"),
+	},
+	"shell": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords: words(`
+			case do done elif else esac export fi for function if in local read return select set shift
+			then until unset while echo exit source`),
+		literals: words("true false"),
+	},
+	"sql": {
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `'"`,
+		caseless:     true,
+		keywords: words(`
+			add all alter and as asc begin between by case check column commit constraint create
+			database default delete desc distinct drop else end exists foreign from full group having
+			in index inner insert into is join key left like limit not offset on or order outer primary
+			references returning right rollback select set table then union unique update values view
+			when where with`),
+		literals: words("null true false"),
+	},
+	"json": {
+		quotes:   `"`,
+		literals: words("true false null"),
+	},
+	"yaml": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		literals:     words("true false null yes no on off"),
+	},
+	"toml": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		tripleQuotes: true,
+		literals:     words("true false"),
+	},
+}
+
+// aliases maps alternative names and file extensions to canonical language names
+var aliases = map[string]string{
+	"golang":  "go",
+	"h":       "c",
+	"c++":     "cpp",
+	"cc":      "cpp",
+	"cxx":     "cpp",
+	"hpp":     "cpp",
+	"js":      "javascript",
+	"mjs":     "javascript",
+	"cjs":     "javascript",
+	"jsx":     "javascript",
+	"ts":      "typescript",
+	"tsx":     "typescript",
+	"rs":      "rust",
+	"py":      "python",
+	"python3": "python",
+	"rb":      "ruby",
+	"sh":      "shell",
+	"bash":    "shell",
+	"zsh":     "shell",
+	"console": "shell",
+	"yml":     "yaml",
+	"patch":   "diff",
+}
+
+// filenames maps well-known file names without a telling extension to languages
+var filenames = map[string]string{
+	"dockerfile": "shell",
+	"makefile":   "shell",
+	".bashrc":    "shell",
+	".zshrc":     "shell",
+}
+
+// extensions maps canonical language names to the file name extension of pastes
+var extensions = map[string]string{
+	"go": "go", "c": "c", "cpp": "cpp", "java": "java", "javascript": "js", "typescript": "ts",
+	"rust": "rs", "python": "py", "ruby": "rb", "shell": "sh", "sql": "sql", "json": "json",
+	"yaml": "yaml", "toml": "toml", "diff": "diff",
+}
+
+func init() {
+	for _, l := range languages {
+		l.keywordLookup = make(map[string]string, len(l.keywords)+len(l.literals))
+		for _, w := range l.keywords {
+			l.keywordLookup[w] = classKeyword
+		}
+		for _, w := range l.literals {
+			l.keywordLookup[w] = classLiteral
+		}
+	}
+}
+
+// withWords returns a copy of base with the given keywords, literals and multi-line quotes
+func withWords(base language, keywords, literals, multiline string) *language {
+	base.keywords = words(keywords)
+	base.literals = words(literals)
+	base.multiline = multiline
+	return &base
+}
+
+func words(list string) []string {
+	return strings.Fields(list)
+}
@@ -0,0 +1,424 @@
+// Package imagemeta removes metadata such as EXIF (with GPS positions, camera serial numbers
+// and capture times), XMP, IPTC and text comments from JPEG and PNG files while they are
+// written, along with whatever follows the end of the image: the secondary images of
+// multi-picture JPEGs, which carry their own EXIF, and the videos of motion photos. The image
+// data is copied unchanged, so stripping is lossless.
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+var (
+	jpegSignature = []byte{0xff, 0xd8}
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader    = []byte("Exif\x00\x00")
+	mpfHeader     = []byte("MPF\x00")
+)
+
+// JPEG markers
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP1  = 0xe1 // EXIF and XMP
+	markerAPP2  = 0xe2 // ICC profiles and the multi-picture index
+	markerAPP13 = 0xed // Photoshop and IPTC
+	markerCOM   = 0xfe
+)
+
+// strippedPNGChunks are the PNG chunks carrying metadata rather than image or colour data
+var strippedPNGChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// format is the kind of file detected from its first bytes
+type format int
+
+const (
+	formatUnknown format = iota
+	formatJPEG
+	formatPNG
+	formatOther
+)
+
+// Stripper is an io.Writer that forwards a file to another writer without its metadata.
+// Files that are neither JPEG nor PNG, and the remainder of a file it cannot parse, are
+// forwarded unchanged. Close must be called after the last write.
+type Stripper struct {
+	dst     io.Writer
+	format  format
+	pending []byte // bytes of a signature, marker or chunk header not complete yet
+	header  []byte // marker and length of the segment being collected
+	segment []byte // an APP1 or APP2 segment being collected to decide whether to keep it
+	skip    int64  // bytes of a removed segment or chunk still to drop
+	copy    int64  // bytes of a kept segment or chunk still to forward
+	scan    bool   // in the entropy-coded data of a JPEG scan
+	through bool   // forward everything from here on
+	trailer bool   // past the end of the image: drop everything from here on
+	written int64
+	removed int64
+}
+
+// NewStripper creates a Stripper writing to dst
+func NewStripper(dst io.Writer) *Stripper {
+	return &Stripper{dst: dst}
+}
+
+// Written returns the number of bytes forwarded to the destination
+func (s *Stripper) Written() int64 {
+	return s.written
+}
+
+// Removed returns the number of bytes of metadata removed
+func (s *Stripper) Removed() int64 {
+	return s.removed
+}
+
+// Write implements io.Writer. It consumes all of p unless the destination fails.
+func (s *Stripper) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		var err error
+		switch {
+		case s.through:
+			err = s.emit(p)
+			p = nil
+		case s.skip > 0:
+			k := min(s.skip, int64(len(p)))
+			s.skip -= k
+			s.removed += k
+			p = p[k:]
+		case s.copy > 0:
+			k := min(s.copy, int64(len(p)))
+			err = s.emit(p[:k])
+			s.copy -= k
+			p = p[k:]
+		case s.trailer:
+			s.removed += int64(len(p))
+			p = nil
+		case s.segment != nil:
+			p = s.collectSegment(p)
+			if s.segment != nil && len(s.segment) == cap(s.segment) {
+				err = s.finishSegment()
+			}
+		case s.scan:
+			p, err = s.copyScan(p)
+		default:
+			p, err = s.parse(p)
+		}
+		if err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+// Close forwards what is left of a truncated file
+func (s *Stripper) Close() error {
+	if s.segment != nil {
+		s.removed += int64(len(s.header) + len(s.segment))
+		s.header, s.segment = nil, nil
+	}
+	if len(s.pending) > 0 {
+		pending := s.pending
+		s.pending = nil
+		return s.emit(pending)
+	}
+	return nil
+}
+
+// emit forwards b to the destination
+func (s *Stripper) emit(b []byte) error {
+	n, err := s.dst.Write(b)
+	s.written += int64(n)
+	return err
+}
+
+// need appends bytes from p to the pending header until it holds n bytes. It returns the
+// rest of p and whether the header is complete.
+func (s *Stripper) need(p []byte, n int) ([]byte, bool) {
+	if len(s.pending) >= n {
+		return p, true
+	}
+	k := min(n-len(s.pending), len(p))
+	s.pending = append(s.pending, p[:k]...)
+	return p[k:], len(s.pending) == n
+}
+
+// passThrough forwards the pending bytes and everything after them unchanged
+func (s *Stripper) passThrough() error {
+	s.through = true
+	pending := s.pending
+	s.pending = nil
+	return s.emit(pending)
+}
+
+// parse reads the next signature, marker or chunk header from p
+func (s *Stripper) parse(p []byte) ([]byte, error) {
+	switch s.format {
+	case formatUnknown:
+		return s.parseSignature(p)
+	case formatJPEG:
+		return s.parseJPEG(p)
+	case formatPNG:
+		return s.parsePNG(p)
+	}
+	return p, s.passThrough()
+}
+
+// parseSignature detects the format of the file
+func (s *Stripper) parseSignature(p []byte) ([]byte, error) {
+	p, ok := s.need(p, len(jpegSignature))
+	if !ok {
+		return p, nil
+	}
+	if bytes.Equal(s.pending, jpegSignature) {
+		s.format = formatJPEG
+		pending := s.pending
+		s.pending = nil
+		return p, s.emit(pending)
+	}
+	if !bytes.HasPrefix(pngSignature, s.pending) {
+		s.format = formatOther
+		return p, s.passThrough()
+	}
+	p, ok = s.need(p, len(pngSignature))
+	if !ok {
+		return p, nil
+	}
+	if !bytes.Equal(s.pending, pngSignature) {
+		s.format = formatOther
+		return p, s.passThrough()
+	}
+	s.format = formatPNG
+	pending := s.pending
+	s.pending = nil
+	return p, s.emit(pending)
+}
+
+// parseJPEG handles the marker segments around the scans of image data, which are forwarded
+// unchanged. Everything after the end of image marker is dropped.
+func (s *Stripper) parseJPEG(p []byte) ([]byte, error) {
+	p, ok := s.need(p, 2)
+	if !ok {
+		return p, nil
+	}
+	if s.pending[0] != 0xff {
+		return p, s.passThrough()
+	}
+	switch marker := s.pending[1]; {
+	case marker == 0xff:
+		// Fill byte before a marker
+		s.pending = s.pending[:1]
+		return p, s.emit([]byte{0xff})
+	case marker == markerSOI, marker == 0x01, marker >= 0xd0 && marker <= 0xd7:
+		// Markers without a length
+		pending := s.pending
+		s.pending = nil
+		return p, s.emit(pending)
+	case marker == markerEOI:
+		s.trailer = true
+		pending := s.pending
+		s.pending = nil
+		return p, s.emit(pending)
+	}
+
+	p, ok = s.need(p, 4)
+	if !ok {
+		return p, nil
+	}
+	marker := s.pending[1]
+	length := int64(binary.BigEndian.Uint16(s.pending[2:]))
+	if length < 2 {
+		return p, s.passThrough()
+	}
+	header := s.pending
+	s.pending = nil
+
+	switch marker {
+	case markerSOS:
+		// The scan header is followed by the entropy-coded data
+		s.copy = length - 2
+		s.scan = true
+		return p, s.emit(header)
+	case markerAPP1, markerAPP2:
+		// Collect the segment: EXIF also holds the orientation, which is kept, and APP2 holds
+		// colour profiles as well as the index of the images after the primary one
+		s.header = header
+		s.segment = make([]byte, 0, length-2)
+		if length == 2 {
+			return p, s.finishSegment()
+		}
+		return p, nil
+	case markerAPP13, markerCOM:
+		s.skip = length - 2
+		s.removed += int64(len(header))
+		return p, nil
+	}
+	s.copy = length - 2
+	return p, s.emit(header)
+}
+
+// copyScan forwards entropy-coded data up to the marker that ends the scan. Within the data,
+// 0xff is followed by a stuffed zero, a restart marker or another 0xff used as fill.
+func (s *Stripper) copyScan(p []byte) ([]byte, error) {
+	if len(s.pending) > 0 {
+		// A 0xff ended the previous write
+		switch b := p[0]; {
+		case b == 0xff:
+			return p[1:], s.emit([]byte{0xff})
+		case b == 0x00, b >= 0xd0 && b <= 0xd7:
+			s.pending = nil
+			return p[1:], s.emit([]byte{0xff, b})
+		}
+		s.scan = false
+		return p, nil
+	}
+
+	i := bytes.IndexByte(p, 0xff)
+	switch {
+	case i < 0:
+		return nil, s.emit(p)
+	case i == len(p)-1:
+		s.pending = append(s.pending, 0xff)
+		return nil, s.emit(p[:i])
+	}
+	switch b := p[i+1]; {
+	case b == 0xff:
+		return p[i+1:], s.emit(p[:i+1])
+	case b == 0x00, b >= 0xd0 && b <= 0xd7:
+		return p[i+2:], s.emit(p[:i+2])
+	}
+	s.scan = false
+	return p[i:], s.emit(p[:i])
+}
+
+// collectSegment appends bytes from p to the APP1 or APP2 segment being collected
+func (s *Stripper) collectSegment(p []byte) []byte {
+	k := min(cap(s.segment)-len(s.segment), len(p))
+	s.segment = append(s.segment, p[:k]...)
+	return p[k:]
+}
+
+// finishSegment handles a collected APP1 or APP2 segment. The multi-picture index in APP2 is
+// dropped with the images it points to; other APP2 segments, such as colour profiles, are kept.
+func (s *Stripper) finishSegment() error {
+	header, segment := s.header, s.segment
+	if header[1] == markerAPP1 {
+		return s.finishExif()
+	}
+	s.header, s.segment = nil, nil
+	if bytes.HasPrefix(segment, mpfHeader) {
+		s.removed += int64(len(header) + len(segment))
+		return nil
+	}
+	if err := s.emit(header); err != nil {
+		return err
+	}
+	return s.emit(segment)
+}
+
+// finishExif drops a collected APP1 segment, writing a minimal EXIF segment in its place when
+// the image has an orientation other than the default, so that photos are not shown rotated
+func (s *Stripper) finishExif() error {
+	segment := s.segment
+	s.removed += int64(len(s.header) + len(segment))
+	s.header, s.segment = nil, nil
+	if !bytes.HasPrefix(segment, exifHeader) {
+		return nil
+	}
+	orientation := exifOrientation(segment[len(exifHeader):])
+	if orientation <= 1 || orientation > 8 {
+		return nil
+	}
+	kept := orientationSegment(orientation)
+	s.removed -= int64(len(kept))
+	return s.emit(kept)
+}
+
+// parsePNG handles one chunk header, dropping metadata chunks. Everything after the image
+// end chunk is dropped.
+func (s *Stripper) parsePNG(p []byte) ([]byte, error) {
+	p, ok := s.need(p, 8)
+	if !ok {
+		return p, nil
+	}
+	header := s.pending
+	s.pending = nil
+	length := int64(binary.BigEndian.Uint32(header))
+	chunkType := string(header[4:8])
+	if length > 1<<31-1 {
+		s.pending = header
+		return p, s.passThrough()
+	}
+	if strippedPNGChunks[chunkType] {
+		// Data and CRC
+		s.skip = length + 4
+		s.removed += int64(len(header))
+		return p, nil
+	}
+	if chunkType == "IEND" {
+		// Forward the CRC, then drop whatever was appended
+		s.copy = 4
+		s.trailer = true
+		return p, s.emit(header)
+	}
+	s.copy = length + 4
+	return p, s.emit(header)
+}
+
+// exifOrientation returns the Orientation tag of the first image directory of EXIF data in
+// TIFF layout, or 0 if there is none
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int64(order.Uint32(tiff[4:8]))
+	if offset+2 > int64(len(tiff)) {
+		return 0
+	}
+	count := int64(order.Uint16(tiff[offset:]))
+	for i := int64(0); i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > int64(len(tiff)) {
+			return 0
+		}
+		const tagOrientation, typeShort = 0x0112, 3
+		if order.Uint16(tiff[entry:]) == tagOrientation && order.Uint16(tiff[entry+2:]) == typeShort {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// orientationSegment builds an APP1 segment whose EXIF data only holds the orientation
+func orientationSegment(orientation int) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, markerAPP1, 0, 0})
+	b.Write(exifHeader)
+	// Big-endian TIFF header with the first directory right after it
+	b.Write([]byte{'M', 'M', 0, 42, 0, 0, 0, 8})
+	// One entry: Orientation, SHORT, count 1, value padded to four bytes
+	b.Write([]byte{0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0})
+	// No next directory
+	b.Write([]byte{0, 0, 0, 0})
+	segment := b.Bytes()
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
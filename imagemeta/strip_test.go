@@ -0,0 +1,281 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a small image with enough detail to produce several blocks of scan data
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 5), G: uint8(y * 8), B: uint8(x * y), A: 0xff})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testImage(), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func encodePNG(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// jpegSegment builds a marker segment with the given payload
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// exifPayload builds EXIF data holding an orientation and a camera serial number
+func exifPayload(orientation int) []byte {
+	payload := append([]byte(nil), exifHeader...)
+	payload = append(payload, 'M', 'M', 0, 42, 0, 0, 0, 8)
+	payload = append(payload, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0)
+	payload = append(payload, 0, 0, 0, 0)
+	return append(payload, "SERIAL-1234567"...)
+}
+
+// withJPEGSegments inserts segments right after the start of image marker
+func withJPEGSegments(jpg []byte, segments ...[]byte) []byte {
+	out := append([]byte(nil), jpg[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, jpg[2:]...)
+}
+
+// pngChunk builds a PNG chunk with its CRC
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// withPNGChunk inserts a chunk right after the IHDR chunk
+func withPNGChunk(p, chunk []byte) []byte {
+	const ihdrEnd = 8 + 8 + 13 + 4
+	out := append([]byte(nil), p[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, p[ihdrEnd:]...)
+}
+
+// strip runs data through a Stripper in writes of at most chunk bytes
+func strip(t *testing.T, data []byte, chunk int) ([]byte, *Stripper) {
+	t.Helper()
+	var out bytes.Buffer
+	s := NewStripper(&out)
+	for rest := data; len(rest) > 0; {
+		k := min(chunk, len(rest))
+		if n, err := s.Write(rest[:k]); err != nil || n != k {
+			t.Fatalf("Write = %d, %v, want %d, nil", n, err, k)
+		}
+		rest = rest[k:]
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return out.Bytes(), s
+}
+
+// assertSameImage checks that got decodes to the same pixels as want
+func assertSameImage(t *testing.T, got, want []byte) {
+	t.Helper()
+	wantImg, _, err := image.Decode(bytes.NewReader(want))
+	if err != nil {
+		t.Fatalf("decoding the original: %v", err)
+	}
+	gotImg, _, err := image.Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("decoding the stripped file: %v", err)
+	}
+	if gotImg.Bounds() != wantImg.Bounds() {
+		t.Fatalf("bounds = %v, want %v", gotImg.Bounds(), wantImg.Bounds())
+	}
+	b := wantImg.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if gotImg.At(x, y) != wantImg.At(x, y) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, gotImg.At(x, y), wantImg.At(x, y))
+			}
+		}
+	}
+}
+
+func TestStripper(t *testing.T) {
+	jpg := encodeJPEG(t)
+	pngData := encodePNG(t)
+	icc := append([]byte("ICC_PROFILE\x00\x01\x01"), "colour-profile"...)
+	secondary := withJPEGSegments(encodeJPEG(t), jpegSegment(markerAPP1, append(exifPayload(1), "SECONDARY"...)))
+
+	tests := []struct {
+		name    string
+		input   []byte
+		image   bool     // the output must decode to the same image as the input
+		gone    [][]byte // must not be in the output
+		kept    [][]byte // must still be in the output
+		removed bool     // some bytes must have been removed
+	}{
+		{
+			name:  "jpeg without metadata",
+			input: jpg,
+			image: true,
+		},
+		{
+			name: "jpeg metadata segments",
+			input: withJPEGSegments(jpg,
+				jpegSegment(markerAPP1, exifPayload(1)),
+				jpegSegment(markerAPP1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+				jpegSegment(markerAPP13, []byte("Photoshop 3.0\x00IPTC")),
+				jpegSegment(markerCOM, []byte("a comment")),
+			),
+			image:   true,
+			gone:    [][]byte{[]byte("SERIAL-1234567"), []byte("xmpmeta"), []byte("IPTC"), []byte("a comment")},
+			removed: true,
+		},
+		{
+			name:    "jpeg orientation kept",
+			input:   withJPEGSegments(jpg, jpegSegment(markerAPP1, exifPayload(6))),
+			image:   true,
+			gone:    [][]byte{[]byte("SERIAL-1234567")},
+			kept:    [][]byte{orientationSegment(6)},
+			removed: true,
+		},
+		{
+			name: "jpeg colour profile kept, multi-picture index dropped",
+			input: withJPEGSegments(jpg,
+				jpegSegment(markerAPP2, icc),
+				jpegSegment(markerAPP2, append(append([]byte(nil), mpfHeader...), "MM-INDEX"...)),
+			),
+			image:   true,
+			gone:    [][]byte{mpfHeader, []byte("MM-INDEX")},
+			kept:    [][]byte{jpegSegment(markerAPP2, icc)},
+			removed: true,
+		},
+		{
+			name:    "jpeg secondary image dropped",
+			input:   append(append([]byte(nil), jpg...), secondary...),
+			image:   true,
+			gone:    [][]byte{[]byte("SECONDARY"), []byte("SERIAL-1234567")},
+			removed: true,
+		},
+		{
+			name:    "jpeg motion photo video dropped",
+			input:   append(append([]byte(nil), jpg...), "\x00\x00\x00\x18ftypmp42 video data"...),
+			image:   true,
+			gone:    [][]byte{[]byte("ftypmp42")},
+			removed: true,
+		},
+		{
+			name:  "png without metadata",
+			input: pngData,
+			image: true,
+		},
+		{
+			name:    "png text chunks",
+			input:   withPNGChunk(pngData, pngChunk("tEXt", []byte("Author\x00someone"))),
+			image:   true,
+			gone:    [][]byte{[]byte("tEXt"), []byte("someone")},
+			removed: true,
+		},
+		{
+			name:    "png trailer dropped",
+			input:   append(append([]byte(nil), pngData...), "appended"...),
+			image:   true,
+			gone:    [][]byte{[]byte("appended")},
+			removed: true,
+		},
+		{
+			name:  "other format unchanged",
+			input: []byte("GIF89a not really a gif, but not JPEG or PNG either"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whole, s := strip(t, tt.input, len(tt.input))
+			if got := s.Written() + s.Removed(); got != int64(len(tt.input)) {
+				t.Errorf("Written + Removed = %d, want %d", got, len(tt.input))
+			}
+			if tt.removed != (s.Removed() > 0) {
+				t.Errorf("Removed = %d, want some removed: %v", s.Removed(), tt.removed)
+			}
+			if !tt.image && !tt.removed && !bytes.Equal(whole, tt.input) {
+				t.Errorf("output differs from the input")
+			}
+			if tt.image {
+				assertSameImage(t, whole, tt.input)
+			}
+			for _, b := range tt.gone {
+				if bytes.Contains(whole, b) {
+					t.Errorf("output still contains %q", b)
+				}
+			}
+			for _, b := range tt.kept {
+				if !bytes.Contains(whole, b) {
+					t.Errorf("output lost %q", b)
+				}
+			}
+
+			// Writes split at every possible boundary must give the same result
+			for _, chunk := range []int{1, 2, 3, 5, 64, 4096} {
+				if split, _ := strip(t, tt.input, chunk); !bytes.Equal(split, whole) {
+					t.Errorf("writes of %d bytes: output differs from a single write", chunk)
+				}
+			}
+		})
+	}
+}
+
+func TestStripperTruncated(t *testing.T) {
+	input := withJPEGSegments(encodeJPEG(t), jpegSegment(markerAPP1, exifPayload(6)), jpegSegment(markerCOM, []byte("comment")))
+
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"signature only", 1},
+		{"inside the marker header", 3},
+		{"inside the EXIF segment", 20},
+		{"inside the comment", len(input) / 8},
+		{"inside the scan", len(input) / 2},
+		{"before the end of image", len(input) - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			truncated := input[:tt.length]
+			out, s := strip(t, truncated, 7)
+			if got := s.Written() + s.Removed(); got != int64(len(truncated)) {
+				t.Errorf("Written + Removed = %d, want %d", got, len(truncated))
+			}
+			if int64(len(out)) != s.Written() {
+				t.Errorf("output is %d bytes, Written = %d", len(out), s.Written())
+			}
+			if bytes.Contains(out, []byte("SERIAL-1234567")) {
+				t.Errorf("output contains the EXIF data")
+			}
+		})
+	}
+}
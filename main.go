@@ -1,50 +1,220 @@
 // Package main is the entry point for the go-quick-cli-upload-server.
 // This server provides temporary file uploads with password protection,
 // automatic expiry, and real-time download notifications via WebSocket.
+// The same binary doubles as a client with the upload, download and wait commands.
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 
+	"go-quick-cli-upload-server/audit"
+	"go-quick-cli-upload-server/cli"
 	"go-quick-cli-upload-server/config"
 	"go-quick-cli-upload-server/handlers"
+	"go-quick-cli-upload-server/logging"
+	"go-quick-cli-upload-server/metrics"
+	"go-quick-cli-upload-server/processing"
+	"go-quick-cli-upload-server/server"
 	"go-quick-cli-upload-server/storage"
 )
 
 func main() {
-	// Load configuration from environment variables
-	cfg, err := config.LoadFromEnv()
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		serve(args)
+		return
+	}
+
+	switch command := args[0]; command {
+	case "serve":
+		serve(args[1:])
+	case "help":
+		usage(os.Stdout)
+	default:
+		if _, ok := cli.Commands[command]; !ok {
+			fmt.Fprintf(os.Stderr, "qcus: unknown command %q\n\n", command)
+			usage(os.Stderr)
+			os.Exit(2)
+		}
+		os.Exit(cli.Run(command, args[1:]))
+	}
+}
+
+// usage lists the available commands
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: qcus [command] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintf(w, "  %-10s %s\n", "serve", "Run the upload server (default)")
+	commands := make([]string, 0, len(cli.Commands))
+	for name := range cli.Commands {
+		commands = append(commands, name)
+	}
+	sort.Strings(commands)
+	for _, name := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", name, cli.Commands[name])
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run \"qcus COMMAND -h\" for the flags of a command.")
+}
+
+// serve runs the upload server until it receives SIGINT or SIGTERM
+func serve(args []string) {
+	// Load configuration from the config file, environment variables and flags
+	opts, err := config.ParseFlags("qcus serve", args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Invalid arguments", err)
+	}
+	cfg, err := config.Load(opts)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", err)
+	}
+	if opts.PrintConfig {
+		if err := cfg.WriteTOML(os.Stdout); err != nil {
+			fatal("Failed to print configuration", err)
+		}
+		return
 	}
 
+	// Switch to the configured log format and level
+	slog.SetDefault(logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel))
+
 	// Log configuration
-	log.Println("Server configuration:")
-	for _, line := range cfg.LogSummary() {
-		log.Printf("  %s", line)
-	}
+	slog.Info("Server configuration", "settings", cfg.LogSummary())
 
 	// Create uploads directory if it doesn't exist
 	if err := os.MkdirAll(cfg.UploadDir, 0755); err != nil {
-		log.Fatalf("Failed to create uploads directory: %v", err)
+		fatal("Failed to create uploads directory", err)
 	}
 
-	// Clean up old files on startup (handles crash recovery)
+	// Own the upload directory, so that "qcus admin" does not modify it behind the server's back
+	unlock, err := storage.LockDir(cfg.UploadDir)
+	if err != nil {
+		fatal("Failed to lock uploads directory", err)
+	}
+	defer unlock()
+
+	// Clean up old and half-written files on startup (handles crash recovery)
 	if err := storage.CleanupOldFiles(cfg.UploadDir, cfg.FileExpiryMinutes); err != nil {
-		log.Printf("Warning: cleanup failed: %v", err)
+		slog.Warn("Cleanup failed", "error", err)
+	}
+	if err := storage.RemoveIncompleteFiles(cfg.UploadDir); err != nil {
+		slog.Warn("Cleanup of incomplete uploads failed", "error", err)
+	}
+
+	// Open the audit log when enabled; a nil logger discards records
+	var auditLog *audit.Logger
+	if cfg.AuditLogPath != "" {
+		auditLog, err = audit.NewLogger(cfg.AuditLogPath, cfg.AuditLogMaxBytes(), cfg.AuditLogBackups)
+		if err != nil {
+			fatal("Failed to open audit log", err)
+		}
+	}
+
+	if cfg.MinFreeSpaceMB > 0 {
+		if _, _, err := storage.DiskSpace(cfg.UploadDir); err != nil {
+			slog.Warn("Cannot check free disk space, MIN_FREE_SPACE_MB is not enforced", "error", err)
+		}
 	}
 
 	// Initialize file store
-	store := storage.NewFileStore()
+	store := storage.NewFileStore(cfg.UploadDir)
+
+	// Audit removals that happen without a request: deletion after download, expiry and eviction.
+	// Revocations are recorded by the admin handler with the admin's identity.
+	store.OnRemove(func(f storage.StoredFile, reason storage.DeleteReason) {
+		event := audit.EventDelete
+		switch reason {
+		case storage.ReasonExpired:
+			event = audit.EventExpire
+		case storage.ReasonEvicted:
+			event = audit.EventEvict
+		case storage.ReasonRevoked:
+			return
+		}
+		auditLog.Log(audit.Record{
+			Event:    event,
+			Success:  true,
+			Identity: audit.IdentitySystem,
+			FileID:   f.ID,
+			FileName: f.OriginalName,
+			Size:     f.Size,
+			SHA256:   f.SHA256,
+		})
+	})
+
+	// Pick up the files of the previous run from the metadata index
+	restored, err := store.Restore()
+	if err != nil {
+		fatal("Failed to load metadata index", err)
+	}
+	slog.Info("Restored stored files", "files", restored)
+
+	// Expose storage usage as gauges computed at scrape time
+	metrics.NewGaugeFunc("qcus_stored_files", "Files currently stored.", func() float64 {
+		return float64(store.Stats().Files)
+	})
+	metrics.NewGaugeFunc("qcus_stored_bytes", "Bytes currently stored on disk, counting identical files once.", func() float64 {
+		return float64(store.Stats().Bytes)
+	})
+	metrics.NewGaugeFunc("qcus_deduplicated_bytes", "Bytes not stored because identical files share their data.", func() float64 {
+		return float64(store.Stats().Deduplicated)
+	})
+	metrics.NewGaugeFunc("qcus_compressed_bytes", "Bytes not stored because text files are stored compressed.", func() float64 {
+		return float64(store.Stats().Compressed())
+	})
+
+	// Upload requests live in memory and expire once their link closed and their files are
+	// gone; watchers of an expired request are told so
+	requests := storage.NewRequestStore()
+	requests.OnExpire(func(req storage.UploadRequest) {
+		store.CloseWatchers(req.OwnerToken, storage.ReasonExpired)
+	})
+	requests.OnClose(func(req storage.UploadRequest) {
+		store.BroadcastMessage(req.OwnerToken, map[string]interface{}{
+			"event":     "closed",
+			"fileID":    req.OwnerToken,
+			"requestID": req.ID,
+		})
+	})
+	store.OnRemove(func(f storage.StoredFile, _ storage.DeleteReason) {
+		if f.RequestID != "" {
+			requests.FileRemoved(f.RequestID, f.ID)
+		}
+	})
+
+	// Handlers read the current configuration through the holder so it can be reloaded
+	holder := config.NewHolder(cfg, opts)
 
 	// Create HTTP handlers
-	uploadHandler := handlers.NewUploadHandler(store, cfg)
-	downloadHandler := handlers.NewDownloadHandler(store)
-	loginHandler := handlers.NewLoginHandler(cfg)
-	wsHandler := handlers.NewWebSocketHandler(store)
-	configHandler := handlers.NewConfigHandler(cfg)
+	uploadHandler := handlers.NewUploadHandler(store, holder, auditLog, processing.NewPipeline(processing.ImageStep{}, processing.ArchiveStep{}))
+	downloadHandler := handlers.NewDownloadHandler(store, holder, auditLog)
+	pasteHandler := handlers.NewPasteHandler(store, auditLog)
+	previewHandler := handlers.NewPreviewHandler(store, holder)
+	infoHandler := handlers.NewInfoHandler(store)
+	loginHandler := handlers.NewLoginHandler(holder, auditLog)
+	relays := handlers.NewRelayHub()
+	relayHandler := handlers.NewRelayHandler(store, relays, requests, holder, auditLog)
+	requestHandler := handlers.NewRequestHandler(store, requests, uploadHandler, holder, auditLog)
+	wsHandler := handlers.NewWebSocketHandler(store, relays, requests)
+	configHandler := handlers.NewConfigHandler(holder, store)
+	expiryHandler := handlers.NewExpiryHandler(store, holder, auditLog)
+	adminHandler := handlers.NewAdminHandler(store, holder, auditLog)
+	metricsHandler := handlers.NewMetricsHandler(holder, auditLog)
 
 	// Serve static files from public directory (Svelte build output)
 	fileServer := http.FileServer(http.Dir("./public"))
@@ -56,6 +226,11 @@ func main() {
 			uploadHandler.ServeHTTP(w, r)
 			return
 		}
+		// The admin dashboard is part of the single-page frontend
+		if r.URL.Path == "/admin" || r.URL.Path == "/admin/" {
+			http.ServeFile(w, r, "./public/index.html")
+			return
+		}
 		// Serve static files for GET requests
 		fileServer.ServeHTTP(w, r)
 	})
@@ -63,11 +238,87 @@ func main() {
 	http.Handle("/login", loginHandler)
 	http.Handle("/config", configHandler)
 	http.Handle("/download/", downloadHandler)
+	http.Handle("/raw/", downloadHandler)
+	http.Handle("/inline/", downloadHandler)
+	http.Handle("/f/", previewHandler)
+	http.Handle("/info/", infoHandler)
+	http.Handle("/thumb/", infoHandler)
+	http.Handle("/paste", uploadHandler)
+	http.Handle("/paste/", uploadHandler)
+	http.Handle("/p/", pasteHandler)
 	http.Handle("/ws/", wsHandler)
+	http.Handle("/relay", relayHandler)
+	http.Handle("/relay/", relayHandler)
+	http.Handle("/requests", requestHandler)
+	http.Handle("/requests/", requestHandler)
+	http.Handle("/r/", requestHandler)
+	http.Handle("/expiry/", expiryHandler)
+	http.Handle("/admin/api/", adminHandler)
+	http.Handle("/metrics", metricsHandler)
+
+	// Stop on SIGINT/SIGTERM (e.g. docker stop) with a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv, err := server.New(holder, handlers.RequestLogger(holder, http.DefaultServeMux))
+	if err != nil {
+		fatal("Failed to set up TLS", err)
+	}
+	srv.RegisterOnShutdown(uploadHandler.StopAccepting)
+	srv.RegisterOnShutdown(relayHandler.StopAccepting)
+	srv.RegisterOnShutdown(func() {
+		store.CloseWSClients("server shutting down")
+	})
+
+	// Reload the configuration and TLS certificates on SIGHUP, without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("Received SIGHUP, reloading configuration and TLS certificates")
+			reloadConfig(holder, auditLog)
+			if err := srv.ReloadCertificates(); err != nil {
+				slog.Error("Failed to reload TLS certificates", "error", err)
+			}
+		}
+	}()
 
 	// Start server
-	log.Printf("Server starting on http://localhost%s", cfg.Port)
-	if err := http.ListenAndServe(cfg.Port, nil); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	runErr := srv.Run(ctx)
+
+	// Flush state and remove uploads that were cut off
+	store.StopExpiryTimers()
+	store.Flush()
+	if err := auditLog.Close(); err != nil {
+		slog.Error("Error closing audit log", "error", err)
+	}
+	if err := storage.RemoveIncompleteFiles(cfg.UploadDir); err != nil {
+		slog.Warn("Cleanup of incomplete uploads failed", "error", err)
+	}
+
+	if runErr != nil {
+		fatal("Server failed", runErr)
+	}
+}
+
+// reloadConfig applies the runtime-changeable settings after a SIGHUP
+func reloadConfig(holder *config.Holder, auditLog *audit.Logger) {
+	result, err := holder.Reload()
+	auditLog.Log(audit.Record{
+		Event:    audit.EventConfigReload,
+		Success:  err == nil,
+		Identity: audit.IdentitySystem,
+		Detail:   config.DescribeReload(result, err),
+	})
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping the current configuration", "error", err)
+		return
 	}
+	slog.Info("Configuration reloaded", "changed", result.Changed, "restart_required", result.RestartRequired)
+}
+
+// fatal logs an unrecoverable error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric func() collector
+		want   string
+	}{
+		{
+			name: "counter",
+			metric: func() collector {
+				c := &Counter{name: "test_total", help: "A counter."}
+				c.Add(2)
+				c.Inc()
+				c.Add(-5)
+				return c
+			},
+			want: "# HELP test_total A counter.\n# TYPE test_total counter\ntest_total 3\n",
+		},
+		{
+			name: "counter vec sorted by label",
+			metric: func() collector {
+				c := &CounterVec{name: "test_total", help: "By outcome.", label: "outcome", values: make(map[string]float64)}
+				c.Inc("success")
+				c.Inc("error")
+				c.Inc("success")
+				return c
+			},
+			want: "# HELP test_total By outcome.\n# TYPE test_total counter\n" +
+				"test_total{outcome=\"error\"} 1\ntest_total{outcome=\"success\"} 2\n",
+		},
+		{
+			name: "label escaping",
+			metric: func() collector {
+				c := &CounterVec{name: "test_total", help: "Escapes.", label: "path", values: make(map[string]float64)}
+				c.Inc("a\"b\\c\nd")
+				return c
+			},
+			want: "# HELP test_total Escapes.\n# TYPE test_total counter\ntest_total{path=\"a\\\"b\\\\c\\nd\"} 1\n",
+		},
+		{
+			name:   "help escaping",
+			metric: func() collector { return &Counter{name: "test_total", help: "Two\nlines \\ slash"} },
+			want:   "# HELP test_total Two\\nlines \\\\ slash\n# TYPE test_total counter\ntest_total 0\n",
+		},
+		{
+			name: "gauge",
+			metric: func() collector {
+				g := &Gauge{name: "test_open", help: "Open."}
+				g.Inc()
+				g.Inc()
+				g.Dec()
+				g.Add(0.5)
+				return g
+			},
+			want: "# HELP test_open Open.\n# TYPE test_open gauge\ntest_open 1.5\n",
+		},
+		{
+			name: "gauge func",
+			metric: func() collector {
+				return &GaugeFunc{name: "test_bytes", help: "Bytes.", fn: func() float64 { return 1 << 30 }}
+			},
+			want: "# HELP test_bytes Bytes.\n# TYPE test_bytes gauge\ntest_bytes 1.073741824e+09\n",
+		},
+		{
+			name: "infinite gauge",
+			metric: func() collector {
+				return &GaugeFunc{name: "test_inf", help: "Inf.", fn: func() float64 { return math.Inf(1) }}
+			},
+			want: "# HELP test_inf Inf.\n# TYPE test_inf gauge\ntest_inf +Inf\n",
+		},
+		{
+			name: "histogram",
+			metric: func() collector {
+				h := &Histogram{name: "test_size", help: "Sizes.", buckets: []float64{1, 10}, counts: make([]uint64, 2)}
+				h.Observe(0.5)
+				h.Observe(5)
+				h.Observe(50)
+				return h
+			},
+			want: "# HELP test_size Sizes.\n# TYPE test_size histogram\n" +
+				"test_size_bucket{le=\"1\"} 1\ntest_size_bucket{le=\"10\"} 2\ntest_size_bucket{le=\"+Inf\"} 3\n" +
+				"test_size_sum 55.5\ntest_size_count 3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			tt.metric().write(&b)
+			if b.String() != tt.want {
+				t.Errorf("exposition:\n%s\nwant:\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+// sampleLine matches a sample of the text exposition format: name, optional labels, value
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*"\})? (\S+)$`)
+
+func TestHandler(t *testing.T) {
+	UploadsTotal.Inc("success")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Every family has HELP and TYPE lines before its samples, and is exposed once
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, typ, _ := strings.Cut(rest, " ")
+			if _, dup := types[name]; dup {
+				t.Errorf("family %s exposed twice", name)
+			}
+			types[name] = typ
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("invalid sample line %q", line)
+			continue
+		}
+		family := m[1]
+		if _, ok := types[family]; !ok {
+			family = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(family, "_bucket"), "_sum"), "_count")
+		}
+		if _, ok := types[family]; !ok {
+			t.Errorf("sample %q has no TYPE line before it", line)
+		}
+	}
+
+	for name, typ := range map[string]string{
+		"qcus_uploads_total":         "counter",
+		"qcus_bytes_in_total":        "counter",
+		"qcus_upload_size_bytes":     "histogram",
+		"qcus_websocket_connections": "gauge",
+	} {
+		if types[name] != typ {
+			t.Errorf("%s has type %q, want %q", name, types[name], typ)
+		}
+	}
+	if !strings.Contains(rec.Body.String(), "qcus_uploads_total{outcome=\"success\"} ") {
+		t.Errorf("exposition lacks the upload counter sample:\n%s", rec.Body.String())
+	}
+}
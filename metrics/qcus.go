@@ -0,0 +1,42 @@
+package metrics
+
+// Server metrics. Label values are kept to small, fixed sets so that the
+// number of exposed series stays bounded.
+var (
+	// UploadsTotal counts upload requests by outcome (success, unauthorized, rejected, timeout, error)
+	UploadsTotal = NewCounterVec("qcus_uploads_total", "Upload requests by outcome.", "outcome")
+
+	// DownloadsTotal counts download requests by outcome (success, not_found, interrupted, error)
+	DownloadsTotal = NewCounterVec("qcus_downloads_total", "Download requests by outcome.", "outcome")
+
+	// BytesInTotal counts bytes of successfully stored uploads
+	BytesInTotal = NewCounter("qcus_bytes_in_total", "Bytes received in successful uploads.")
+
+	// BytesOutTotal counts bytes sent to downloaders, including interrupted transfers
+	BytesOutTotal = NewCounter("qcus_bytes_out_total", "Bytes sent in downloads.")
+
+	// UploadSizeBytes observes the size of stored uploads
+	UploadSizeBytes = NewHistogram("qcus_upload_size_bytes", "Size of successful uploads in bytes.",
+		[]float64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20, 1 << 30, 10 << 30})
+
+	// WebSocketConnections tracks currently open download notification sockets
+	WebSocketConnections = NewGauge("qcus_websocket_connections", "Currently open WebSocket connections.")
+
+	// AuthFailuresTotal counts rejected credentials by endpoint
+	AuthFailuresTotal = NewCounterVec("qcus_auth_failures_total", "Failed authentication attempts by endpoint.", "endpoint")
+
+	// RateLimitRejectionsTotal counts requests or connections refused by rate limiting
+	RateLimitRejectionsTotal = NewCounter("qcus_rate_limit_rejections_total", "Requests or connections rejected by rate limits.")
+
+	// RelayTransfersTotal counts relay transfers by outcome (success, timeout, aborted, rejected)
+	RelayTransfersTotal = NewCounterVec("qcus_relay_transfers_total", "Relay transfers by outcome.", "outcome")
+
+	// RelayBytesTotal counts bytes streamed from relay senders to receivers
+	RelayBytesTotal = NewCounter("qcus_relay_bytes_total", "Bytes streamed through relays.")
+
+	// ExpirationsTotal counts files deleted because they reached their expiry time
+	ExpirationsTotal = NewCounter("qcus_expirations_total", "Files deleted after reaching their expiry time.")
+
+	// EvictionsTotal counts files deleted before their expiry to make room for uploads
+	EvictionsTotal = NewCounter("qcus_evictions_total", "Files deleted early to make room for uploads.")
+)
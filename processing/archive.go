@@ -0,0 +1,348 @@
+package processing
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"go-quick-cli-upload-server/storage"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// maxListedEntries is how many entries of an archive are kept for its listing
+	maxListedEntries = 1000
+	// maxScannedEntries stops counting entries of archives with absurd numbers of them
+	maxScannedEntries = 100_000
+	// maxScannedBytes bounds how much of a compressed tarball is decompressed to find its
+	// entries, so that compression bombs cannot keep the server busy
+	maxScannedBytes = 256 << 20
+	// maxZstdWindow bounds the memory the zstd decoder may allocate for the window of a frame
+	maxZstdWindow = 32 << 20
+)
+
+var (
+	zipSignature      = []byte("PK\x03\x04")
+	emptyZipSignature = []byte("PK\x05\x06") // also the end of central directory record
+	zipDirSignature   = []byte("PK\x01\x02")
+	zip64EndSignature = []byte("PK\x06\x06")
+	zip64LocSignature = []byte("PK\x06\x07")
+	gzipSignature     = []byte{0x1f, 0x8b}
+	zstdSignature     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// tarMagicOffset is where the ustar magic of the first tar header is
+	tarMagicOffset = 257
+	tarMagic       = []byte("ustar")
+)
+
+// Sizes of zip records, without their variable-length fields
+const (
+	zipEndLen        = 22
+	zip64LocLen      = 20
+	zip64EndLen      = 56
+	zipDirHeaderLen  = 46
+	maxZipCommentLen = 1<<16 - 1
+)
+
+var (
+	// errScanLimit stops scanning an archive at one of the limits
+	errScanLimit = errors.New("archive scan limit reached")
+	// errZipFormat is returned for zip files whose central directory cannot be found or read
+	errZipFormat = errors.New("invalid zip central directory")
+)
+
+// ArchiveStep lists the entries of zip, tar, and gzip- or zstd-compressed tar uploads. Zip files
+// are listed from their central directory without decompressing anything.
+type ArchiveStep struct{}
+
+// Name implements Step
+func (ArchiveStep) Name() string {
+	return "archive"
+}
+
+// Process implements Step
+func (ArchiveStep) Process(f *storage.StoredFile) error {
+	if f.Encoding != "" {
+		// Only text is stored compressed
+		return nil
+	}
+	src, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	head := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	head = head[:n]
+
+	var listing archiveListing
+	switch {
+	case bytes.HasPrefix(head, zipSignature), bytes.HasPrefix(head, emptyZipSignature):
+		listing.info.Format = "zip"
+		err = listing.scanZip(src, f.Size)
+	case isTar(head):
+		listing.info.Format = "tar"
+		_, err = src.Seek(0, io.SeekStart)
+		if err == nil {
+			err = listing.scanTar(src)
+		}
+	case bytes.HasPrefix(head, gzipSignature), bytes.HasPrefix(head, zstdSignature):
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		format, decoder, closeDecoder, decErr := newDecompressor(head, bufio.NewReader(src))
+		if decErr != nil {
+			return nil
+		}
+		defer closeDecoder()
+		decompressed := bufio.NewReader(&limitedReader{r: decoder, n: maxScannedBytes})
+		if inner, _ := decompressed.Peek(tarMagicOffset + len(tarMagic)); !isTar(inner) {
+			// A single compressed file, not a tarball
+			return nil
+		}
+		listing.info.Format = format
+		err = listing.scanTar(decompressed)
+	default:
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, errScanLimit):
+		listing.info.Truncated = true
+	case err != nil && listing.info.Count == 0:
+		// Not an archive after all, or too damaged to list anything
+		return nil
+	case err != nil:
+		// Keep what could be listed before the damage
+		listing.info.Truncated = true
+	}
+
+	if err := storage.WriteListing(*f, listing.entries); err != nil {
+		return err
+	}
+	info := listing.info
+	f.Archive = &info
+	return nil
+}
+
+// newDecompressor returns the format name and a decoder of a tarball compressed with gzip or
+// zstd, as told by head, along with a function releasing the decoder
+func newDecompressor(head []byte, src io.Reader) (format string, r io.Reader, closeFn func(), err error) {
+	if bytes.HasPrefix(head, zstdSignature) {
+		zr, err := zstd.NewReader(src, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxWindow(maxZstdWindow))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		return "tar.zst", zr, zr.Close, nil
+	}
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return "tar.gz", gz, func() { gz.Close() }, nil
+}
+
+// isTar reports whether a file starts with a POSIX or GNU tar header
+func isTar(head []byte) bool {
+	return len(head) >= tarMagicOffset+len(tarMagic) &&
+		bytes.Equal(head[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic)
+}
+
+// archiveListing collects the entries of an archive up to the listing limits
+type archiveListing struct {
+	info    storage.ArchiveInfo
+	entries []storage.ArchiveEntry
+}
+
+// add records an entry, or returns errScanLimit once too many were found
+func (l *archiveListing) add(entry storage.ArchiveEntry) error {
+	if l.info.Count >= maxScannedEntries {
+		return errScanLimit
+	}
+	l.info.Count++
+	l.info.TotalSize += entry.Size
+	if len(l.entries) < maxListedEntries {
+		l.entries = append(l.entries, entry)
+	}
+	return nil
+}
+
+// scanZip lists a zip file from its central directory. The headers are read one at a time up
+// to the entry count of the end record, so that only the listed entries are kept in memory and
+// a huge directory stops at the scan limit.
+func (l *archiveListing) scanZip(src io.ReaderAt, size int64) error {
+	offset, length, count, err := zipDirectory(src, size)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(io.NewSectionReader(src, offset, length))
+	header := make([]byte, zipDirHeaderLen)
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return errZipFormat
+		}
+		if !bytes.HasPrefix(header, zipDirSignature) {
+			return errZipFormat
+		}
+		le := binary.LittleEndian
+		nameLen, extraLen, commentLen := int(le.Uint16(header[28:])), int(le.Uint16(header[30:])), int(le.Uint16(header[32:]))
+		fields := make([]byte, nameLen+extraLen)
+		if _, err := io.ReadFull(r, fields); err != nil {
+			return errZipFormat
+		}
+		if _, err := r.Discard(commentLen); err != nil {
+			return errZipFormat
+		}
+
+		name := string(fields[:nameLen])
+		entry := storage.ArchiveEntry{
+			Path:     name,
+			Size:     int64(le.Uint32(header[24:])),
+			Modified: msDosTime(le.Uint16(header[14:]), le.Uint16(header[12:])),
+			Dir:      len(name) > 0 && name[len(name)-1] == '/',
+		}
+		zipExtra(&entry, fields[nameLen:])
+		if entry.Dir {
+			entry.Size = 0
+		}
+		if err := l.add(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipDirectory reads the offset, length and entry count of the central directory from the end
+// record of a zip file, or from the zip64 end record it points to
+func zipDirectory(src io.ReaderAt, size int64) (offset, length int64, count uint64, err error) {
+	le := binary.LittleEndian
+	// The end record is followed by a comment of at most 64 KB
+	tailLen := min(size, zipEndLen+maxZipCommentLen)
+	tail := make([]byte, tailLen)
+	if _, err := src.ReadAt(tail, size-tailLen); err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, 0, err
+	}
+	i := bytes.LastIndex(tail, emptyZipSignature)
+	if i < 0 || len(tail)-i < zipEndLen {
+		return 0, 0, 0, errZipFormat
+	}
+	end := tail[i:]
+	count = uint64(le.Uint16(end[10:]))
+	length = int64(le.Uint32(end[12:]))
+	offset = int64(le.Uint32(end[16:]))
+
+	if count == 0xffff || length == 0xffffffff || offset == 0xffffffff {
+		if locAt := size - tailLen + int64(i) - zip64LocLen; locAt >= 0 {
+			loc := make([]byte, zip64LocLen)
+			if _, err := src.ReadAt(loc, locAt); err == nil && bytes.HasPrefix(loc, zip64LocSignature) {
+				record := make([]byte, zip64EndLen)
+				recordAt := int64(le.Uint64(loc[8:]))
+				if _, err := src.ReadAt(record, recordAt); err != nil || !bytes.HasPrefix(record, zip64EndSignature) {
+					return 0, 0, 0, errZipFormat
+				}
+				count = le.Uint64(record[32:])
+				length = int64(le.Uint64(record[40:]))
+				offset = int64(le.Uint64(record[48:]))
+			}
+		}
+	}
+	if offset < 0 || length < 0 || offset > size || length > size-offset {
+		return 0, 0, 0, errZipFormat
+	}
+	return offset, length, count, nil
+}
+
+// zipExtra applies the zip64 size and the extended timestamp found in the extra field of a
+// central directory header
+func zipExtra(entry *storage.ArchiveEntry, extra []byte) {
+	le := binary.LittleEndian
+	for len(extra) >= 4 {
+		tag, n := le.Uint16(extra), int(le.Uint16(extra[2:]))
+		extra = extra[4:]
+		if n > len(extra) {
+			return
+		}
+		field := extra[:n]
+		extra = extra[n:]
+		switch tag {
+		case 0x0001:
+			// Zip64: the 64-bit values of the header fields set to 0xffffffff, uncompressed size first
+			if entry.Size == 0xffffffff && len(field) >= 8 {
+				entry.Size = int64(le.Uint64(field))
+			}
+		case 0x5455:
+			// Extended timestamp: flags, then the modification time in Unix seconds if bit 0 is set
+			if len(field) >= 5 && field[0]&1 != 0 {
+				entry.Modified = time.Unix(int64(int32(le.Uint32(field[1:]))), 0).UTC()
+			}
+		}
+	}
+}
+
+// msDosTime converts the date and time of a zip header
+func msDosTime(date, t uint16) time.Time {
+	return time.Date(int(date>>9)+1980, time.Month(date>>5&0xf), int(date&0x1f),
+		int(t>>11), int(t>>5&0x3f), int(t&0x1f)*2, 0, time.UTC)
+}
+
+// scanTar lists a tarball by reading its headers. Entry contents are skipped, which for
+// plain tar files on disk is a seek rather than a read.
+func (l *archiveListing) scanTar(src io.Reader) error {
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+		default:
+			// Devices, FIFOs and extended headers are not interesting to recipients
+			continue
+		}
+		entry := storage.ArchiveEntry{
+			Path:     header.Name,
+			Size:     header.Size,
+			Modified: header.ModTime.UTC(),
+			Dir:      header.Typeflag == tar.TypeDir,
+		}
+		if entry.Dir {
+			entry.Size = 0
+		}
+		if err := l.add(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// limitedReader reads at most n bytes from r, then fails with errScanLimit
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errScanLimit
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,279 @@
+package processing
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-quick-cli-upload-server/storage"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// scanArchive stores data in a temporary file and runs the archive step on it
+func scanArchive(t *testing.T, data []byte) (*storage.ArchiveInfo, []storage.ArchiveEntry) {
+	t.Helper()
+	f := storage.StoredFile{Path: filepath.Join(t.TempDir(), "upload"), Size: int64(len(data))}
+	if err := os.WriteFile(f.Path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := (ArchiveStep{}).Process(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Archive == nil {
+		return nil, nil
+	}
+	entries, err := storage.ReadListing(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Archive, entries
+}
+
+// testFile is an entry of a generated archive, a directory when its name ends with a slash
+type testFile struct {
+	name string
+	size int
+}
+
+var testFiles = []testFile{{"readme.txt", 12}, {"docs/", 0}, {"docs/guide.md", 3000}}
+
+var testModified = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+func makeZip(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: testModified})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte("x"), f.size))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTar writes files to w as a tarball; the content of every file is zeros
+func writeTar(t *testing.T, w io.Writer, files []testFile) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Size: int64(f.size), Mode: 0o644, ModTime: testModified, Typeflag: tar.TypeReg}
+		if f.name[len(f.name)-1] == '/' {
+			header.Typeflag, header.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.CopyN(tw, zeros{}, int64(f.size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// zeros is an endless stream of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func makeTar(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writeTar(t, &buf, files)
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	writeTar(t, gz, files)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarZst(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTar(t, zw, files)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveListing(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+	}{
+		{"zip", makeZip(t, testFiles), "zip"},
+		{"tar", makeTar(t, testFiles), "tar"},
+		{"tar.gz", makeTarGz(t, testFiles), "tar.gz"},
+		{"tar.zst", makeTarZst(t, testFiles), "tar.zst"},
+	}
+	for _, tt := range tests {
+		info, entries := scanArchive(t, tt.data)
+		if info == nil {
+			t.Errorf("%s: not listed", tt.name)
+			continue
+		}
+		want := storage.ArchiveInfo{Format: tt.format, Count: 3, TotalSize: 3012}
+		if *info != want {
+			t.Errorf("%s: info = %+v, want %+v", tt.name, *info, want)
+		}
+		if len(entries) != len(testFiles) {
+			t.Errorf("%s: %d entries listed, want %d", tt.name, len(entries), len(testFiles))
+			continue
+		}
+		for i, f := range testFiles {
+			e := entries[i]
+			dir := f.name[len(f.name)-1] == '/'
+			if e.Path != f.name || e.Size != int64(f.size) || e.Dir != dir || !e.Modified.Equal(testModified) {
+				t.Errorf("%s: entry %d = %+v, want %s of %d bytes modified at %s", tt.name, i, e, f.name, f.size, testModified)
+			}
+		}
+	}
+}
+
+func TestArchiveNotListed(t *testing.T) {
+	var single bytes.Buffer
+	gz := gzip.NewWriter(&single)
+	gz.Write([]byte("a single compressed file, not a tarball"))
+	gz.Close()
+	zipData := makeZip(t, testFiles)
+
+	tests := map[string][]byte{
+		"text":              []byte("hello world"),
+		"empty":             nil,
+		"compressed file":   single.Bytes(),
+		"zip without a end": zipData[:len(zipData)-30],
+	}
+	for name, data := range tests {
+		if info, _ := scanArchive(t, data); info != nil {
+			t.Errorf("%s: listed as %+v, want no listing", name, *info)
+		}
+	}
+}
+
+func TestArchiveTruncatedFile(t *testing.T) {
+	files := []testFile{{"a.bin", 1024}, {"b.bin", 1024}, {"c.bin", 1024}}
+	// Cut in the middle of the header of the second entry
+	data := makeTar(t, files)[:512+1024+100]
+	info, entries := scanArchive(t, data)
+	if info == nil {
+		t.Fatal("not listed")
+	}
+	if !info.Truncated || info.Count != 1 || len(entries) != 1 || entries[0].Path != "a.bin" {
+		t.Errorf("info = %+v with %d entries, want a.bin only and truncated", *info, len(entries))
+	}
+}
+
+func TestZip64(t *testing.T) {
+	t.Run("large entry", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		// The central directory is all that is read, so the data need not match the sizes
+		header := &zip.FileHeader{Name: "huge.img", Method: zip.Store, CompressedSize64: 4, UncompressedSize64: 5 << 30}
+		w, err := zw.CreateRaw(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("data"))
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		info, entries := scanArchive(t, buf.Bytes())
+		if info == nil || info.Count != 1 || len(entries) != 1 || entries[0].Size != 5<<30 {
+			t.Fatalf("info = %+v, entries = %+v, want one entry of 5 GB", info, entries)
+		}
+	})
+
+	t.Run("many entries", func(t *testing.T) {
+		// More entries than the 16-bit count of the end record holds, so it points to a zip64 end record
+		const count = 1<<16 + 10
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for i := range count {
+			if _, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("f%d", i), Method: zip.Store}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		info, entries := scanArchive(t, buf.Bytes())
+		if info == nil {
+			t.Fatal("not listed")
+		}
+		if info.Count != count || info.Truncated || len(entries) != maxListedEntries {
+			t.Errorf("info = %+v with %d entries listed, want %d counted and %d listed", *info, len(entries), count, maxListedEntries)
+		}
+		if entries[len(entries)-1].Path != fmt.Sprintf("f%d", maxListedEntries-1) {
+			t.Errorf("last listed entry is %s", entries[len(entries)-1].Path)
+		}
+	})
+}
+
+func TestArchiveListedEntriesLimit(t *testing.T) {
+	files := make([]testFile, maxListedEntries+5)
+	for i := range files {
+		files[i] = testFile{fmt.Sprintf("file%04d", i), 1}
+	}
+	info, entries := scanArchive(t, makeTarGz(t, files))
+	if info == nil {
+		t.Fatal("not listed")
+	}
+	if info.Count != len(files) || info.TotalSize != int64(len(files)) || info.Truncated || len(entries) != maxListedEntries {
+		t.Errorf("info = %+v with %d entries listed, want %d counted and %d listed", *info, len(entries), len(files), maxListedEntries)
+	}
+}
+
+func TestCompressionBomb(t *testing.T) {
+	// The first entry decompresses to more than the scan limit, hiding the second one
+	files := []testFile{{"zeros.bin", maxScannedBytes + 1<<20}, {"after.txt", 1}}
+	tests := map[string]func(*testing.T, []testFile) []byte{
+		"tar.gz":  makeTarGz,
+		"tar.zst": makeTarZst,
+	}
+	for format, makeArchive := range tests {
+		data := makeArchive(t, files)
+		if len(data) > 2<<20 {
+			t.Fatalf("%s: the bomb takes %d bytes", format, len(data))
+		}
+		info, entries := scanArchive(t, data)
+		if info == nil {
+			t.Errorf("%s: not listed", format)
+			continue
+		}
+		if info.Format != format || !info.Truncated || info.Count != 1 || len(entries) != 1 {
+			t.Errorf("%s: info = %+v with %d entries, want the first entry only and truncated", format, *info, len(entries))
+		}
+	}
+}
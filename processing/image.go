@@ -0,0 +1,166 @@
+package processing
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-quick-cli-upload-server/storage"
+)
+
+const (
+	// ThumbnailSize is the longest side of generated thumbnails, in pixels
+	ThumbnailSize = 320
+	// maxDecodeBytes stops images that would take too much memory to decode, such as
+	// decompression bombs, from getting a thumbnail; their dimensions are still recorded
+	maxDecodeBytes = 128 << 20
+	// maxConcurrentDecodes is how many images are decoded for thumbnails at once. Uploads
+	// beyond it wait, so decoding never takes more than maxConcurrentDecodes × maxDecodeBytes.
+	maxConcurrentDecodes = 2
+	// samplesPerAxis is how many source pixels are averaged per thumbnail pixel and axis
+	samplesPerAxis = 4
+)
+
+// decodeSlots holds a token for each thumbnail being generated
+var decodeSlots = make(chan struct{}, maxConcurrentDecodes)
+
+// ImageStep records the format and dimensions of PNG, JPEG and GIF uploads and stores a
+// JPEG thumbnail next to them
+type ImageStep struct{}
+
+// Name implements Step
+func (ImageStep) Name() string {
+	return "image"
+}
+
+// Process implements Step
+func (ImageStep) Process(f *storage.StoredFile) error {
+	// Only text is stored compressed
+	if !strings.HasPrefix(f.ContentType, "image/") || f.Encoding != "" {
+		return nil
+	}
+
+	src, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	cfg, format, err := image.DecodeConfig(src)
+	if err != nil {
+		// Not a format the standard library decodes, such as WebP
+		return nil
+	}
+	f.Image = &storage.ImageInfo{Format: format, Width: cfg.Width, Height: cfg.Height}
+	if cfg.Width <= 0 || cfg.Height <= 0 ||
+		int64(cfg.Width)*int64(cfg.Height) > maxDecodeBytes/bytesPerPixel(cfg.ColorModel) {
+		return nil
+	}
+
+	decodeSlots <- struct{}{}
+	defer func() { <-decodeSlots }()
+
+	if _, err := src.Seek(0, 0); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+	if err := writeThumbnail(f.ThumbnailPath(), img); err != nil {
+		return err
+	}
+	f.Image.Thumbnail = true
+	return nil
+}
+
+// bytesPerPixel estimates the memory a decoded pixel takes in the given colour model
+func bytesPerPixel(model color.Model) int64 {
+	switch model {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel:
+		// Without chroma subsampling, which most JPEGs use
+		return 3
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel:
+		return 4
+	}
+	if _, ok := model.(color.Palette); ok {
+		return 1
+	}
+	// 16-bit colour, and models not listed above
+	return 8
+}
+
+// writeThumbnail scales img down to ThumbnailSize and saves it as a JPEG. It is written to a
+// temporary file first, as uploads sharing the image may be writing it at the same time.
+func writeThumbnail(path string, img image.Image) error {
+	thumb := scaleDown(img, ThumbnailSize)
+
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+storage.IncompleteSuffix)
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	if err := jpeg.Encode(out, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("encoding thumbnail: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// scaleDown returns img fitted within size×size pixels, averaging a grid of samples for
+// each pixel. Transparent areas become white, since the thumbnail is a JPEG. Images that
+// already fit keep their size.
+func scaleDown(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			var r, g, bl, a uint64
+			for sy := 0; sy < samplesPerAxis; sy++ {
+				for sx := 0; sx < samplesPerAxis; sx++ {
+					px := b.Min.X + (x*samplesPerAxis+sx)*w/(tw*samplesPerAxis)
+					py := b.Min.Y + (y*samplesPerAxis+sy)*h/(th*samplesPerAxis)
+					cr, cg, cb, ca := img.At(px, py).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+				}
+			}
+			n := uint64(samplesPerAxis * samplesPerAxis)
+			// Colours are premultiplied: blend over the white background
+			over := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + over) >> 8),
+				G: uint8((g/n + over) >> 8),
+				B: uint8((bl/n + over) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
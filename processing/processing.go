@@ -0,0 +1,35 @@
+// Package processing runs post-upload steps on stored files, such as extracting image
+// metadata and generating thumbnails. Steps never modify the uploaded data.
+package processing
+
+import (
+	"log/slog"
+
+	"go-quick-cli-upload-server/storage"
+)
+
+// Step inspects an upload written at f.Path and may add metadata to f or files next to it
+type Step interface {
+	Name() string
+	Process(f *storage.StoredFile) error
+}
+
+// Pipeline runs its steps in order on each upload
+type Pipeline struct {
+	Steps []Step
+}
+
+// NewPipeline creates a pipeline running the given steps
+func NewPipeline(steps ...Step) *Pipeline {
+	return &Pipeline{Steps: steps}
+}
+
+// Run processes f with every step. A failing step is logged and does not stop the others,
+// nor the upload: the file is stored without the metadata it would have added.
+func (p *Pipeline) Run(f *storage.StoredFile) {
+	for _, step := range p.Steps {
+		if err := step.Process(f); err != nil {
+			slog.Warn("Upload processing step failed", "step", step.Name(), "file_id", f.ID, "error", err)
+		}
+	}
+}
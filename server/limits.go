@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+
+	"go-quick-cli-upload-server/metrics"
+)
+
+// perIPListener caps the number of concurrent connections accepted from a single IP.
+// Connections over the limit are closed immediately after being accepted.
+type perIPListener struct {
+	net.Listener
+	max func() int // current limit, read on every Accept so that it can be reloaded; 0 is unlimited
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// limitConnsPerIP wraps ln so that no IP holds more than max() connections at once.
+// Connections are counted even while there is no limit, so that one set later applies to them.
+func limitConnsPerIP(ln net.Listener, max func() int) net.Listener {
+	return &perIPListener{
+		Listener: ln,
+		max:      max,
+		counts:   make(map[string]int),
+	}
+}
+
+// Accept implements net.Listener
+func (l *perIPListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		limit := l.max()
+		if l.acquire(ip, limit) {
+			return &trackedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+		}
+
+		metrics.RateLimitRejectionsTotal.Inc()
+		slog.Warn("Rejected connection: too many concurrent connections from IP", "client_ip", ip, "limit", limit)
+		conn.Close()
+	}
+}
+
+// acquire reserves a connection slot for ip if it holds fewer than limit, or limit is 0
+func (l *perIPListener) acquire(ip string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit > 0 && l.counts[ip] >= limit {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+// release frees a connection slot for ip
+func (l *perIPListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[ip]--; l.counts[ip] <= 0 {
+		delete(l.counts, ip)
+	}
+}
+
+// trackedConn releases its slot exactly once when closed
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close implements net.Conn
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// remoteIP returns the IP of the connection's peer
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
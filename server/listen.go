@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"go-quick-cli-upload-server/config"
+)
+
+// systemdFirstFD is the first file descriptor passed by systemd socket activation (SD_LISTEN_FDS_START)
+const systemdFirstFD = 3
+
+// boundListener is an open listener together with the address it was configured from
+type boundListener struct {
+	net.Listener
+	addr config.ListenAddr
+}
+
+// openListeners opens every configured listen address, closing those already opened if one fails
+func openListeners(addrs []config.ListenAddr) ([]boundListener, error) {
+	var (
+		listeners []boundListener
+		activated []systemdListener
+		err       error
+	)
+
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		for _, ln := range activated {
+			ln.Close()
+		}
+	}
+
+	for _, addr := range addrs {
+		switch addr.Network {
+		case config.NetworkSystemd:
+			if activated == nil {
+				if activated, err = systemdListeners(); err != nil {
+					closeAll()
+					return nil, err
+				}
+			}
+			var matched int
+			activated, matched = takeSystemdListeners(activated, addr, &listeners)
+			if matched == 0 {
+				closeAll()
+				return nil, fmt.Errorf("no systemd socket matches %s", addr)
+			}
+
+		case config.NetworkUnix:
+			ln, err := listenUnix(addr.Address, addr.Mode)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			listeners = append(listeners, boundListener{Listener: ln, addr: addr})
+
+		default:
+			ln, err := net.Listen("tcp", addr.Address)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+			}
+			listeners = append(listeners, boundListener{Listener: ln, addr: addr})
+		}
+	}
+
+	// Sockets passed by systemd but not referenced in LISTEN are not served
+	for _, ln := range activated {
+		ln.Close()
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a Unix domain socket, replacing a stale socket left by a previous run
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("failed to listen on unix://%s: file exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on unix://%s: %w", path, err)
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set permissions on %s: %w", path, err)
+		}
+	}
+	return ln, nil
+}
+
+// systemdListener is a socket inherited through systemd socket activation
+type systemdListener struct {
+	net.Listener
+	name string
+}
+
+// systemdListeners returns the sockets passed by systemd, as described in sd_listen_fds(3)
+func systemdListeners() ([]systemdListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("systemd listen address configured but the process was not socket-activated")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, errors.New("systemd passed no sockets (LISTEN_FDS)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Keep the variables from leaking into child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]systemdListener, 0, count)
+	for i := 0; i < count; i++ {
+		fd := systemdFirstFD + i
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd socket %d is not a listening socket: %w", fd, err)
+		}
+		listeners = append(listeners, systemdListener{Listener: ln, name: name})
+	}
+	return listeners, nil
+}
+
+// takeSystemdListeners moves the activated sockets matching addr (all of them when it has no
+// name) into listeners, returning the remaining sockets and how many matched
+func takeSystemdListeners(activated []systemdListener, addr config.ListenAddr, listeners *[]boundListener) ([]systemdListener, int) {
+	remaining := activated[:0]
+	matched := 0
+	for _, ln := range activated {
+		if addr.Address != "" && ln.name != addr.Address {
+			remaining = append(remaining, ln)
+			continue
+		}
+		*listeners = append(*listeners, boundListener{Listener: ln.Listener, addr: addr})
+		matched++
+	}
+	return remaining, matched
+}
+
+// listenURL describes where a listener can be reached, for the startup log
+func listenURL(ln boundListener, scheme string) string {
+	if ln.addr.Network == config.NetworkUnix {
+		return "unix://" + ln.addr.Address
+	}
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		return ln.Addr().String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// httpsPort returns the port of the first TCP listener, which HTTP requests are redirected to
+func httpsPort(addrs []config.ListenAddr) string {
+	for _, addr := range addrs {
+		if addr.Network != config.NetworkTCP {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(addr.Address); err == nil {
+			return port
+		}
+	}
+	return ""
+}
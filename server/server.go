@@ -0,0 +1,161 @@
+// Package server runs the HTTP server and coordinates its graceful shutdown.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"go-quick-cli-upload-server/config"
+)
+
+// Server wraps an http.Server configured from the application config
+type Server struct {
+	cfg            *config.Config // settings that need a restart, as they were at startup
+	config         *config.Holder
+	httpServer     *http.Server
+	redirectServer *http.Server
+	certs          *certReloader
+}
+
+// New creates a Server serving handler, loading or generating the TLS certificate when TLS is enabled
+func New(holder *config.Holder, handler http.Handler) (*Server, error) {
+	cfg := holder.Current()
+	s := &Server{
+		cfg:    cfg,
+		config: holder,
+		httpServer: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout(),
+			IdleTimeout:       cfg.IdleTimeout(),
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		},
+	}
+
+	if !cfg.TLSEnabled() {
+		return s, nil
+	}
+
+	certs, err := newCertReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.certs = certs
+	s.httpServer.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if cfg.TLSRedirectAddr != "" {
+		s.redirectServer = &http.Server{
+			Addr:              cfg.TLSRedirectAddr,
+			Handler:           redirectHandler(httpsPort(cfg.Listen)),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout(),
+			IdleTimeout:       cfg.IdleTimeout(),
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		}
+	}
+	return s, nil
+}
+
+// ReloadCertificates re-reads the TLS certificate and key from disk, keeping the current
+// certificate if they cannot be loaded. It does nothing when TLS is disabled.
+func (s *Server) ReloadCertificates() error {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.Reload()
+}
+
+// RegisterOnShutdown registers a function called as soon as shutdown begins,
+// for example to refuse new uploads or to notify hijacked WebSocket connections
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.httpServer.RegisterOnShutdown(f)
+}
+
+// Run serves until ctx is cancelled, then stops accepting connections and waits up to the
+// configured grace period for in-flight requests before closing the remaining connections.
+func (s *Server) Run(ctx context.Context) error {
+	listeners, err := openListeners(s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if s.certs != nil {
+		scheme = "https"
+		go s.certs.watch(ctx, s.cfg.TLSReloadInterval())
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, bl := range listeners {
+		var ln net.Listener = bl
+		// Unix and systemd sockets are normally fronted by a proxy, so every connection shares one peer address
+		if bl.addr.Network == config.NetworkTCP {
+			ln = limitConnsPerIP(ln, func() int { return s.config.Current().MaxConnsPerIP })
+		}
+		if s.certs != nil {
+			ln = tls.NewListener(ln, s.httpServer.TLSConfig)
+		}
+
+		slog.Info("Server starting", "url", listenURL(bl, scheme))
+		go func() {
+			errCh <- s.httpServer.Serve(ln)
+		}()
+	}
+
+	redirectErrCh := make(chan error, 1)
+	if s.redirectServer != nil {
+		go func() {
+			slog.Info("Redirecting HTTP to HTTPS", "addr", s.redirectServer.Addr)
+			redirectErrCh <- s.redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		s.closeRedirect()
+		s.httpServer.Close()
+		return fmt.Errorf("server failed: %w", err)
+	case err := <-redirectErrCh:
+		s.httpServer.Close()
+		return fmt.Errorf("redirect server failed: %w", err)
+	case <-ctx.Done():
+	}
+	s.closeRedirect()
+
+	grace := s.cfg.ShutdownGracePeriod()
+	slog.Info("Shutting down, waiting for in-flight transfers", "grace_period", grace)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Grace period expired, closing remaining connections", "error", err)
+		if err := s.httpServer.Close(); err != nil {
+			slog.Error("Error closing server", "error", err)
+		}
+	}
+
+	for range listeners {
+		if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server failed: %w", err)
+		}
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+// closeRedirect stops the HTTP-to-HTTPS redirect server if one is running
+func (s *Server) closeRedirect() {
+	if s.redirectServer == nil {
+		return
+	}
+	if err := s.redirectServer.Close(); err != nil {
+		slog.Warn("Error closing redirect server", "error", err)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go-quick-cli-upload-server/config"
+)
+
+// selfSignedValidity is how long a generated self-signed certificate stays valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// certReloader serves the current TLS certificate and swaps it when the files on disk change
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// newCertReloader loads the configured certificate, generating a self-signed one if requested.
+// A self-signed certificate is written to the configured paths when set, so clients only need
+// to trust it once; otherwise it lives in memory until the server stops.
+func newCertReloader(cfg *config.Config) (*certReloader, error) {
+	cr := &certReloader{certFile: cfg.TLSCertFile, keyFile: cfg.TLSKeyFile}
+
+	if cfg.TLSSelfSigned {
+		if cr.certFile == "" {
+			cert, _, _, err := generateSelfSigned(cfg.TLSHosts)
+			if err != nil {
+				return nil, err
+			}
+			cr.cert = cert
+			return cr, nil
+		}
+		if err := ensureSelfSignedFiles(cr.certFile, cr.keyFile, cfg.TLSHosts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// Reload reads the certificate and key from disk. The previous certificate is kept if loading fails.
+func (cr *certReloader) Reload() error {
+	if cr.certFile == "" {
+		return nil
+	}
+
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat TLS key: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.certModTime = certInfo.ModTime()
+	cr.keyModTime = keyInfo.ModTime()
+	cr.mu.Unlock()
+
+	if cert.Leaf != nil {
+		slog.Info("TLS certificate loaded", "file", cr.certFile, "subject", cert.Leaf.Subject.String(), "not_after", cert.Leaf.NotAfter)
+	}
+	return nil
+}
+
+// changed reports whether the certificate or key file was modified since the last load
+func (cr *certReloader) changed() bool {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return false
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return !certInfo.ModTime().Equal(cr.certModTime) || !keyInfo.ModTime().Equal(cr.keyModTime)
+}
+
+// watch polls the certificate files and reloads them when they change, until ctx is cancelled
+func (cr *certReloader) watch(ctx context.Context, interval time.Duration) {
+	if cr.certFile == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !cr.changed() {
+				continue
+			}
+			if err := cr.Reload(); err != nil {
+				slog.Warn("Failed to reload TLS certificate, keeping the previous one", "error", err)
+			}
+		}
+	}
+}
+
+// ensureSelfSignedFiles generates a self-signed certificate at the given paths unless a
+// still-valid certificate is already there
+func ensureSelfSignedFiles(certFile, keyFile string, hosts []string) error {
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if cert.Leaf != nil && time.Now().Before(cert.Leaf.NotAfter) {
+			return nil
+		}
+		slog.Info("Self-signed TLS certificate expired, generating a new one", "file", certFile)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to load self-signed TLS certificate: %w", err)
+	}
+
+	_, certPEM, keyPEM, err := generateSelfSigned(hosts)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write TLS key: %w", err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write TLS certificate: %w", err)
+	}
+	slog.Info("Generated self-signed TLS certificate", "file", certFile)
+	return nil
+}
+
+// generateSelfSigned creates an ECDSA P-256 certificate valid for the given hosts, the loopback
+// addresses and the addresses of the local network interfaces
+func generateSelfSigned(hosts []string) (cert *tls.Certificate, certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"QCUS"}, CommonName: "QCUS self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	template.IPAddresses = append(template.IPAddresses, net.IPv4(127, 0, 0, 1), net.IPv6loopback)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to encode TLS key: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load self-signed certificate: %w", err)
+	}
+	return &pair, certPEM, keyPEM, nil
+}
+
+// redirectHandler redirects plain HTTP requests to the same path on the HTTPS listener
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
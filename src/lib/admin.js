@@ -0,0 +1,72 @@
+// Admin API module for managing active uploads
+
+/**
+ * Performs an authenticated admin API request
+ * @param {string} path - API path below /admin/api
+ * @param {string} adminPassword - The admin password
+ * @param {RequestInit} [options] - Additional fetch options
+ * @returns {Promise<{success: boolean, data?: any, error?: string, unauthorized?: boolean}>}
+ */
+async function adminRequest(path, adminPassword, options = {}) {
+	try {
+		const response = await fetch(`/admin/api${path}`, {
+			...options,
+			headers: {
+				...(options.headers || {}),
+				'X-Admin-Password': adminPassword
+			}
+		});
+
+		if (response.status === 401) {
+			return { success: false, error: 'Invalid admin password', unauthorized: true };
+		}
+		if (!response.ok) {
+			return { success: false, error: (await response.text()).trim() || response.statusText };
+		}
+		if (response.status === 204) {
+			return { success: true };
+		}
+		return { success: true, data: await response.json() };
+	} catch (error) {
+		console.error('Admin API error:', error);
+		return { success: false, error: 'Network error' };
+	}
+}
+
+/**
+ * Lists all active files
+ * @param {string} adminPassword - The admin password
+ */
+export function listFiles(adminPassword) {
+	return adminRequest('/files', adminPassword);
+}
+
+/**
+ * Force-deletes a file
+ * @param {string} adminPassword - The admin password
+ * @param {string} fileID - The file ID
+ */
+export function deleteFile(adminPassword, fileID) {
+	return adminRequest(`/files/${fileID}`, adminPassword, { method: 'DELETE' });
+}
+
+/**
+ * Sets the remaining lifetime of a file
+ * @param {string} adminPassword - The admin password
+ * @param {string} fileID - The file ID
+ * @param {number} minutes - New remaining lifetime in minutes, counted from now
+ */
+export function setFileExpiry(adminPassword, fileID, minutes) {
+	return adminRequest(`/files/${fileID}/expiry`, adminPassword, {
+		method: 'POST',
+		body: new URLSearchParams({ minutes: String(minutes) })
+	});
+}
+
+/**
+ * Reloads the runtime-changeable settings from the server's configuration file
+ * @param {string} adminPassword - The admin password
+ */
+export function reloadConfig(adminPassword) {
+	return adminRequest('/config/reload', adminPassword, { method: 'POST' });
+}
@@ -5,7 +5,7 @@ let configPromise = null;
 
 /**
  * Fetches public configuration from the server
- * @returns {Promise<{isDefaultPassword: boolean, fileExpiryMinutes: number, maxFileSizeMB: number}>}
+ * @returns {Promise<{isDefaultPassword: boolean, fileExpiryMinutes: number, maxExpiryMinutes: number, maxFileSizeMB: number}>}
  */
 export async function getPublicConfig() {
 	if (!configPromise) {
@@ -15,10 +15,10 @@ export async function getPublicConfig() {
 				if (response.ok) {
 					return await response.json();
 				}
-				return { isDefaultPassword: true, fileExpiryMinutes: 10, maxFileSizeMB: 100 };
+				return { isDefaultPassword: true, fileExpiryMinutes: 10, maxFileSizeMB: 100, stripMetadata: false };
 			} catch (error) {
 				console.error('Failed to fetch config:', error);
-				return { isDefaultPassword: true, fileExpiryMinutes: 10, maxFileSizeMB: 100 };
+				return { isDefaultPassword: true, fileExpiryMinutes: 10, maxFileSizeMB: 100, stripMetadata: false };
 			}
 		})();
 	}
@@ -50,9 +50,10 @@ export async function validatePassword(password) {
  * @param {File} file - The file to upload
  * @param {string} password - The upload password
  * @param {Function} onProgress - Progress callback (percentage)
+ * @param {boolean} stripMetadata - Whether to remove EXIF and other metadata from photos
  * @returns {Promise<{success: boolean, data?: object, error?: string}>}
  */
-export function uploadFile(file, password, onProgress) {
+export function uploadFile(file, password, onProgress, stripMetadata = false) {
 	return new Promise((resolve) => {
 		const formData = new FormData();
 		formData.append('file', file);
@@ -75,12 +76,20 @@ export function uploadFile(file, password, onProgress) {
 				const curlMatch = response.match(/cURL command: (.+)/);
 				const nameMatch = response.match(/Original name: ([^\n]+)/);
 				const sizeMatch = response.match(/File size: ([^\n]+)/);
+				const expiresMatch = response.match(/Expires at: ([^\n]+)/);
+				const thumbnailMatch = response.match(/Thumbnail URL: (http[^\s]+)/);
+				const strippedMatch = response.match(/Metadata stripped: ([^\n]+) removed/);
+				const verifyMatch = response.match(/Verify command: (.+)/);
 
 				if (urlMatch) {
 					const downloadURL = urlMatch[1];
 					const curlCommand = curlMatch ? curlMatch[1] : '';
 					const fileName = nameMatch ? nameMatch[1] : '';
 					const fileSize = sizeMatch ? sizeMatch[1] : '';
+					const expiresAt = expiresMatch ? expiresMatch[1] : null;
+					const thumbnailURL = thumbnailMatch ? thumbnailMatch[1] : '';
+					const metadataRemoved = strippedMatch ? strippedMatch[1] : '';
+					const verifyCommand = verifyMatch ? verifyMatch[1] : '';
 					const fileIDMatch = downloadURL.match(/\/download\/([^\/\s]+)/);
 					const fileID = fileIDMatch ? fileIDMatch[1] : null;
 
@@ -91,7 +100,11 @@ export function uploadFile(file, password, onProgress) {
 							fileSize,
 							downloadURL,
 							curlCommand,
-							fileID
+							fileID,
+							expiresAt,
+							thumbnailURL,
+							metadataRemoved,
+							verifyCommand
 						}
 					});
 				} else {
@@ -131,6 +144,95 @@ export function uploadFile(file, password, onProgress) {
 		// Send request
 		xhr.open('POST', '/');
 		xhr.setRequestHeader('X-Upload-Password', password);
+		xhr.setRequestHeader('X-Strip-Metadata', String(stripMetadata));
 		xhr.send(formData);
 	});
 }
+
+/**
+ * Formats a byte count like the server's upload responses
+ * @param {number} bytes
+ * @returns {string}
+ */
+function formatSize(bytes) {
+	if (bytes < 1024) {
+		return `${bytes} B`;
+	}
+	const units = 'KMGTPE';
+	let exp = 0;
+	let value = bytes / 1024;
+	while (value >= 1024 && exp < units.length - 1) {
+		value /= 1024;
+		exp++;
+	}
+	return `${value.toFixed(1)} ${units[exp]}B`;
+}
+
+/**
+ * Uploads a text snippet as a paste
+ * @param {string} text - The text to share
+ * @param {string} language - Language hint for syntax highlighting, or an empty string
+ * @param {string} password - The upload password
+ * @returns {Promise<{success: boolean, data?: object, error?: string, unauthorized?: boolean}>}
+ */
+export async function uploadPaste(text, language, password) {
+	try {
+		const headers = {
+			'X-Upload-Password': password,
+			'Content-Type': 'text/plain; charset=utf-8',
+			Accept: 'application/json'
+		};
+		if (language) {
+			headers['X-Language'] = language;
+		}
+		const response = await fetch('/paste', { method: 'POST', headers, body: text });
+		if (response.status === 401) {
+			return { success: false, error: 'Unauthorized: Invalid password', unauthorized: true };
+		}
+		if (!response.ok) {
+			return { success: false, error: `Upload failed: ${(await response.text()).trim() || response.statusText}` };
+		}
+		const data = await response.json();
+		return {
+			success: true,
+			data: {
+				fileName: data.name,
+				fileSize: formatSize(data.size),
+				downloadURL: data.viewURL,
+				curlCommand: `curl ${data.viewURL.replace('/p/', '/raw/')}`,
+				fileID: data.id,
+				expiresAt: data.expiresAt
+			}
+		};
+	} catch (error) {
+		console.error('Paste upload error:', error);
+		return { success: false, error: 'Upload failed: Network error' };
+	}
+}
+
+/**
+ * Changes the expiry of an uploaded file
+ * @param {string} fileID - The file ID
+ * @param {string} password - The upload password
+ * @param {number} minutes - New remaining lifetime in minutes, counted from now
+ * @returns {Promise<{success: boolean, data?: {fileID: string, expiresAt: string, maxExpiresAt: string}, error?: string}>}
+ */
+export async function changeExpiry(fileID, password, minutes) {
+	try {
+		const body = new URLSearchParams({ minutes: String(minutes) });
+		const response = await fetch(`/expiry/${fileID}`, {
+			method: 'POST',
+			headers: {
+				'X-Upload-Password': password
+			},
+			body
+		});
+		if (response.ok) {
+			return { success: true, data: await response.json() };
+		}
+		return { success: false, error: (await response.text()).trim() || response.statusText };
+	} catch (error) {
+		console.error('Expiry change error:', error);
+		return { success: false, error: 'Network error' };
+	}
+}
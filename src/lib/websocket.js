@@ -4,9 +4,10 @@
  * Creates a WebSocket connection for file download notifications
  * @param {string} fileID - The file ID to monitor
  * @param {Function} onDownloaded - Callback when file is downloaded
+ * @param {Function} [onEvent] - Callback for other lifecycle events (e.g. "expiry-changed")
  * @returns {WebSocket} - The WebSocket connection
  */
-export function connectToFileNotifications(fileID, onDownloaded) {
+export function connectToFileNotifications(fileID, onDownloaded, onEvent) {
 	const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
 	const wsUrl = `${protocol}//${window.location.host}/ws/${fileID}`;
 
@@ -21,6 +22,8 @@ export function connectToFileNotifications(fileID, onDownloaded) {
 			const data = JSON.parse(event.data);
 			if (data.downloaded && onDownloaded) {
 				onDownloaded();
+			} else if (data.event && onEvent) {
+				onEvent(data);
 			}
 		} catch (error) {
 			console.error('WebSocket message error:', error);
@@ -1,8 +1,12 @@
 import './app.css'
 import { mount } from 'svelte'
 import App from './App.svelte'
+import Admin from './Admin.svelte'
 
-const app = mount(App, {
+// The admin dashboard shares the bundle with the upload page
+const isAdminPage = window.location.pathname.replace(/\/$/, '') === '/admin'
+
+const app = mount(isAdminPage ? Admin : App, {
   target: document.getElementById('app'),
 })
 
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobDir is the subdirectory of the upload directory keeping upload data by content. Each
+// blob is named after the SHA-256 of its bytes and shared by all uploads with that content.
+const BlobDir = "blobs"
+
+// BlobPath returns where the blob with the hex-encoded SHA-256 sum is stored in dir, with
+// CompressedSuffix when its encoding is GzipEncoding
+func BlobPath(dir, sum, encoding string) string {
+	path := filepath.Join(dir, BlobDir, sum)
+	if encoding == GzipEncoding {
+		path += CompressedSuffix
+	}
+	return path
+}
+
+// isBlobName reports whether name is a hex-encoded SHA-256, as blobs are named
+func isBlobName(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// BlobName returns the data file a file in the blob directory belongs to, for its data,
+// thumbnail and listing, as the hex-encoded SHA-256 and CompressedSuffix if it is compressed.
+// ok is false for unfinished writes and anything else.
+func BlobName(name string) (blob string, ok bool) {
+	for _, suffix := range sidecarSuffixes {
+		if blob, found := strings.CutSuffix(name, suffix); found {
+			name = blob
+			break
+		}
+	}
+	if isBlobName(strings.TrimSuffix(name, CompressedSuffix)) {
+		return name, true
+	}
+	return "", false
+}
+
+// blobRef counts the references on a blob: the stored files sharing it and the commits
+// about to add one
+type blobRef struct {
+	refs int
+	size int64 // bytes the blob takes on disk
+}
+
+// CommitBlob moves the finished upload at tmpPath, whose content f describes with its
+// SHA256, Encoding and StoredSize, into the blob directory and takes a reference on it for
+// f, which must be added with Add next. It sets the Path and Blob of f. When a stored file
+// already has the same content, tmpPath is deleted instead, f is pointed at the existing
+// blob, which may be stored with another encoding, and deduplicated is true.
+func (fs *FileStore) CommitBlob(f *StoredFile, tmpPath string) (deduplicated bool, err error) {
+	sum := f.SHA256
+	if !isBlobName(sum) {
+		return false, fmt.Errorf("invalid blob name %q", sum)
+	}
+
+	// The encoding of f first, then the other one
+	encodings := []string{f.Encoding, GzipEncoding}
+	if f.Encoding == GzipEncoding {
+		encodings[1] = ""
+	}
+	for _, encoding := range encodings {
+		path := BlobPath(fs.dir, sum, encoding)
+		if !fs.shareBlob(path) {
+			continue
+		}
+		// The reference keeps the blob on disk while it is checked, without holding the lock
+		info, err := os.Stat(path)
+		if err != nil {
+			fs.releaseBlob(path)
+			continue
+		}
+		if err := os.Remove(tmpPath); err != nil {
+			fs.releaseBlob(path)
+			return false, err
+		}
+		f.Path, f.Blob, f.Encoding = path, sum, encoding
+		f.StoredSize = 0
+		if encoding != "" {
+			f.StoredSize = info.Size()
+		}
+		return true, nil
+	}
+
+	// The reference is counted before the move, so that removing the last file with the same
+	// content cannot delete the blob once it is in place
+	path := BlobPath(fs.dir, sum, f.Encoding)
+	fs.mu.Lock()
+	fs.addBlobRef(path, f.DiskSize())
+	fs.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fs.releaseBlob(path)
+		return false, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		fs.releaseBlob(path)
+		return false, err
+	}
+	f.Path, f.Blob = path, sum
+	return false, nil
+}
+
+// shareBlob takes a reference on the blob at path if it is already referenced
+func (fs *FileStore) shareBlob(path string) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	b, ok := fs.blobs[path]
+	if !ok {
+		return false
+	}
+	b.refs++
+	fs.blobs[path] = b
+	return true
+}
+
+// releaseBlob drops a reference CommitBlob could not use, deleting the blob if it was the last.
+// The blob is deleted under the lock, like in remove, so that no commit can move new data to
+// its path in between.
+func (fs *FileStore) releaseBlob(path string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.dropBlobRef(path) {
+		if err := RemoveData(StoredFile{Path: path}); err != nil {
+			slog.Error("Error removing unused blob", "path", path, "error", err)
+		}
+	}
+}
+
+// addBlobRef takes a reference on the blob at path, counting size against the disk usage
+// when it is the first.
+// Must be called with fs.mu lock held
+func (fs *FileStore) addBlobRef(path string, size int64) {
+	b, ok := fs.blobs[path]
+	if !ok {
+		b.size = size
+		fs.diskBytes += size
+	}
+	b.refs++
+	fs.blobs[path] = b
+}
+
+// dropBlobRef releases a reference on the blob at path. It reports whether that was the last
+// one, in which case the blob is no longer counted and its data must be deleted.
+// Must be called with fs.mu lock held
+func (fs *FileStore) dropBlobRef(path string) (last bool) {
+	b, ok := fs.blobs[path]
+	if !ok {
+		return false
+	}
+	if b.refs--; b.refs > 0 {
+		fs.blobs[path] = b
+		return false
+	}
+	delete(fs.blobs, path)
+	fs.diskBytes -= b.size
+	return true
+}
+
+// FindBlob returns a stored file whose data is at path, to reuse what was derived from it
+func (fs *FileStore) FindBlob(path string) (StoredFile, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	for _, f := range fs.files {
+		if f.Path == path {
+			return f, true
+		}
+	}
+	return StoredFile{}, false
+}
+
+// Usage describes the files of an upload directory and the disk space their data takes
+type Usage struct {
+	Files        int   // stored files
+	Blobs        int   // distinct data files on disk
+	Bytes        int64 // size of the data on disk, counting shared content once
+	LogicalBytes int64 // size of all files, as if each had its own uncompressed copy
+	Deduplicated int64 // size of the files sharing the content of another
+}
+
+// Compressed returns the bytes compression at rest avoids storing
+func (u Usage) Compressed() int64 {
+	return u.LogicalBytes - u.Deduplicated - u.Bytes
+}
+
+// UsageOf returns the disk usage of files. Files sharing their content are stored once.
+func UsageOf(files []StoredFile) Usage {
+	u := Usage{Files: len(files)}
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		u.LogicalBytes += f.Size
+		if seen[f.Path] {
+			u.Deduplicated += f.Size
+			continue
+		}
+		seen[f.Path] = true
+		u.Blobs++
+		u.Bytes += f.DiskSize()
+	}
+	return u
+}
+
+// OrphanedFiles returns the data, thumbnail and listing files in dir and its blob directory
+// that no file of the index refers to, as paths relative to dir
+func OrphanedFiles(dir string, files []StoredFile) ([]string, error) {
+	known := make(map[string]bool, len(files))
+	for _, f := range files {
+		known[f.Path] = true
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var orphaned []string
+	for _, entry := range entries {
+		id, ok := UploadID(entry.Name())
+		if entry.IsDir() || !ok || known[filepath.Join(dir, id)] {
+			continue
+		}
+		orphaned = append(orphaned, entry.Name())
+	}
+
+	blobs, err := os.ReadDir(filepath.Join(dir, BlobDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range blobs {
+		blob, ok := BlobName(entry.Name())
+		if entry.IsDir() || !ok || known[filepath.Join(dir, BlobDir, blob)] {
+			continue
+		}
+		orphaned = append(orphaned, filepath.Join(BlobDir, entry.Name()))
+	}
+	return orphaned, nil
+}
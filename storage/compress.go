@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// GzipEncoding is the Encoding of files stored gzip-compressed
+const GzipEncoding = "gzip"
+
+// CompressedSuffix marks blobs stored gzip-compressed
+const CompressedSuffix = ".gz"
+
+const (
+	// MinCompressSize is the size below which compressing an upload is not worth it
+	MinCompressSize = 1024
+	// compressSampleSize is how much of an upload is compressed on trial to decide whether
+	// compressing all of it is worth it
+	compressSampleSize = 64 << 10
+	// minCompressionRatio is how much smaller, in percent, a file must get to be kept compressed
+	minCompressionRatio = 10
+)
+
+// compressibleTypes are the content types besides text/* that compress well
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"application/sql":        true,
+	"application/x-yaml":     true,
+	"image/svg+xml":          true,
+}
+
+// Compressible reports whether a file of the sniffed content type and size is worth storing
+// compressed. Images, video, archives and other binary formats are usually compressed already.
+func Compressible(contentType string, size int64) bool {
+	if size < MinCompressSize {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// Compressor writes an upload to w, gzip-compressing it on the way if worth reports from its
+// first bytes that it is of a compressible type and a trial compression of those bytes saves
+// enough. The decision is made before anything is written, so the upload is written once, either
+// way. Uploads smaller than MinCompressSize are written as they are.
+type Compressor struct {
+	w       io.Writer
+	worth   func(head []byte) bool
+	head    []byte
+	decided bool
+	gz      *gzip.Writer // nil unless the upload is being compressed
+}
+
+// NewCompressor creates a Compressor writing to w
+func NewCompressor(w io.Writer, worth func(head []byte) bool) *Compressor {
+	return &Compressor{w: w, worth: worth}
+}
+
+// Write implements io.Writer
+func (c *Compressor) Write(p []byte) (int, error) {
+	if c.decided {
+		return c.write(p)
+	}
+	n := min(compressSampleSize-len(c.head), len(p))
+	c.head = append(c.head, p[:n]...)
+	if len(c.head) < compressSampleSize {
+		return n, nil
+	}
+	if err := c.decide(c.shouldCompress()); err != nil {
+		return 0, err
+	}
+	written, err := c.write(p[n:])
+	return n + written, err
+}
+
+// shouldCompress reports whether the buffered head is of a compressible type and shrinks enough
+// when compressed on trial
+func (c *Compressor) shouldCompress() bool {
+	if len(c.head) < MinCompressSize || !c.worth(c.head) {
+		return false
+	}
+	var trial bytes.Buffer
+	gz := gzip.NewWriter(&trial)
+	if _, err := gz.Write(c.head); err != nil || gz.Close() != nil {
+		return false
+	}
+	return WorthCompressing(int64(len(c.head)), int64(trial.Len()))
+}
+
+// decide writes out the buffered head, compressing it and the rest of the upload if compress is set
+func (c *Compressor) decide(compress bool) error {
+	c.decided = true
+	if compress {
+		c.gz = gzip.NewWriter(c.w)
+	}
+	head := c.head
+	c.head = nil
+	_, err := c.write(head)
+	return err
+}
+
+func (c *Compressor) write(p []byte) (int, error) {
+	if c.gz != nil {
+		return c.gz.Write(p)
+	}
+	return c.w.Write(p)
+}
+
+// Close writes out what is still buffered. It does not close the underlying writer.
+func (c *Compressor) Close() error {
+	if !c.decided {
+		if err := c.decide(c.shouldCompress()); err != nil {
+			return err
+		}
+	}
+	if c.gz != nil {
+		return c.gz.Close()
+	}
+	return nil
+}
+
+// Compressed reports whether the upload is being written gzip-compressed
+func (c *Compressor) Compressed() bool {
+	return c.gz != nil
+}
+
+// WorthCompressing reports whether a file of the given size that compressed to
+// compressedSize saves enough to be kept compressed
+func WorthCompressing(size, compressedSize int64) bool {
+	return compressedSize <= size*(100-minCompressionRatio)/100
+}
+
+// OpenData opens the content of a stored file, decompressing it if it is stored compressed.
+// Seeking a compressed file forward reads and discards the data in between, and seeking
+// backwards starts over from the beginning.
+func OpenData(f StoredFile) (io.ReadSeekCloser, error) {
+	if f.Encoding != GzipEncoding {
+		return os.Open(f.Path)
+	}
+	return &gzipFile{path: f.Path, size: f.Size}, nil
+}
+
+// gzipFile is a seekable view of the decompressed content of a gzip file
+type gzipFile struct {
+	path string
+	size int64 // decompressed size, so that seeking to the end needs no decompression
+	file *os.File
+	gz   *gzip.Reader
+	pos  int64 // position of gz in the decompressed data
+	off  int64 // position requested by Seek
+}
+
+// Read implements io.Reader
+func (g *gzipFile) Read(p []byte) (int, error) {
+	if g.gz == nil || g.off < g.pos {
+		if err := g.rewind(); err != nil {
+			return 0, err
+		}
+	}
+	if g.off > g.pos {
+		n, err := io.CopyN(io.Discard, g.gz, g.off-g.pos)
+		g.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := g.gz.Read(p)
+	g.pos += int64(n)
+	g.off = g.pos
+	return n, err
+}
+
+// rewind starts decompressing from the beginning of the file
+func (g *gzipFile) rewind() error {
+	if g.file == nil {
+		file, err := os.Open(g.path)
+		if err != nil {
+			return err
+		}
+		g.file = file
+	} else if _, err := g.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(g.file)
+	if g.gz == nil {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		g.gz = gz
+	} else if err := g.gz.Reset(br); err != nil {
+		return err
+	}
+	g.pos = 0
+	return nil
+}
+
+// Seek implements io.Seeker
+func (g *gzipFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += g.off
+	case io.SeekEnd:
+		offset += g.size
+	default:
+		return 0, errors.New("gzipFile.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("gzipFile.Seek: negative position")
+	}
+	g.off = offset
+	return offset, nil
+}
+
+// Close implements io.Closer
+func (g *gzipFile) Close() error {
+	if g.file == nil {
+		return nil
+	}
+	return g.file.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package storage
+
+// DiskSpace fails with ErrDiskSpaceUnsupported on platforms where the free space of a volume
+// is not queried: the free space watermark is not enforced there
+func DiskSpace(dir string) (free, total int64, err error) {
+	return 0, 0, ErrDiskSpaceUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package storage
+
+import "syscall"
+
+// DiskSpace returns the space available to the server and the size of the volume holding dir
+func DiskSpace(dir string) (free, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	// Field types differ between platforms
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// DiskSpace returns the space available to the server and the size of the volume holding dir
+func DiskSpace(dir string) (free, total int64, err error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var available, size, totalFree uint64
+	ok, _, callErr := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&available)), uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&totalFree)))
+	if ok == 0 {
+		return 0, 0, callErr
+	}
+	return int64(available), int64(size), nil
+}
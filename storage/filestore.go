@@ -4,54 +4,363 @@ package storage
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"go-quick-cli-upload-server/metrics"
+
 	"github.com/gorilla/websocket"
 )
 
-// FileStore manages uploaded files and their associated WebSocket connections
+// FileStore manages uploaded files and their associated WebSocket connections. Metadata
+// changes are saved to the index in the upload directory.
 type FileStore struct {
 	mu        sync.RWMutex
+	dir       string
 	files     map[string]StoredFile
+	timers    map[string]*time.Timer
 	wsClients map[string][]*websocket.Conn
+	onRemove  []RemoveHook
+	blobs     map[string]blobRef      // references per data path, so shared blobs outlive all but the last file
+	removed   map[string]DeleteReason // why recently removed files left the store, see RemovedReason
+
+	// Storage accounting for quotas, see Reserve
+	diskBytes     int64            // size on disk of the data of all stored files
+	ownerBytes    map[string]int64 // size of the stored files of each quota owner
+	pending       int64            // bytes written by uploads in progress
+	pendingOwners map[string]int64 // bytes written by uploads in progress per quota owner
+
+	// Index saving, see persist
+	dirty     bool          // the index is behind the files in memory
+	saveIndex chan struct{} // holds a token while the saver has work to do
+	saveMu    sync.Mutex    // serialises the saver and Flush
 }
 
+// removedRetention is how long the reason a file was removed is remembered, so that watchers
+// connecting late still learn what happened to it
+const removedRetention = time.Hour
+
+// maxDownloadRecords is how many download attempts are kept in the history of a file; older
+// ones are only counted, as the audit log keeps them all
+const maxDownloadRecords = 100
+
 // StoredFile contains metadata about an uploaded file
 type StoredFile struct {
-	Path         string
-	OriginalName string
-	UploadTime   time.Time
+	ID                 string           `json:"id"`
+	Path               string           `json:"-"`
+	OriginalName       string           `json:"name"`
+	Size               int64            `json:"size"`
+	SHA256             string           `json:"sha256"`
+	Blob               string           `json:"blob,omitempty"`       // content the file is stored as, see BlobPath
+	Encoding           string           `json:"encoding,omitempty"`   // GzipEncoding when stored compressed
+	StoredSize         int64            `json:"storedSize,omitempty"` // size on disk when stored compressed
+	UploaderIP         string           `json:"uploaderIP"`
+	UploaderAgent      string           `json:"uploaderAgent"`
+	RequestID          string           `json:"requestID,omitempty"` // upload request the file was sent through
+	UploadTime         time.Time        `json:"uploadTime"`
+	ExpiresAt          time.Time        `json:"expiresAt"`
+	RemainingDownloads int              `json:"remainingDownloads"`
+	ContentType        string           `json:"contentType,omitempty"`     // sniffed at upload
+	Language           string           `json:"language,omitempty"`        // set for text pastes
+	Image              *ImageInfo       `json:"image,omitempty"`           // set for decodable images
+	MetadataRemoved    int64            `json:"metadataRemoved,omitempty"` // bytes of EXIF and other metadata stripped on upload
+	Archive            *ArchiveInfo     `json:"archive,omitempty"`         // set for listable archives
+	Downloads          []DownloadRecord `json:"downloads,omitempty"`
+	EarlierDownloads   int              `json:"earlierDownloads,omitempty"` // attempts dropped from Downloads
+	// PartialBytes is what incomplete downloads served since the last complete one, see
+	// RecordPartialDownload
+	PartialBytes int64 `json:"partialBytes,omitempty"`
+}
+
+// ImageInfo describes an uploaded image
+type ImageInfo struct {
+	Format    string `json:"format"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Thumbnail bool   `json:"thumbnail"` // a thumbnail is stored at ThumbnailPath
+}
+
+// DiskSize returns the number of bytes the data of the file takes on disk
+func (f StoredFile) DiskSize() int64 {
+	if f.Encoding != "" {
+		return f.StoredSize
+	}
+	return f.Size
+}
+
+// ThumbnailPath returns where the thumbnail of the file is stored
+func (f StoredFile) ThumbnailPath() string {
+	return f.Path + ThumbnailSuffix
+}
+
+// HasThumbnail reports whether a thumbnail was generated for the file
+func (f StoredFile) HasThumbnail() bool {
+	return f.Image != nil && f.Image.Thumbnail
+}
+
+// ArchiveInfo summarises the contents of an uploaded archive; the entries are stored at
+// ListingPath
+type ArchiveInfo struct {
+	Format    string `json:"format"`    // zip, tar, tar.gz or tar.zst
+	Count     int    `json:"count"`     // entries found, which may be more than were listed
+	TotalSize int64  `json:"totalSize"` // uncompressed size of the entries found
+	Truncated bool   `json:"truncated"` // scanning stopped at a limit or damage: there may be more entries
+}
+
+// ArchiveEntry is a file or directory in an archive
+type ArchiveEntry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Dir      bool      `json:"dir,omitempty"`
+}
+
+// ListingPath returns where the entries of an archive are stored
+func (f StoredFile) ListingPath() string {
+	return f.Path + ListingSuffix
+}
+
+// WriteListing stores the entries of an archive next to it. The listing is written to a
+// temporary file first, as uploads sharing the archive may be writing it at the same time.
+func WriteListing(f StoredFile, entries []ArchiveEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	path := f.ListingPath()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+IncompleteSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadListing returns the entries of an archive stored by WriteListing
+func ReadListing(f StoredFile) ([]ArchiveEntry, error) {
+	data, err := os.ReadFile(f.ListingPath())
+	if err != nil {
+		return nil, err
+	}
+	var entries []ArchiveEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RemoveData deletes the data of a file and the thumbnail or listing stored next to it from
+// disk; files already gone are not an error
+func RemoveData(f StoredFile) error {
+	for _, path := range []string{f.Path, f.ThumbnailPath(), f.ListingPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
-// NewFileStore creates a new FileStore instance
-func NewFileStore() *FileStore {
-	return &FileStore{
+// DownloadRecord describes a single download attempt of a stored file
+type DownloadRecord struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"clientIP"`
+	UserAgent string    `json:"userAgent"`
+	Success   bool      `json:"success"`
+}
+
+// DeleteReason explains why a file left the store; it is sent to WebSocket watchers as the event name
+type DeleteReason string
+
+const (
+	// ReasonDownloaded is used when a file was consumed by a download
+	ReasonDownloaded DeleteReason = "downloaded"
+	// ReasonExpired is used when a file reached its expiry time
+	ReasonExpired DeleteReason = "expired"
+	// ReasonRevoked is used when a file was force-deleted by an administrator
+	ReasonRevoked DeleteReason = "deleted"
+	// ReasonAborted is used when a relayed transfer failed part-way
+	ReasonAborted DeleteReason = "aborted"
+	// ReasonEvicted is used when a file was deleted early to make room for an upload
+	ReasonEvicted DeleteReason = "evicted"
+)
+
+// RemoveHook is called after a file has been removed from the store
+type RemoveHook func(f StoredFile, reason DeleteReason)
+
+// NewFileStore creates a new FileStore keeping its metadata index in dir. Flush must be
+// called before exiting to save the last changes.
+func NewFileStore(dir string) *FileStore {
+	fs := &FileStore{
+		dir:       dir,
 		files:     make(map[string]StoredFile),
+		timers:    make(map[string]*time.Timer),
 		wsClients: make(map[string][]*websocket.Conn),
+		blobs:     make(map[string]blobRef),
+		removed:   make(map[string]DeleteReason),
+
+		ownerBytes:    make(map[string]int64),
+		pendingOwners: make(map[string]int64),
+
+		saveIndex: make(chan struct{}, 1),
 	}
+	go fs.saveLoop()
+	return fs
 }
 
-// Add stores a new file in the FileStore and schedules its automatic deletion
-func (fs *FileStore) Add(id, path, originalName string, expiryMinutes int) {
+// Restore loads the files listed in the metadata index and schedules their expiry. Entries
+// whose file is gone are dropped; files that expired while the server was down are deleted
+// right away through the usual expiry path.
+func (fs *FileStore) Restore() (int, error) {
+	files, err := ReadIndex(fs.dir)
+	if err != nil {
+		return 0, err
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	fs.files[id] = StoredFile{
-		Path:         path,
-		OriginalName: originalName,
-		UploadTime:   time.Now(),
+	for _, f := range files {
+		if _, err := os.Stat(f.Path); err != nil {
+			slog.Warn("Dropping index entry of missing file", "file_id", f.ID, "error", err)
+			continue
+		}
+		id := f.ID
+		fs.files[id] = f
+		fs.addBlobRef(f.Path, f.DiskSize())
+		fs.ownerBytes[f.QuotaOwner()] += f.Size
+		fs.timers[id] = time.AfterFunc(time.Until(f.ExpiresAt), func() {
+			fs.expire(id)
+		})
+	}
+	fs.persist()
+	return len(fs.files), nil
+}
+
+// persist schedules a save of the metadata of all files to the index. The index is written
+// in the background without holding fs.mu, and changes made while it is being written are
+// saved together by the next write.
+// Must be called with fs.mu lock held
+func (fs *FileStore) persist() {
+	fs.dirty = true
+	select {
+	case fs.saveIndex <- struct{}{}:
+	default:
+		// A save is already due and will include this change
 	}
+}
 
-	// Schedule automatic deletion after expiry duration
+// saveLoop writes the index whenever persist asks for it
+func (fs *FileStore) saveLoop() {
+	for range fs.saveIndex {
+		fs.Flush()
+	}
+}
+
+// Flush writes the index now if it is behind the files in memory
+func (fs *FileStore) Flush() {
+	fs.saveMu.Lock()
+	defer fs.saveMu.Unlock()
+
+	fs.mu.Lock()
+	if !fs.dirty {
+		fs.mu.Unlock()
+		return
+	}
+	fs.dirty = false
+	files := make([]StoredFile, 0, len(fs.files))
+	for _, f := range fs.files {
+		f.Downloads = append([]DownloadRecord(nil), f.Downloads...)
+		files = append(files, f)
+	}
+	fs.mu.Unlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].UploadTime.Before(files[j].UploadTime)
+	})
+	if err := WriteIndex(fs.dir, files); err != nil {
+		slog.Error("Error writing metadata index", "dir", fs.dir, "error", err)
+	}
+}
+
+// OnRemove registers a hook called after every removal, whatever the reason
+func (fs *FileStore) OnRemove(hook RemoveHook) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.onRemove = append(fs.onRemove, hook)
+}
+
+// Add stores a new file in the FileStore, schedules its automatic deletion and returns its metadata.
+// The ID, upload time and expiry of f are filled in by the store.
+func (fs *FileStore) Add(id string, f StoredFile, expiryMinutes int) StoredFile {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := time.Now()
 	expiryDuration := time.Duration(expiryMinutes) * time.Minute
-	time.AfterFunc(expiryDuration, func() {
-		fs.Delete(id)
+	f.ID = id
+	f.UploadTime = now
+	f.ExpiresAt = now.Add(expiryDuration)
+	fs.files[id] = f
+	fs.ownerBytes[f.QuotaOwner()] += f.Size
+
+	// Schedule automatic deletion after expiry duration
+	fs.timers[id] = time.AfterFunc(expiryDuration, func() {
+		fs.expire(id)
+	})
+	fs.persist()
+
+	return f
+}
+
+// SetExpiry moves the expiry of a stored file to expiresAt and reschedules its deletion.
+// It returns the updated metadata, or false if the file no longer exists.
+func (fs *FileStore) SetExpiry(id string, expiresAt time.Time) (StoredFile, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, exists := fs.files[id]
+	if !exists {
+		return StoredFile{}, false
+	}
+
+	if t, ok := fs.timers[id]; ok {
+		t.Stop()
+	}
+	f.ExpiresAt = expiresAt
+	fs.files[id] = f
+	fs.timers[id] = time.AfterFunc(time.Until(expiresAt), func() {
+		fs.expire(id)
 	})
+	fs.persist()
+
+	return f, true
+}
+
+// expire deletes a file once its expiry time has passed. A timer that fired
+// concurrently with SetExpiry finds the new expiry in the future and does nothing.
+func (fs *FileStore) expire(id string) {
+	f, exists := fs.Get(id)
+	if !exists || time.Now().Before(f.ExpiresAt) {
+		return
+	}
+	if fs.remove(id, ReasonExpired) {
+		metrics.ExpirationsTotal.Inc()
+		slog.Info("File expired and deleted", "file_id", id)
+	}
 }
 
 // Get retrieves file metadata by ID
@@ -62,25 +371,157 @@ func (fs *FileStore) Get(id string) (StoredFile, bool) {
 	return f, exists
 }
 
-// Delete removes a file from storage and notifies all connected WebSocket clients
-func (fs *FileStore) Delete(id string) {
+// RemovedReason returns why a file that is no longer stored was removed, if that happened
+// within removedRetention
+func (fs *FileStore) RemovedReason(id string) (DeleteReason, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	reason, ok := fs.removed[id]
+	return reason, ok
+}
+
+// List returns the metadata of all stored files, oldest upload first
+func (fs *FileStore) List() []StoredFile {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	files := make([]StoredFile, 0, len(fs.files))
+	for _, f := range fs.files {
+		f.Downloads = append([]DownloadRecord(nil), f.Downloads...)
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].UploadTime.Before(files[j].UploadTime)
+	})
+	return files
+}
+
+// Stats returns the number of stored files and the disk space they take
+func (fs *FileStore) Stats() Usage {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	files := make([]StoredFile, 0, len(fs.files))
+	for _, f := range fs.files {
+		files = append(files, f)
+	}
+	return UsageOf(files)
+}
+
+// RecordDownload appends a download attempt to the file's history and returns how many
+// downloads the file still allows
+func (fs *FileStore) RecordDownload(id string, record DownloadRecord) int {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	f, exists := fs.files[id]
 	if !exists {
-		return
+		return 0
+	}
+	f.recordDownload(record)
+	if record.Success {
+		f.PartialBytes = 0
+		if f.RemainingDownloads > 0 {
+			f.RemainingDownloads--
+		}
+	}
+	fs.files[id] = f
+	fs.persist()
+	return f.RemainingDownloads
+}
+
+// RecordPartialDownload records a download attempt that served bytes of the file without
+// completing it: a range stopping before the end or an interrupted transfer. Once such
+// attempts add up to the size of the file, they count as a download and complete is true;
+// the attempt is then not recorded, and the caller records it with RecordDownload as a
+// successful download.
+func (fs *FileStore) RecordPartialDownload(id string, record DownloadRecord, bytes int64) (complete bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, exists := fs.files[id]
+	if !exists {
+		return false
+	}
+	f.PartialBytes += bytes
+	if f.PartialBytes >= f.Size {
+		f.PartialBytes = 0
+		fs.files[id] = f
+		return true
+	}
+	f.recordDownload(record)
+	fs.files[id] = f
+	fs.persist()
+	return false
+}
+
+// recordDownload appends a download attempt to the history, dropping the oldest attempt once
+// the history holds maxDownloadRecords
+func (f *StoredFile) recordDownload(record DownloadRecord) {
+	if len(f.Downloads) >= maxDownloadRecords {
+		dropped := len(f.Downloads) - maxDownloadRecords + 1
+		f.Downloads = append([]DownloadRecord(nil), f.Downloads[dropped:]...)
+		f.EarlierDownloads += dropped
+	}
+	f.Downloads = append(f.Downloads, record)
+}
+
+// Delete removes a downloaded file from storage and notifies all connected WebSocket clients
+func (fs *FileStore) Delete(id string) {
+	fs.remove(id, ReasonDownloaded)
+}
+
+// Revoke force-deletes a file before it was downloaded or expired
+func (fs *FileStore) Revoke(id string) bool {
+	return fs.remove(id, ReasonRevoked)
+}
+
+// remove deletes a file from memory, and from disk unless other files share its content, and
+// notifies its WebSocket clients of the reason. It returns false if the file was not in the store.
+func (fs *FileStore) remove(id string, reason DeleteReason) bool {
+	fs.mu.Lock()
+
+	f, exists := fs.files[id]
+	if !exists {
+		fs.mu.Unlock()
+		return false
+	}
+
+	if fs.dropBlobRef(f.Path) {
+		if err := RemoveData(f); err != nil {
+			slog.Error("Error removing file", "file_id", id, "path", f.Path, "error", err)
+		}
 	}
 
-	if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
-		log.Printf("Error removing file %s: %v", f.Path, err)
+	owner := f.QuotaOwner()
+	if fs.ownerBytes[owner] -= f.Size; fs.ownerBytes[owner] <= 0 {
+		delete(fs.ownerBytes, owner)
 	}
 
 	delete(fs.files, id)
+	if t, ok := fs.timers[id]; ok {
+		t.Stop()
+		delete(fs.timers, id)
+	}
+	fs.persist()
 
-	fs.notifyClients(id)
+	fs.removed[id] = reason
+	time.AfterFunc(removedRetention, func() {
+		fs.mu.Lock()
+		delete(fs.removed, id)
+		fs.mu.Unlock()
+	})
+	fs.notifyClients(id, reason)
 
 	delete(fs.wsClients, id)
+	hooks := fs.onRemove
+	fs.mu.Unlock()
+
+	// Hooks run without the lock so they may safely call back into the store
+	for _, hook := range hooks {
+		hook(f, reason)
+	}
+	return true
 }
 
 // AddWSClient registers a new WebSocket client for download notifications
@@ -105,21 +546,61 @@ func (fs *FileStore) RemoveWSClient(fileID string, conn *websocket.Conn) {
 	}
 }
 
-// notifyClients sends download notification to all WebSocket clients for a file
+// notifyClients sends the removal notification to all WebSocket clients for a file
 // Must be called with fs.mu lock held
-func (fs *FileStore) notifyClients(fileID string) {
+func (fs *FileStore) notifyClients(fileID string, reason DeleteReason) {
 	for _, conn := range fs.wsClients[fileID] {
 		err := conn.WriteJSON(map[string]interface{}{
-			"downloaded": true,
+			"downloaded": reason == ReasonDownloaded,
+			"event":      reason,
 			"fileID":     fileID,
 		})
 		if err != nil {
-			log.Printf("Error sending WebSocket message: %v", err)
+			slog.Warn("Error sending WebSocket message", "file_id", fileID, "error", err)
 		}
 		conn.Close()
 	}
 }
 
+// CloseWatchers notifies the WebSocket clients of an ID that is not a stored file, such as
+// a relay, that it is gone, and disconnects them
+func (fs *FileStore) CloseWatchers(fileID string, reason DeleteReason) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.notifyClients(fileID, reason)
+	delete(fs.wsClients, fileID)
+}
+
+// CloseWSClients sends a close frame with the given reason to every WebSocket client and closes them
+func (fs *FileStore) CloseWSClients(reason string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+	deadline := time.Now().Add(time.Second)
+	for fileID, clients := range fs.wsClients {
+		for _, conn := range clients {
+			if err := conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+				slog.Warn("Error sending WebSocket close message", "file_id", fileID, "error", err)
+			}
+			conn.Close()
+		}
+		delete(fs.wsClients, fileID)
+	}
+}
+
+// StopExpiryTimers cancels all scheduled deletions, so that no file is removed while the server shuts down
+func (fs *FileStore) StopExpiryTimers() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for id, t := range fs.timers {
+		t.Stop()
+		delete(fs.timers, id)
+	}
+}
+
 // BroadcastMessage sends a custom message to all WebSocket clients for a file
 func (fs *FileStore) BroadcastMessage(fileID string, message interface{}) {
 	fs.mu.RLock()
@@ -127,7 +608,7 @@ func (fs *FileStore) BroadcastMessage(fileID string, message interface{}) {
 
 	for _, conn := range fs.wsClients[fileID] {
 		if err := conn.WriteJSON(message); err != nil {
-			log.Printf("Error broadcasting message to WebSocket client: %v", err)
+			slog.Warn("Error broadcasting message to WebSocket client", "file_id", fileID, "error", err)
 		}
 	}
 }
@@ -141,11 +622,54 @@ func GenerateID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// IncompleteSuffix marks files that are still being written by an upload
+const IncompleteSuffix = ".part"
+
+// ThumbnailSuffix marks the thumbnail stored next to an uploaded image
+const ThumbnailSuffix = ".thumb"
+
+// ListingSuffix marks the list of entries stored next to an uploaded archive
+const ListingSuffix = ".list"
+
+// sidecarSuffixes mark files derived from an upload and stored next to it
+var sidecarSuffixes = []string{ThumbnailSuffix, ListingSuffix}
+
+// RemoveIncompleteFiles deletes leftovers of uploads that never finished
+func RemoveIncompleteFiles(uploadDir string) error {
+	for _, dir := range []string{uploadDir, filepath.Join(uploadDir, BlobDir)} {
+		files, err := os.ReadDir(dir)
+		if dir != uploadDir && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading uploads directory: %w", err)
+		}
+
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), IncompleteSuffix) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil && !os.IsNotExist(err) {
+				slog.Error("Error removing incomplete upload", "file", file.Name(), "error", err)
+				continue
+			}
+			slog.Info("Removed incomplete upload", "file", file.Name())
+		}
+	}
+	return nil
+}
+
 // CleanupOldFiles removes files older than the specified duration from the upload directory
+// and its blob directory. Files referred to by the metadata index are left alone: their
+// expiry is handled by Restore.
 func CleanupOldFiles(uploadDir string, expiryMinutes int) error {
-	log.Printf("Starting cleanup of files older than %d minutes...", expiryMinutes)
+	slog.Info("Starting cleanup of old files", "older_than_minutes", expiryMinutes)
 
-	files, err := os.ReadDir(uploadDir)
+	indexed, err := ReadIndex(uploadDir)
+	if err != nil {
+		return err
+	}
+	orphaned, err := OrphanedFiles(uploadDir, indexed)
 	if err != nil {
 		return fmt.Errorf("error reading uploads directory: %w", err)
 	}
@@ -154,29 +678,25 @@ func CleanupOldFiles(uploadDir string, expiryMinutes int) error {
 	now := time.Now()
 	cleanedCount := 0
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		filePath := filepath.Join(uploadDir, file.Name())
-		info, err := file.Info()
+	for _, name := range orphaned {
+		filePath := filepath.Join(uploadDir, name)
+		info, err := os.Stat(filePath)
 		if err != nil {
-			log.Printf("Error getting file info for %s: %v", file.Name(), err)
+			slog.Warn("Error getting file info", "file", name, "error", err)
 			continue
 		}
 
 		fileAge := now.Sub(info.ModTime())
 		if fileAge > expiryDuration {
 			if err := os.Remove(filePath); err != nil {
-				log.Printf("Error removing old file %s: %v", file.Name(), err)
+				slog.Error("Error removing old file", "file", name, "error", err)
 			} else {
-				log.Printf("Removed old file: %s (age: %v)", file.Name(), fileAge)
+				slog.Info("Removed old file", "file", name, "age", fileAge)
 				cleanedCount++
 			}
 		}
 	}
 
-	log.Printf("Cleanup completed. Removed %d old files.", cleanedCount)
+	slog.Info("Cleanup completed", "removed", cleanedCount)
 	return nil
 }
@@ -0,0 +1,23 @@
+package storage
+
+import "testing"
+
+func TestRemovedReason(t *testing.T) {
+	fs := newTestStore(t)
+	addFile(t, fs, "a", "10.0.0.1", "a", 10)
+	addFile(t, fs, "b", "10.0.0.1", "b", 10)
+
+	if _, ok := fs.RemovedReason("a"); ok {
+		t.Errorf("RemovedReason of a stored file is set")
+	}
+	fs.Delete("a")
+	fs.Revoke("b")
+	for id, want := range map[string]DeleteReason{"a": ReasonDownloaded, "b": ReasonRevoked} {
+		if reason, ok := fs.RemovedReason(id); !ok || reason != want {
+			t.Errorf("RemovedReason(%s) = %q, %v, want %q", id, reason, ok, want)
+		}
+	}
+	if _, ok := fs.RemovedReason("unknown"); ok {
+		t.Errorf("RemovedReason of an unknown ID is set")
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IndexFileName is the metadata index kept in the upload directory, so that stored files
+// survive a restart and can be inspected while the server is down
+const IndexFileName = ".qcus-index.json"
+
+// LockFileName is locked by the process that owns the upload directory
+const LockFileName = ".qcus.lock"
+
+// indexVersion is bumped when the index format changes incompatibly
+const indexVersion = 1
+
+// ErrLocked is returned by LockDir when another process owns the upload directory
+var ErrLocked = errors.New("upload directory is in use by another process")
+
+// index is the on-disk form of the metadata index
+type index struct {
+	Version int          `json:"version"`
+	Files   []StoredFile `json:"files"`
+}
+
+// ReadIndex returns the files listed in the metadata index of dir, oldest upload first.
+// A missing index is not an error: it is created by the first upload.
+func ReadIndex(dir string) ([]StoredFile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, IndexFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("corrupt metadata index: %w", err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("unsupported metadata index version %d", idx.Version)
+	}
+	for i, f := range idx.Files {
+		if f.Blob != "" {
+			idx.Files[i].Path = BlobPath(dir, f.Blob, f.Encoding)
+		} else {
+			// Stored before uploads were deduplicated
+			idx.Files[i].Path = filepath.Join(dir, f.ID)
+		}
+	}
+	return idx.Files, nil
+}
+
+// WriteIndex replaces the metadata index of dir. The index is written to a temporary file
+// first, so readers never see a partial index.
+func WriteIndex(dir string, files []StoredFile) error {
+	if files == nil {
+		files = []StoredFile{}
+	}
+	data, err := json.MarshalIndent(index{Version: indexVersion, Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, IndexFileName)
+	tmp := path + IncompleteSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// IsDataFile reports whether a file name in the upload directory holds uploaded data, as
+// opposed to the index, the lock, a thumbnail or listing, or an unfinished upload
+func IsDataFile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, IncompleteSuffix) {
+		return false
+	}
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// UploadID returns the ID of the upload a file in the upload directory belongs to, for its
+// data, thumbnail and listing. ok is false for the index, the lock and unfinished uploads.
+func UploadID(name string) (id string, ok bool) {
+	if IsDataFile(name) {
+		return name, true
+	}
+	for _, suffix := range sidecarSuffixes {
+		if id, found := strings.CutSuffix(name, suffix); found && IsDataFile(id) {
+			return id, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package storage
+
+// LockDir is a no-op on platforms without flock: nothing prevents the admin commands from
+// modifying the upload directory of a running server there
+func LockDir(dir string) (unlock func(), err error) {
+	return func() {}, nil
+}
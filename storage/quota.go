@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"go-quick-cli-upload-server/metrics"
+)
+
+// ErrInsufficientStorage is wrapped by the errors of uploads that do not fit in the storage
+// quotas or the free space of the upload volume
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// ErrDiskSpaceUnsupported is returned by DiskSpace on platforms where it is not available
+var ErrDiskSpaceUnsupported = errors.New("disk space queries are not supported on this platform")
+
+// freeSpaceCheckInterval is how many bytes an upload writes between checks of the free space
+const freeSpaceCheckInterval = 1 << 20
+
+// Limits are the storage quotas and free space watermark uploads must fit in; zero disables each
+type Limits struct {
+	Quota      int64 // disk space all stored files may take
+	OwnerQuota int64 // size of the stored files per quota owner before compression, see QuotaOwner
+	MinFree    int64 // free space to keep on the volume of the upload directory
+	// Evict deletes the files expiring soonest to make room when the quota or the free space
+	// runs out. Owner quotas are never made room for.
+	Evict bool
+}
+
+// QuotaOwner returns who an upload counts against for owner quotas: the upload request it
+// was sent through, or else the client IP. Uploaders have no tokens of their own to key on,
+// as they all share the upload password.
+func QuotaOwner(requestID, clientIP string) string {
+	if requestID != "" {
+		return "request:" + requestID
+	}
+	return "ip:" + clientIP
+}
+
+// QuotaOwner returns who the file counts against for owner quotas
+func (f StoredFile) QuotaOwner() string {
+	return QuotaOwner(f.RequestID, f.UploaderIP)
+}
+
+// Reservation counts the bytes an upload in progress writes against the storage limits, so
+// that concurrent uploads cannot overrun them together
+type Reservation struct {
+	fs         *FileStore
+	owner      string
+	limits     Limits
+	bytes      int64 // written to disk so far
+	ownerBytes int64 // of the file so far, before compression
+	checked    int64 // bytes written at the last free space check
+	released   bool
+}
+
+// Reserve checks that an upload of size bytes, or of unknown size if zero, by owner fits in
+// limits, evicting files if allowed, and starts counting its bytes. Release must be called
+// once the upload was added to the store or abandoned.
+func (fs *FileStore) Reserve(owner string, size int64, limits Limits) (*Reservation, error) {
+	r := &Reservation{fs: fs, owner: owner, limits: limits}
+	if err := r.fit(size, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Grow counts n more bytes written to disk by the upload. It fails with ErrInsufficientStorage
+// once the upload no longer fits in the limits.
+func (r *Reservation) Grow(n int64) error {
+	fs := r.fs
+	fs.mu.Lock()
+	r.bytes += n
+	fs.pending += n
+	fs.mu.Unlock()
+
+	checkDisk := r.bytes-r.checked >= freeSpaceCheckInterval
+	if checkDisk {
+		r.checked = r.bytes
+	}
+	return r.fit(0, checkDisk)
+}
+
+// GrowOwner counts n more bytes of the uploaded file against the owner quota. Stored files
+// count there at their full size, so these are counted before compression, unlike Grow.
+func (r *Reservation) GrowOwner(n int64) error {
+	fs := r.fs
+	fs.mu.Lock()
+	r.ownerBytes += n
+	fs.pendingOwners[r.owner] += n
+	fs.mu.Unlock()
+	return r.fit(0, false)
+}
+
+// Release stops counting the bytes of the upload, which are now counted as a stored file or
+// were deleted
+func (r *Reservation) Release() {
+	fs := r.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if r.released {
+		return
+	}
+	r.released = true
+	fs.pending -= r.bytes
+	if fs.pendingOwners[r.owner] -= r.ownerBytes; fs.pendingOwners[r.owner] <= 0 {
+		delete(fs.pendingOwners, r.owner)
+	}
+}
+
+// fit checks that size more bytes fit in the limits, evicting files to make room if allowed
+func (r *Reservation) fit(size int64, checkDisk bool) error {
+	for {
+		reclaimable, err := r.check(size, checkDisk)
+		if err == nil || !r.limits.Evict || !reclaimable {
+			return err
+		}
+		if !r.fs.evictOne() {
+			return err
+		}
+	}
+}
+
+// check tests the limits once. reclaimable reports whether evicting files could help.
+func (r *Reservation) check(size int64, checkDisk bool) (reclaimable bool, err error) {
+	fs := r.fs
+	fs.mu.RLock()
+	used, pending := fs.diskBytes, fs.pending
+	ownerUsed := fs.ownerBytes[r.owner] + fs.pendingOwners[r.owner]
+	fs.mu.RUnlock()
+
+	if q := r.limits.OwnerQuota; q > 0 && ownerUsed+size > q {
+		return false, fmt.Errorf("%w: the upload would exceed your storage quota of %d MB (%.1f MB in use)",
+			ErrInsufficientStorage, q>>20, float64(ownerUsed)/(1<<20))
+	}
+	if q := r.limits.Quota; q > 0 && used+pending+size > q {
+		return pending+size <= q && used > 0, fmt.Errorf("%w: the upload would exceed the server's storage quota of %d MB",
+			ErrInsufficientStorage, q>>20)
+	}
+	if !checkDisk || r.limits.MinFree <= 0 {
+		return false, nil
+	}
+	free, _, diskErr := DiskSpace(fs.dir)
+	if diskErr != nil {
+		// Without the free space, uploads are only stopped by the volume filling up
+		return false, nil
+	}
+	if free-size < r.limits.MinFree {
+		return free+used-size >= r.limits.MinFree,
+			fmt.Errorf("%w: the server is running out of disk space", ErrInsufficientStorage)
+	}
+	return false, nil
+}
+
+// evictOne deletes the file expiring soonest to make room for an upload. Files sharing their
+// content with others are skipped, as deleting them frees no space. It returns false if there
+// is nothing left to delete.
+func (fs *FileStore) evictOne() bool {
+	fs.mu.RLock()
+	var victim StoredFile
+	found := false
+	for _, f := range fs.files {
+		if fs.blobs[f.Path].refs > 1 {
+			continue
+		}
+		if !found || f.ExpiresAt.Before(victim.ExpiresAt) {
+			victim, found = f, true
+		}
+	}
+	fs.mu.RUnlock()
+	if !found {
+		return false
+	}
+
+	if fs.remove(victim.ID, ReasonEvicted) {
+		metrics.EvictionsTotal.Inc()
+		slog.Warn("Evicted file to make room for an upload", "file_id", victim.ID, "size", victim.Size,
+			"expires_in", time.Until(victim.ExpiresAt).Round(time.Second))
+	}
+	return true
+}
+
+// QuotaUsage returns the bytes counted against the storage quota and against the quota of
+// owner, including uploads in progress
+func (fs *FileStore) QuotaUsage(owner string) (used, ownerUsed int64) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.diskBytes + fs.pending, fs.ownerBytes[owner] + fs.pendingOwners[owner]
+}
+
+// OwnerUsage is the storage used by one quota owner
+type OwnerUsage struct {
+	Owner string `json:"owner"`
+	Bytes int64  `json:"bytes"` // stored files and uploads in progress
+}
+
+// OwnerUsages returns the storage used by each quota owner, largest first
+func (fs *FileStore) OwnerUsages() []OwnerUsage {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	totals := make(map[string]int64, len(fs.ownerBytes))
+	for owner, bytes := range fs.ownerBytes {
+		totals[owner] += bytes
+	}
+	for owner, bytes := range fs.pendingOwners {
+		totals[owner] += bytes
+	}
+	usages := make([]OwnerUsage, 0, len(totals))
+	for owner, bytes := range totals {
+		if bytes > 0 {
+			usages = append(usages, OwnerUsage{Owner: owner, Bytes: bytes})
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Bytes != usages[j].Bytes {
+			return usages[i].Bytes > usages[j].Bytes
+		}
+		return usages[i].Owner < usages[j].Owner
+	})
+	return usages
+}
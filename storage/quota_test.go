@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *FileStore {
+	t.Helper()
+	fs := NewFileStore(t.TempDir())
+	t.Cleanup(fs.Flush)
+	return fs
+}
+
+// addFile stores content as a new file uploaded by ip, expiring after the given minutes
+func addFile(t *testing.T, fs *FileStore, id, ip, content string, expiryMinutes int) (f StoredFile, deduplicated bool) {
+	t.Helper()
+	tmpPath := filepath.Join(fs.dir, id+".tmp")
+	if err := os.WriteFile(tmpPath, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	f = StoredFile{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(content)), UploaderIP: ip}
+	deduplicated, err := fs.CommitBlob(&f, tmpPath)
+	if err != nil {
+		t.Fatalf("CommitBlob: %v", err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("the upload was left at %s", tmpPath)
+	}
+	return fs.Add(id, f, expiryMinutes), deduplicated
+}
+
+func assertExists(t *testing.T, path string, want bool) {
+	t.Helper()
+	if _, err := os.Stat(path); (err == nil) != want {
+		t.Errorf("%s exists: %v, want %v", path, err == nil, want)
+	}
+}
+
+func TestSharedBlobs(t *testing.T) {
+	fs := newTestStore(t)
+
+	a, dedupA := addFile(t, fs, "a", "10.0.0.1", "same content", 10)
+	b, dedupB := addFile(t, fs, "b", "10.0.0.2", "same content", 10)
+	c, _ := addFile(t, fs, "c", "10.0.0.1", "other", 10)
+	if dedupA || !dedupB {
+		t.Errorf("deduplicated = %v, %v, want false, true", dedupA, dedupB)
+	}
+	if a.Path != b.Path || a.Path == c.Path {
+		t.Errorf("paths = %s, %s, %s, want the first two shared", a.Path, b.Path, c.Path)
+	}
+
+	usage := fs.Stats()
+	want := Usage{Files: 3, Blobs: 2, Bytes: 17, LogicalBytes: 29, Deduplicated: 12}
+	if usage != want {
+		t.Errorf("Stats = %+v, want %+v", usage, want)
+	}
+	if used, ownerUsed := fs.QuotaUsage(a.QuotaOwner()); used != 17 || ownerUsed != 17 {
+		t.Errorf("QuotaUsage = %d, %d, want 17, 17", used, ownerUsed)
+	}
+
+	fs.Revoke("a")
+	assertExists(t, b.Path, true)
+	if used, _ := fs.QuotaUsage(""); used != 17 {
+		t.Errorf("used = %d after removing a shared file, want 17", used)
+	}
+
+	fs.Delete("b")
+	assertExists(t, b.Path, false)
+	if used, _ := fs.QuotaUsage(""); used != 5 {
+		t.Errorf("used = %d after removing the last shared file, want 5", used)
+	}
+
+	// The content can be stored again once its blob is gone
+	if _, dedup := addFile(t, fs, "d", "10.0.0.1", "same content", 10); dedup {
+		t.Errorf("deduplicated against a deleted blob")
+	}
+	if used, _ := fs.QuotaUsage(""); used != 17 {
+		t.Errorf("used = %d, want 17", used)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	fs := newTestStore(t)
+	addFile(t, fs, "a", "10.0.0.1", "0123456789", 10)
+
+	limits := Limits{Quota: 30, OwnerQuota: 15}
+	owner := QuotaOwner("", "10.0.0.1")
+
+	if _, err := fs.Reserve(owner, 6, limits); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("Reserve over the owner quota: err = %v, want ErrInsufficientStorage", err)
+	}
+	if _, err := fs.Reserve(QuotaOwner("req", ""), 21, limits); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("Reserve over the quota: err = %v, want ErrInsufficientStorage", err)
+	}
+
+	res, err := fs.Reserve(QuotaOwner("req", ""), 0, limits)
+	if err != nil {
+		t.Fatalf("Reserve of unknown size: %v", err)
+	}
+	if err := res.Grow(15); err != nil {
+		t.Fatalf("Grow: %v", err)
+	}
+	if used, _ := fs.QuotaUsage(""); used != 25 {
+		t.Errorf("used = %d with an upload in progress, want 25", used)
+	}
+	if _, err := fs.Reserve(QuotaOwner("", "10.0.0.9"), 6, limits); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("Reserve beside an upload in progress: err = %v, want ErrInsufficientStorage", err)
+	}
+	if err := res.GrowOwner(15); err != nil {
+		t.Fatalf("GrowOwner: %v", err)
+	}
+	if _, ownerUsed := fs.QuotaUsage(QuotaOwner("req", "")); ownerUsed != 15 {
+		t.Errorf("owner usage = %d with an upload in progress, want 15", ownerUsed)
+	}
+	if err := res.GrowOwner(1); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("GrowOwner over the owner quota: err = %v, want ErrInsufficientStorage", err)
+	}
+
+	if err := res.Grow(6); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("Grow over the quota: err = %v, want ErrInsufficientStorage", err)
+	}
+
+	res.Release()
+	res.Release()
+	if used, ownerUsed := fs.QuotaUsage(QuotaOwner("req", "")); used != 10 || ownerUsed != 0 {
+		t.Errorf("QuotaUsage after Release = %d, %d, want 10, 0", used, ownerUsed)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	fs := newTestStore(t)
+
+	// The shared files expire first, but deleting one of them alone frees nothing
+	shared1, _ := addFile(t, fs, "shared1", "10.0.0.1", "shared content", 1)
+	addFile(t, fs, "shared2", "10.0.0.2", "shared content", 2)
+	unique, _ := addFile(t, fs, "unique", "10.0.0.3", "unique content", 5)
+	later, _ := addFile(t, fs, "later", "10.0.0.4", "other content!", 9)
+
+	limits := Limits{Quota: 50, Evict: true}
+	res, err := fs.Reserve(QuotaOwner("", "10.0.0.5"), 20, limits)
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	res.Release()
+
+	for id, want := range map[string]bool{"shared1": true, "shared2": true, "unique": false, "later": true} {
+		if _, ok := fs.Get(id); ok != want {
+			t.Errorf("%s stored: %v, want %v", id, ok, want)
+		}
+	}
+	assertExists(t, shared1.Path, true)
+	assertExists(t, unique.Path, false)
+
+	// With only shared content left to evict, the upload is refused without deleting anything
+	fs.Revoke("later")
+	assertExists(t, later.Path, false)
+	if _, err := fs.Reserve(QuotaOwner("", "10.0.0.5"), 40, limits); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("Reserve: err = %v, want ErrInsufficientStorage", err)
+	}
+	for _, id := range []string{"shared1", "shared2"} {
+		if _, ok := fs.Get(id); !ok {
+			t.Errorf("%s was evicted although it freed no space", id)
+		}
+	}
+}
+
+func TestSetExpiryOrdersEviction(t *testing.T) {
+	fs := newTestStore(t)
+	addFile(t, fs, "a", "10.0.0.1", "aaaa", 1)
+	addFile(t, fs, "b", "10.0.0.1", "bbbb", 10)
+	fs.SetExpiry("a", time.Now().Add(time.Hour))
+
+	res, err := fs.Reserve(QuotaOwner("", "10.0.0.2"), 4, Limits{Quota: 8, Evict: true})
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	res.Release()
+	if _, ok := fs.Get("b"); ok {
+		t.Errorf("b is still stored, want it evicted as it now expires first")
+	}
+	if _, ok := fs.Get("a"); !ok {
+		t.Errorf("a was evicted")
+	}
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRequestNotFound is returned for unknown upload requests and requests whose link expired
+	ErrRequestNotFound = errors.New("upload request not found or expired")
+	// ErrRequestFull is returned once an upload request has received all its files
+	ErrRequestFull = errors.New("upload request has already received all its files")
+)
+
+// UploadRequest is a link letting someone without the upload password send files to the
+// person who created it. Its ID is public; only the holder of OwnerToken sees the files.
+// The link closes at ExpiresAt, but the owner keeps seeing the request until none of the
+// received files is stored any more.
+type UploadRequest struct {
+	ID         string
+	OwnerToken string
+	Note       string
+	MaxSizeMB  int
+	MaxFiles   int
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	Files      []StoredFile        // files received so far
+	Closed     bool                // the link expired and accepts no more uploads
+	pending    int                 // uploads in progress
+	stored     map[string]struct{} // IDs of the received files still in the file store
+}
+
+// Remaining returns how many more files the request accepts
+func (req UploadRequest) Remaining() int {
+	if req.Closed {
+		return 0
+	}
+	return req.MaxFiles - len(req.Files) - req.pending
+}
+
+// RequestStore keeps the open upload requests in memory
+type RequestStore struct {
+	mu       sync.Mutex
+	requests map[string]*UploadRequest
+	owners   map[string]string        // owner token to request ID
+	changed  map[string]chan struct{} // closed when a request receives a file
+	onClose  []func(UploadRequest)
+	onExpire []func(UploadRequest)
+}
+
+// NewRequestStore creates an empty RequestStore
+func NewRequestStore() *RequestStore {
+	return &RequestStore{
+		requests: make(map[string]*UploadRequest),
+		owners:   make(map[string]string),
+		changed:  make(map[string]chan struct{}),
+	}
+}
+
+// OnClose registers a hook called when the link of a request expires while some of its files
+// are still stored
+func (rs *RequestStore) OnClose(hook func(UploadRequest)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.onClose = append(rs.onClose, hook)
+}
+
+// OnExpire registers a hook called when a request expires, once its link closed and none of
+// its files is left
+func (rs *RequestStore) OnExpire(hook func(UploadRequest)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.onExpire = append(rs.onExpire, hook)
+}
+
+// Create registers a new request valid for the given duration. Its ID, owner token and
+// timestamps are filled in by the store.
+func (rs *RequestStore) Create(req UploadRequest, validFor time.Duration) (UploadRequest, error) {
+	id, err := GenerateID()
+	if err != nil {
+		return UploadRequest{}, err
+	}
+	token, err := GenerateID()
+	if err != nil {
+		return UploadRequest{}, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	req.ID = id
+	req.OwnerToken = token
+	req.CreatedAt = now
+	req.ExpiresAt = now.Add(validFor)
+	req.Files = nil
+	req.Closed = false
+	req.pending = 0
+	req.stored = make(map[string]struct{})
+	rs.requests[id] = &req
+	rs.owners[token] = id
+	rs.changed[id] = make(chan struct{})
+
+	time.AfterFunc(validFor, func() {
+		rs.closeLink(id)
+	})
+	return req.snapshot(), nil
+}
+
+// closeLink stops a request from accepting uploads once its link is no longer valid. The
+// request expires right away unless some of its files are still stored.
+func (rs *RequestStore) closeLink(id string) {
+	rs.mu.Lock()
+	req, ok := rs.requests[id]
+	if !ok {
+		rs.mu.Unlock()
+		return
+	}
+	req.Closed = true
+	if len(req.stored) == 0 {
+		rs.expire(req)
+		return
+	}
+	rs.notifyChange(id)
+	hooks, closed := rs.onClose, req.snapshot()
+	rs.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(closed)
+	}
+}
+
+// FileRemoved tells the store that a file received through the request with the given ID
+// was deleted. The last one to go expires the request if its link already closed.
+func (rs *RequestStore) FileRemoved(id, fileID string) {
+	rs.mu.Lock()
+	req, ok := rs.requests[id]
+	if !ok {
+		rs.mu.Unlock()
+		return
+	}
+	delete(req.stored, fileID)
+	if !req.Closed || len(req.stored) > 0 {
+		rs.mu.Unlock()
+		return
+	}
+	rs.expire(req)
+}
+
+// expire removes a request and calls the expiry hooks. It must be called with rs.mu held,
+// which it releases.
+func (rs *RequestStore) expire(req *UploadRequest) {
+	id := req.ID
+	delete(rs.requests, id)
+	delete(rs.owners, req.OwnerToken)
+	close(rs.changed[id])
+	delete(rs.changed, id)
+	hooks := rs.onExpire
+	rs.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(*req)
+	}
+}
+
+// Get returns the request with the given public ID while its link is open
+func (rs *RequestStore) Get(id string) (UploadRequest, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	req, ok := rs.requests[id]
+	if !ok || req.Closed {
+		return UploadRequest{}, false
+	}
+	return req.snapshot(), true
+}
+
+// GetByOwner returns the request belonging to an owner token, with a channel closed at its
+// next change, for long-polling
+func (rs *RequestStore) GetByOwner(token string) (UploadRequest, <-chan struct{}, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	req, ok := rs.requests[rs.owners[token]]
+	if !ok {
+		return UploadRequest{}, nil, false
+	}
+	return req.snapshot(), rs.changed[req.ID], true
+}
+
+// HasOwner reports whether token belongs to a request that has not expired
+func (rs *RequestStore) HasOwner(token string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	_, ok := rs.owners[token]
+	return ok
+}
+
+// Reserve claims one of the request's file slots for an upload about to start. The slot
+// must be handed back with Complete or Release.
+func (rs *RequestStore) Reserve(id string) (UploadRequest, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	req, ok := rs.requests[id]
+	if !ok || req.Closed {
+		return UploadRequest{}, ErrRequestNotFound
+	}
+	if req.Remaining() <= 0 {
+		return UploadRequest{}, ErrRequestFull
+	}
+	req.pending++
+	return req.snapshot(), nil
+}
+
+// Release gives back a slot whose upload failed
+func (rs *RequestStore) Release(id string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if req, ok := rs.requests[id]; ok {
+		req.pending--
+	}
+}
+
+// Complete records the file received in a reserved slot and wakes up long-polling owners.
+// It returns false if the link expired during the upload.
+func (rs *RequestStore) Complete(id string, f StoredFile) (UploadRequest, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	req, ok := rs.requests[id]
+	if !ok {
+		return UploadRequest{}, false
+	}
+	req.pending--
+	if req.Closed {
+		return UploadRequest{}, false
+	}
+	f.Downloads = nil
+	req.Files = append(req.Files, f)
+	req.stored[f.ID] = struct{}{}
+
+	rs.notifyChange(id)
+	return req.snapshot(), true
+}
+
+// notifyChange wakes up the owners long-polling a request. rs.mu must be held.
+func (rs *RequestStore) notifyChange(id string) {
+	close(rs.changed[id])
+	rs.changed[id] = make(chan struct{})
+}
+
+// snapshot copies a request so that callers never share its file slice
+func (req *UploadRequest) snapshot() UploadRequest {
+	c := *req
+	c.Files = append([]StoredFile(nil), req.Files...)
+	c.stored = nil
+	return c
+}